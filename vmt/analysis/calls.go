@@ -0,0 +1,299 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"vmt/parser"
+)
+
+// Memory the stack must stay within: it starts at StackBase and runs into
+// the heap at HeapBase and into the screen at ScreenBase.
+const (
+	StackBase  = 256
+	HeapBase   = 2048
+	ScreenBase = 16384
+)
+
+// FrameSize is the number of words a call pushes: the return address, LCL,
+// ARG, THIS and THAT.
+const FrameSize = 5
+
+// Node is a function in a call graph.
+type Node struct {
+	Name   string
+	File   string
+	Locals int
+	// Peak is the most values the function itself has on the stack above
+	// its locals, the arguments of its calls included.
+	Peak int
+	// Calls are the functions it calls, each once, in the order of the
+	// first call. Functions the program does not define have no node.
+	Calls []string
+	// Recursive is set when the function can call itself, directly or
+	// through others.
+	Recursive bool
+	// Stack is the most words a call to the function adds to the stack, its
+	// frame included, or -1 when it is unbounded because the function can
+	// reach a recursive one.
+	Stack int
+
+	sites []site
+	cycle int // number of the cycle the function is in, from 1
+}
+
+// site is a reachable call with the depth of the stack before it, the
+// arguments included.
+type site struct {
+	callee string
+	depth  int
+}
+
+// CallGraph is the call graph of a program.
+type CallGraph struct {
+	Nodes     []*Node // in the order of the files
+	Cycles    [][]string
+	Undefined []string // called functions the program does not define
+
+	byName map[string]*Node
+}
+
+/*
+Calls builds the call graph of files, finds its recursive cycles and
+estimates the stack every function needs.
+
+A function needs its locals and the most it has on the stack at any point,
+or at a call the values below the arguments, the arguments, the frame and
+what the callee needs, whichever is more. Calls to functions the program
+does not define count only their frame. Functions that can reach a cycle
+have no bound.
+*/
+func Calls(files []parser.File) *CallGraph {
+	cg := &CallGraph{byName: map[string]*Node{}}
+	for _, f := range files {
+		for _, g := range Functions(f) {
+			n := &Node{Name: g.Name(), File: f.Name + ".vm", Locals: g.Function.Arg2}
+			seen := map[string]bool{}
+			for _, b := range g.Blocks {
+				for _, c := range b.Commands {
+					if c.Type == parser.CALL && !seen[c.Arg1] {
+						seen[c.Arg1] = true
+						n.Calls = append(n.Calls, c.Arg1)
+					}
+				}
+			}
+			ignore := func(string, parser.Command, string, ...interface{}) {}
+			followStack(g, ignore, func(c parser.Command, depth int) {
+				pops, pushes := effect(c)
+				if after := depth - pops + pushes; after > n.Peak {
+					n.Peak = after
+				}
+				if c.Type == parser.CALL {
+					n.sites = append(n.sites, site{c.Arg1, depth})
+				}
+			})
+			if cg.byName[n.Name] == nil {
+				cg.byName[n.Name] = n
+				cg.Nodes = append(cg.Nodes, n)
+			}
+		}
+	}
+
+	undefined := map[string]bool{}
+	for _, n := range cg.Nodes {
+		for _, callee := range n.Calls {
+			if cg.byName[callee] == nil && !undefined[callee] {
+				undefined[callee] = true
+				cg.Undefined = append(cg.Undefined, callee)
+			}
+		}
+	}
+	sort.Strings(cg.Undefined)
+
+	// components come callees first, so the stack of every callee is known
+	// before its callers need it
+	for i, scc := range cg.components() {
+		recursive := len(scc) > 1
+		for _, callee := range scc[0].Calls {
+			recursive = recursive || callee == scc[0].Name
+		}
+		if recursive {
+			var names []string
+			for _, n := range scc {
+				n.Recursive, n.Stack, n.cycle = true, -1, i+1
+				names = append(names, n.Name)
+			}
+			sort.Strings(names)
+			cg.Cycles = append(cg.Cycles, names)
+			continue
+		}
+		cg.estimate(scc[0])
+	}
+	sort.Slice(cg.Cycles, func(i, j int) bool { return cg.Cycles[i][0] < cg.Cycles[j][0] })
+	return cg
+}
+
+// estimate sets the Stack of n from those of its callees.
+func (cg *CallGraph) estimate(n *Node) {
+	need := n.Peak
+	for _, s := range n.sites {
+		callee := FrameSize
+		if c := cg.byName[s.callee]; c != nil {
+			if c.Stack < 0 {
+				n.Stack = -1
+				return
+			}
+			callee = c.Stack
+		}
+		if s.depth+callee > need {
+			need = s.depth + callee
+		}
+	}
+	n.Stack = FrameSize + n.Locals + need
+}
+
+// components returns the strongly connected components of the graph in
+// reverse topological order, by Tarjan's algorithm.
+func (cg *CallGraph) components() [][]*Node {
+	index := map[*Node]int{}
+	low := map[*Node]int{}
+	onStack := map[*Node]bool{}
+	var stack []*Node
+	var sccs [][]*Node
+	var connect func(n *Node)
+	connect = func(n *Node) {
+		index[n], low[n] = len(index), len(index)
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, name := range n.Calls {
+			m := cg.byName[name]
+			switch {
+			case m == nil:
+			case !hasIndex(index, m):
+				connect(m)
+				if low[m] < low[n] {
+					low[n] = low[m]
+				}
+			case onStack[m] && index[m] < low[n]:
+				low[n] = index[m]
+			}
+		}
+		if low[n] == index[n] {
+			var scc []*Node
+			for {
+				m := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[m] = false
+				scc = append(scc, m)
+				if m == n {
+					break
+				}
+			}
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, n := range cg.Nodes {
+		if !hasIndex(index, n) {
+			connect(n)
+		}
+	}
+	return sccs
+}
+
+func hasIndex(index map[*Node]int, n *Node) bool {
+	_, ok := index[n]
+	return ok
+}
+
+// Lookup returns the node of the function name, or nil.
+func (cg *CallGraph) Lookup(name string) *Node {
+	return cg.byName[name]
+}
+
+/*
+Top returns the highest value the stack pointer can reach when the bootstrap
+code calls entry with the stack at StackBase, or -1 when that is unbounded
+or entry is not defined. The stack writes into the heap when Top is more
+than HeapBase.
+*/
+func (cg *CallGraph) Top(entry string) int {
+	n := cg.byName[entry]
+	if n == nil || n.Stack < 0 {
+		return -1
+	}
+	return StackBase + n.Stack
+}
+
+/*
+WriteDOT writes cg as a Graphviz digraph. Every function is labeled with
+the stack it needs; functions in cycles and the calls between them are red,
+and functions the program does not define are dashed.
+*/
+func (cg *CallGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph calls {\n")
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, n := range cg.Nodes {
+		stack := "unbounded"
+		if n.Stack >= 0 {
+			stack = fmt.Sprintf("%d words", n.Stack)
+		}
+		attr := ""
+		if n.Recursive {
+			attr = ", color=red"
+		}
+		fmt.Fprintf(&b, "\t\"%s\" [label=\"%s\\nstack %s\"%s];\n", dotEscaper.Replace(n.Name), dotEscaper.Replace(n.Name), stack, attr)
+	}
+	for _, name := range cg.Undefined {
+		fmt.Fprintf(&b, "\t\"%s\" [style=dashed];\n", dotEscaper.Replace(name))
+	}
+	for _, n := range cg.Nodes {
+		for _, callee := range n.Calls {
+			attr := ""
+			if c := cg.byName[callee]; c != nil && n.cycle != 0 && c.cycle == n.cycle {
+				attr = " [color=red]"
+			}
+			fmt.Fprintf(&b, "\t\"%s\" -> \"%s\"%s;\n", dotEscaper.Replace(n.Name), dotEscaper.Replace(callee), attr)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes cg as JSON, with the Stack of unbounded functions null.
+func (cg *CallGraph) WriteJSON(w io.Writer) error {
+	type node struct {
+		Name      string   `json:"name"`
+		File      string   `json:"file"`
+		Locals    int      `json:"locals"`
+		Peak      int      `json:"peak"`
+		Stack     *int     `json:"stack"`
+		Recursive bool     `json:"recursive"`
+		Calls     []string `json:"calls"`
+	}
+	out := struct {
+		Functions []node     `json:"functions"`
+		Cycles    [][]string `json:"cycles"`
+		Undefined []string   `json:"undefined"`
+	}{Functions: []node{}, Cycles: [][]string{}, Undefined: []string{}}
+	for _, n := range cg.Nodes {
+		j := node{Name: n.Name, File: n.File, Locals: n.Locals, Peak: n.Peak, Recursive: n.Recursive, Calls: []string{}}
+		if n.Stack >= 0 {
+			stack := n.Stack
+			j.Stack = &stack
+		}
+		j.Calls = append(j.Calls, n.Calls...)
+		out.Functions = append(out.Functions, j)
+	}
+	out.Cycles = append(out.Cycles, cg.Cycles...)
+	out.Undefined = append(out.Undefined, cg.Undefined...)
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,139 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/parser"
+)
+
+const program = `function Sys.init 0
+push constant 1
+call Main.a 1
+pop temp 0
+label END
+goto END
+function Main.a 2
+push argument 0
+push constant 2
+call Main.b 2
+push constant 3
+add
+return
+function Main.b 0
+push argument 0
+push argument 1
+add
+return
+function Main.r 0
+call Main.r 0
+return
+function Main.x 0
+call Main.r 0
+return
+function Main.p 0
+call Main.q 0
+return
+function Main.q 0
+call Main.p 0
+return
+function Main.y 0
+push constant 1
+call Output.print 1
+return
+`
+
+func TestCalls(t *testing.T) {
+	cg := Calls([]parser.File{file(t, program)})
+	tests := []struct {
+		name      string
+		peak      int
+		stack     int
+		recursive bool
+	}{
+		{"Main.b", 2, 7, false},
+		{"Main.a", 2, 5 + 2 + 2 + 7, false},
+		{"Sys.init", 1, 5 + 0 + 1 + 16, false},
+		{"Main.r", 1, -1, true},
+		{"Main.x", 1, -1, false},
+		{"Main.p", 1, -1, true},
+		{"Main.y", 1, 5 + 0 + 1 + FrameSize, false},
+	}
+	for _, tt := range tests {
+		n := cg.Lookup(tt.name)
+		if n == nil {
+			t.Errorf("Lookup(%s) = nil", tt.name)
+			continue
+		}
+		if n.Peak != tt.peak || n.Stack != tt.stack || n.Recursive != tt.recursive {
+			t.Errorf("%s: Peak, Stack, Recursive = %d, %d, %v, want %d, %d, %v",
+				tt.name, n.Peak, n.Stack, n.Recursive, tt.peak, tt.stack, tt.recursive)
+		}
+	}
+	if want := [][]string{{"Main.p", "Main.q"}, {"Main.r"}}; !reflect.DeepEqual(cg.Cycles, want) {
+		t.Errorf("Cycles = %v, want %v", cg.Cycles, want)
+	}
+	if want := []string{"Output.print"}; !reflect.DeepEqual(cg.Undefined, want) {
+		t.Errorf("Undefined = %v, want %v", cg.Undefined, want)
+	}
+	if top := cg.Top("Sys.init"); top != StackBase+22 {
+		t.Errorf("Top(Sys.init) = %d, want %d", top, StackBase+22)
+	}
+	if top := cg.Top("Main.x"); top != -1 {
+		t.Errorf("Top(Main.x) = %d, want -1", top)
+	}
+	if top := cg.Top("Main.none"); top != -1 {
+		t.Errorf("Top(Main.none) = %d, want -1", top)
+	}
+}
+
+func TestCallGraph_WriteDOT(t *testing.T) {
+	cg := Calls([]parser.File{file(t, program)})
+	b := bytes.NewBufferString("")
+	if err := cg.WriteDOT(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"Sys.init" [label="Sys.init\nstack 22 words"];`,
+		`"Main.r" [label="Main.r\nstack unbounded", color=red];`,
+		`"Output.print" [style=dashed];`,
+		`"Sys.init" -> "Main.a";`,
+		`"Main.p" -> "Main.q" [color=red];`,
+		`"Main.x" -> "Main.r";`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("WriteDOT() =\n%s\nwithout %s", b, s)
+		}
+	}
+}
+
+func TestCallGraph_WriteJSON(t *testing.T) {
+	cg := Calls([]parser.File{file(t, program)})
+	b := bytes.NewBufferString("")
+	if err := cg.WriteJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Functions []struct {
+			Name  string
+			Stack *int
+			Calls []string
+		}
+		Cycles    [][]string
+		Undefined []string
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Functions) != 8 || got.Functions[0].Name != "Sys.init" || *got.Functions[0].Stack != 22 {
+		t.Errorf("functions = %+v", got.Functions)
+	}
+	if got.Functions[3].Name != "Main.r" || got.Functions[3].Stack != nil {
+		t.Errorf("Main.r = %+v, want no stack", got.Functions[3])
+	}
+	if len(got.Cycles) != 2 || len(got.Undefined) != 1 {
+		t.Errorf("cycles = %v, undefined = %v", got.Cycles, got.Undefined)
+	}
+}
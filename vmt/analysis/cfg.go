@@ -0,0 +1,189 @@
+/*
+Package analysis finds properties of VM functions from their control flow.
+
+Functions builds the control flow graph of every function of a file, whose
+basic blocks end at labels, jumps, calls and returns. CheckStack follows the
+depth of the stack through those graphs and reports code that would corrupt
+the frame at run time.
+*/
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"vmt/parser"
+)
+
+// Block is a basic block: commands that control enters only at the first
+// and leaves only after the last.
+type Block struct {
+	Index    int // in CFG.Blocks
+	Commands []parser.Command
+
+	// Succs are the blocks control can go to next: the block after this one
+	// unless it ends in goto or return, then the target of a jump that ends
+	// it. Preds are the blocks that have this one as a successor.
+	Succs []*Block
+	Preds []*Block
+}
+
+// Label returns the label b starts with, or "".
+func (b *Block) Label() string {
+	if len(b.Commands) > 0 && b.Commands[0].Type == parser.LABEL {
+		return b.Commands[0].Arg1
+	}
+	return ""
+}
+
+// last returns the last command of b, or a command without type when b is
+// empty.
+func (b *Block) last() parser.Command {
+	if len(b.Commands) == 0 {
+		return parser.Command{}
+	}
+	return b.Commands[len(b.Commands)-1]
+}
+
+// CFG is the control flow graph of a function. Blocks are in the order of
+// the code and the first is the entry.
+type CFG struct {
+	Function parser.Command
+	Blocks   []*Block
+}
+
+// Name returns the name of the function.
+func (g *CFG) Name() string { return g.Function.Arg1 }
+
+/*
+Build returns the control flow graph of the function fn with the commands
+up to the next function.
+
+A block starts at the first command, at every label and after every goto,
+if-goto, call and return. Jumps to labels that are not defined lead nowhere.
+*/
+func Build(fn parser.Command, commands []parser.Command) *CFG {
+	g := &CFG{Function: fn}
+	labels := map[string]*Block{}
+	cur := &Block{}
+	end := func() {
+		if len(cur.Commands) > 0 {
+			cur.Index = len(g.Blocks)
+			g.Blocks = append(g.Blocks, cur)
+			cur = &Block{}
+		}
+	}
+	for _, c := range commands {
+		if c.Type == parser.LABEL {
+			end()
+			labels[c.Arg1] = cur
+		}
+		cur.Commands = append(cur.Commands, c)
+		switch c.Type {
+		case parser.GOTO, parser.IF, parser.CALL, parser.RETURN:
+			end()
+		}
+	}
+	end()
+	if len(g.Blocks) == 0 {
+		g.Blocks = []*Block{cur}
+	}
+
+	for i, b := range g.Blocks {
+		last := b.last()
+		if last.Type != parser.GOTO && last.Type != parser.RETURN && i+1 < len(g.Blocks) {
+			link(b, g.Blocks[i+1])
+		}
+		if last.Type == parser.GOTO || last.Type == parser.IF {
+			if target, ok := labels[last.Arg1]; ok {
+				link(b, target)
+			}
+		}
+	}
+	return g
+}
+
+// link adds the edge from b to s once.
+func link(b, s *Block) {
+	for _, t := range b.Succs {
+		if t == s {
+			return
+		}
+	}
+	b.Succs = append(b.Succs, s)
+	s.Preds = append(s.Preds, b)
+}
+
+// Functions returns the control flow graphs of the functions of f in order.
+// Commands before the first function belong to none and are left out.
+func Functions(f parser.File) []*CFG {
+	var gs []*CFG
+	start := -1
+	for i, c := range f.Commands {
+		if c.Type != parser.FUNCTION {
+			continue
+		}
+		if start >= 0 {
+			gs = append(gs, Build(f.Commands[start], f.Commands[start+1:i]))
+		}
+		start = i
+	}
+	if start >= 0 {
+		gs = append(gs, Build(f.Commands[start], f.Commands[start+1:]))
+	}
+	return gs
+}
+
+// Find returns the control flow graph of the function name in files, or nil
+// when no file defines it.
+func Find(files []parser.File, name string) *CFG {
+	for _, f := range files {
+		for _, g := range Functions(f) {
+			if g.Name() == name {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+/*
+WriteDOT writes g as a Graphviz digraph. Every block is a box listing its
+commands, the entry box starting with the function command. The edges of a
+block ending in if-goto are labeled true for the jump and false for the
+command after it.
+*/
+func (g *CFG) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", g.Name())
+	b.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, blk := range g.Blocks {
+		var lines []string
+		if blk.Index == 0 {
+			lines = append(lines, dotEscaper.Replace(g.Function.String()))
+		}
+		for _, c := range blk.Commands {
+			lines = append(lines, dotEscaper.Replace(c.String()))
+		}
+		// \l ends a left-aligned line
+		fmt.Fprintf(&b, "\tb%d [label=\"%s\\l\"];\n", blk.Index, strings.Join(lines, "\\l"))
+	}
+	for _, blk := range g.Blocks {
+		last := blk.last()
+		for _, s := range blk.Succs {
+			attr := ""
+			if last.Type == parser.IF && len(blk.Succs) == 2 {
+				attr = " [label=\"false\"]"
+				if s.Label() == last.Arg1 {
+					attr = " [label=\"true\"]"
+				}
+			}
+			fmt.Fprintf(&b, "\tb%d -> b%d%s;\n", blk.Index, s.Index, attr)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
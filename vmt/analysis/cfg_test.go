@@ -0,0 +1,154 @@
+package analysis
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"vmt/parser"
+)
+
+const loop = `function Main.main 1
+push constant 0
+pop local 0
+label LOOP
+push local 0
+push constant 10
+lt
+not
+if-goto END
+push local 0
+call Output.printInt 1
+pop temp 0
+goto LOOP
+label END
+push constant 0
+return
+`
+
+// edges returns the successors and predecessors of every block by index.
+func edges(g *CFG) (succs, preds [][]int) {
+	for _, b := range g.Blocks {
+		var s, p []int
+		for _, t := range b.Succs {
+			s = append(s, t.Index)
+		}
+		for _, t := range b.Preds {
+			p = append(p, t.Index)
+		}
+		succs, preds = append(succs, s), append(preds, p)
+	}
+	return succs, preds
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		starts []int // line of the first command of every block
+		succs  [][]int
+		preds  [][]int
+	}{
+		{
+			"loop",
+			loop,
+			[]int{2, 4, 10, 12, 14},
+			[][]int{{1}, {2, 4}, {3}, {1}, nil},
+			[][]int{nil, {0, 3}, {1}, {2}, {1}},
+		},
+		{
+			"empty function",
+			"function Main.f 0\n",
+			[]int{0},
+			[][]int{nil},
+			[][]int{nil},
+		},
+		{
+			"jump to the next block and to nowhere",
+			"function Main.f 0\nif-goto NEXT\nlabel NEXT\ngoto NOWHERE\nreturn\n",
+			[]int{2, 3, 5},
+			[][]int{{1}, nil, nil},
+			[][]int{nil, {0}, nil},
+		},
+		{
+			"label at the start",
+			"function Main.f 0\nlabel TOP\ngoto TOP\n",
+			[]int{2},
+			[][]int{{0}},
+			[][]int{{0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := Functions(file(t, tt.src))
+			if len(gs) != 1 {
+				t.Fatalf("Functions() = %d graphs, want 1", len(gs))
+			}
+			g := gs[0]
+			var starts []int
+			for i, b := range g.Blocks {
+				if b.Index != i {
+					t.Errorf("block %d has Index %d", i, b.Index)
+				}
+				line := 0
+				if len(b.Commands) > 0 {
+					line = b.Commands[0].Line
+				}
+				starts = append(starts, line)
+			}
+			if !reflect.DeepEqual(starts, tt.starts) {
+				t.Errorf("blocks start at lines %v, want %v", starts, tt.starts)
+			}
+			succs, preds := edges(g)
+			if !reflect.DeepEqual(succs, tt.succs) {
+				t.Errorf("Succs = %v, want %v", succs, tt.succs)
+			}
+			if !reflect.DeepEqual(preds, tt.preds) {
+				t.Errorf("Preds = %v, want %v", preds, tt.preds)
+			}
+		})
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	f := file(t, "push constant 1\nfunction Main.a 0\npush constant 0\nreturn\nfunction Main.b 2\nlabel X\ngoto X\n")
+	var names []string
+	for _, g := range Functions(f) {
+		names = append(names, g.Name())
+	}
+	if want := []string{"Main.a", "Main.b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Functions() = %v, want %v", names, want)
+	}
+
+	files := []parser.File{file(t, "function Main.a 0\nreturn\n"), f}
+	if g := Find(files, "Main.b"); g == nil || g.Function.Arg2 != 2 || g.Blocks[0].Label() != "X" {
+		t.Errorf("Find(Main.b) = %+v", g)
+	}
+	if g := Find(files, "Main.c"); g != nil {
+		t.Errorf("Find(Main.c) = %+v, want nil", g)
+	}
+}
+
+func TestCFG_WriteDOT(t *testing.T) {
+	g := Functions(file(t, loop))[0]
+	b := bytes.NewBufferString("")
+	if err := g.WriteDOT(b); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph "Main.main" {
+	node [shape=box, fontname="monospace"];
+	b0 [label="function Main.main 1\lpush constant 0\lpop local 0\l"];
+	b1 [label="label LOOP\lpush local 0\lpush constant 10\llt\lnot\lif-goto END\l"];
+	b2 [label="push local 0\lcall Output.printInt 1\l"];
+	b3 [label="pop temp 0\lgoto LOOP\l"];
+	b4 [label="label END\lpush constant 0\lreturn\l"];
+	b0 -> b1;
+	b1 -> b2 [label="false"];
+	b1 -> b4 [label="true"];
+	b2 -> b3;
+	b3 -> b1;
+}
+`
+	if b.String() != want {
+		t.Errorf("WriteDOT() =\n%s\nwant\n%s", b, want)
+	}
+}
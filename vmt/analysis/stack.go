@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"fmt"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+// effect returns how many values c takes from the stack and how many it
+// puts back.
+func effect(c parser.Command) (pops, pushes int) {
+	switch c.Type {
+	case parser.PUSH:
+		return 0, 1
+	case parser.POP, parser.IF:
+		return 1, 0
+	case parser.ARITHMETIC:
+		if c.Arg1 == "neg" || c.Arg1 == "not" {
+			return 1, 1
+		}
+		return 2, 1
+	case parser.CALL:
+		return c.Arg2, 1
+	case parser.RETURN:
+		return 1, 0
+	}
+	return 0, 0
+}
+
+/*
+CheckStack follows the depth of the stack, the values above the locals of
+the frame, through every function of f and reports commands that would take
+values from below the frame, labels that are reached with different depths,
+which leave the depth after them depending on the path taken, and returns
+with anything but the one return value on the stack.
+
+Code that cannot be reached from the start of its function is not checked,
+and neither are commands before the first function, which have no frame.
+*/
+func CheckStack(f parser.File) diag.List {
+	var l diag.List
+	path := f.Path
+	if path == "" {
+		path = f.Name + ".vm"
+	}
+	for _, g := range Functions(f) {
+		report := func(rule string, c parser.Command, format string, args ...interface{}) {
+			l = append(l, diag.Errorf(rule, format, args...).At(path, c.Line, 0))
+		}
+		followStack(g, report, nil)
+	}
+	return l
+}
+
+// reportFunc reports a problem with the stack at command c.
+type reportFunc func(rule string, c parser.Command, format string, args ...interface{})
+
+// followStack follows the depth of the stack through g, calling visit, if
+// not nil, with every reachable command and the depth before it.
+func followStack(g *CFG, report reportFunc, visit func(c parser.Command, depth int)) {
+	depth := make([]int, len(g.Blocks)) // at the start of each block
+	seen := make([]bool, len(g.Blocks))
+	merged := make([]bool, len(g.Blocks)) // reported a mismatch
+	seen[0] = true
+	work := []*Block{g.Blocks[0]}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		d, ok := depth[b.Index], true
+		for _, c := range b.Commands {
+			if visit != nil {
+				visit(c, d)
+			}
+			pops, pushes := effect(c)
+			if c.Type == parser.RETURN {
+				if d != 1 {
+					report("stack-return", c, "%s returns with %s on the stack, want 1", g.Name(), values(d))
+				}
+				break
+			}
+			if d < pops {
+				report("stack-underflow", c, "%s takes %s from a stack that holds %d in %s", c, values(pops), d, g.Name())
+				ok = false
+				break
+			}
+			d += pushes - pops
+		}
+		if !ok {
+			continue
+		}
+		for _, s := range b.Succs {
+			switch {
+			case !seen[s.Index]:
+				seen[s.Index], depth[s.Index] = true, d
+				work = append(work, s)
+			case depth[s.Index] != d && !merged[s.Index]:
+				merged[s.Index] = true
+				c := s.Commands[0]
+				report("stack-mismatch", c, "%s is reached with %s and with %s on the stack", c, values(depth[s.Index]), values(d))
+			}
+		}
+	}
+}
+
+func values(n int) string {
+	if n == 1 {
+		return "1 value"
+	}
+	return fmt.Sprintf("%d values", n)
+}
@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/diag"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+func file(t *testing.T, src string) parser.File {
+	t.Helper()
+	cmds, err := parser.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parser.File{Name: "Main", Commands: cmds}
+}
+
+func rules(l diag.List) []string {
+	var out []string
+	for _, d := range l {
+		out = append(out, fmt.Sprintf("%s:%d", d.Rule, d.Line))
+	}
+	return out
+}
+
+func TestCheckStack(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			"balanced",
+			"function Main.f 1\npush argument 0\npush local 0\nadd\nneg\ncall Main.g 1\nreturn\n",
+			nil,
+		},
+		{
+			"underflow",
+			"function Main.f 0\npush constant 1\nadd\npush constant 0\nreturn\n",
+			[]string{"stack-underflow:3"},
+		},
+		{
+			"call takes its arguments",
+			"function Main.f 0\npush constant 1\ncall Main.g 2\nreturn\n",
+			[]string{"stack-underflow:3"},
+		},
+		{
+			"return without a value",
+			"function Main.f 0\nreturn\n",
+			[]string{"stack-return:2"},
+		},
+		{
+			"return with two values",
+			"function Main.f 0\npush constant 1\npush constant 2\nreturn\n",
+			[]string{"stack-return:4"},
+		},
+		{
+			"if-goto takes the condition",
+			"function Main.f 0\npush constant 1\nif-goto A\npush constant 2\nreturn\nlabel A\npush constant 3\nreturn\n",
+			nil,
+		},
+		{
+			"branches merge with different depths",
+			"function Main.f 0\npush constant 1\nif-goto A\npush constant 2\nlabel A\npush constant 3\nreturn\n",
+			[]string{"stack-mismatch:5"},
+		},
+		{
+			"loop that grows the stack",
+			"function Main.f 0\nlabel LOOP\npush constant 1\ngoto LOOP\n",
+			[]string{"stack-mismatch:2"},
+		},
+		{
+			"balanced loop",
+			"function Main.f 0\npush constant 0\nlabel LOOP\npush constant 1\nadd\npush constant 1\nif-goto LOOP\nreturn\n",
+			nil,
+		},
+		{
+			"unreachable code is not checked",
+			"function Main.f 0\npush constant 0\nreturn\nadd\nlabel DEAD\nreturn\n",
+			nil,
+		},
+		{
+			"functions are checked on their own",
+			"push constant 1\nfunction Main.f 0\npop temp 0\npush constant 0\nreturn\nfunction Main.g 0\npush constant 0\nreturn\n",
+			[]string{"stack-underflow:3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := CheckStack(file(t, tt.src))
+			if got := rules(l); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckStack() = %v, want %v", got, tt.want)
+			}
+			for _, d := range l {
+				if d.File != "Main.vm" || d.Severity != diag.Error || diag.Rules[d.Rule] == "" {
+					t.Errorf("diagnostic %+v", d)
+				}
+			}
+		})
+	}
+}
+
+// Generated programs keep the stack balanced, so nothing may be reported.
+func TestCheckStack_generated(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		for _, f := range generator.Generate(seed, generator.DefaultConfig) {
+			if l := CheckStack(f); len(l) > 0 {
+				t.Fatalf("seed %d: CheckStack(%s) = %v", seed, f.Name, l)
+			}
+		}
+	}
+}
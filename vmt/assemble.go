@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"vmt/rom"
+)
+
+func assemble(args []string) error {
+	var o options
+	var format string
+	fs := newFlagSet("assemble", "[flags] {asm file | vm file, directory, glob pattern or - ...}")
+	o.register(fs)
+	o.registerOutput(fs)
+	usage := "ROM image format:"
+	for _, f := range rom.Formats {
+		usage += fmt.Sprintf("\n%-8s %s", f.Name, f.Doc)
+	}
+	fs.StringVar(&format, "format", "hack", usage)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	f := rom.Lookup(format)
+	if f == nil {
+		return usageErrorf("unknown ROM image format %q", format)
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+
+	name, err := o.outputName(inputs, f.Ext)
+	if err != nil {
+		return err
+	}
+	code, err := o.load(inputs)
+	if err != nil {
+		return err
+	}
+	image := bytes.NewBufferString("")
+	if err := f.Write(image, code); err != nil {
+		return err
+	}
+	if err := writeOutput(name, image); err != nil {
+		return err
+	}
+	if name != stdio {
+		log.Printf("assembled %d instructions: %s", len(code), name)
+	}
+	return nil
+}
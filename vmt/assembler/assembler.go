@@ -0,0 +1,302 @@
+/*
+Package assembler translates Hack assembly into Hack machine code.
+
+It follows the nand2tetris assembler: labels are collected in a first pass,
+and every other symbol that is not predefined becomes a variable allocated
+from RAM 16 in order of first appearance.
+*/
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var predefined = map[string]uint16{
+	"SP":     0,
+	"LCL":    1,
+	"ARG":    2,
+	"THIS":   3,
+	"THAT":   4,
+	"SCREEN": 16384,
+	"KBD":    24576,
+}
+
+func init() {
+	for i := 0; i < 16; i++ {
+		predefined[fmt.Sprintf("R%d", i)] = uint16(i)
+	}
+}
+
+// Predefined returns the address of the predefined symbol sym, such as SP,
+// R13 or SCREEN.
+func Predefined(sym string) (uint16, bool) {
+	a, ok := predefined[sym]
+	return a, ok
+}
+
+// Comp maps the comp field of a C-instruction to its a and c bits.
+var Comp = map[string]uint16{
+	"0":   0x2A,
+	"1":   0x3F,
+	"-1":  0x3A,
+	"D":   0x0C,
+	"A":   0x30,
+	"!D":  0x0D,
+	"!A":  0x31,
+	"-D":  0x0F,
+	"-A":  0x33,
+	"D+1": 0x1F,
+	"A+1": 0x37,
+	"D-1": 0x0E,
+	"A-1": 0x32,
+	"D+A": 0x02,
+	"D-A": 0x13,
+	"A-D": 0x07,
+	"D&A": 0x00,
+	"D|A": 0x15,
+	"M":   0x70,
+	"!M":  0x71,
+	"-M":  0x73,
+	"M+1": 0x77,
+	"M-1": 0x72,
+	"D+M": 0x42,
+	"D-M": 0x53,
+	"M-D": 0x47,
+	"D&M": 0x40,
+	"D|M": 0x55,
+}
+
+// commutative spellings accepted in addition to the canonical ones
+var compAlias = map[string]string{
+	"A+D": "D+A",
+	"M+D": "D+M",
+	"A&D": "D&A",
+	"M&D": "D&M",
+	"A|D": "D|A",
+	"M|D": "D|M",
+}
+
+// Jump lists the jump mnemonics by their j bits.
+var Jump = []string{"", "JGT", "JEQ", "JGE", "JLT", "JNE", "JLE", "JMP"}
+
+// Error is an assembly error at a line of the source.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type line struct {
+	n    int
+	text string
+}
+
+// Symbol is a label or a variable and its address.
+type Symbol struct {
+	Name    string
+	Address uint16
+}
+
+// Program is machine code together with the symbols of its assembly.
+type Program struct {
+	Code      []uint16
+	Labels    []Symbol // in the order they are defined
+	Variables []Symbol // in the order they are allocated, from RAM 16
+	// Refs holds the symbol every A-instruction was written with, by ROM
+	// address; "" for numbers and C-instructions.
+	Refs []string
+}
+
+// Assemble reads Hack assembly from r and returns the machine code.
+func Assemble(r io.Reader) ([]uint16, error) {
+	p, err := AssembleProgram(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.Code, nil
+}
+
+// AssembleProgram is Assemble that also returns the symbols of the program.
+func AssembleProgram(r io.Reader) (*Program, error) {
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{}
+
+	// first pass: labels
+	symbols := map[string]uint16{}
+	for k, v := range predefined {
+		symbols[k] = v
+	}
+	rom := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l.text, "(") {
+			if !strings.HasSuffix(l.text, ")") || len(l.text) < 3 {
+				return nil, &Error{l.n, fmt.Sprintf("invalid label %q", l.text)}
+			}
+			label := l.text[1 : len(l.text)-1]
+			if _, ok := symbols[label]; ok {
+				return nil, &Error{l.n, fmt.Sprintf("label %s defined twice", label)}
+			}
+			symbols[label] = uint16(rom)
+			p.Labels = append(p.Labels, Symbol{label, uint16(rom)})
+			continue
+		}
+		rom++
+	}
+
+	// second pass: instructions
+	code := make([]uint16, 0, rom)
+	next := uint16(16)
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l.text, "("):
+		case strings.HasPrefix(l.text, "@"):
+			allocated := next
+			v, err := address(l.text[1:], symbols, &next)
+			if err != nil {
+				return nil, &Error{l.n, err.Error()}
+			}
+			if next != allocated {
+				p.Variables = append(p.Variables, Symbol{l.text[1:], v})
+			}
+			ref := l.text[1:]
+			if ref[0] >= '0' && ref[0] <= '9' {
+				ref = ""
+			}
+			p.Refs = append(p.Refs, ref)
+			code = append(code, v)
+		default:
+			v, err := Encode(l.text)
+			if err != nil {
+				return nil, &Error{l.n, err.Error()}
+			}
+			p.Refs = append(p.Refs, "")
+			code = append(code, v)
+		}
+	}
+	p.Code = code
+	return p, nil
+}
+
+func readLines(r io.Reader) ([]line, error) {
+	var lines []line
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		text := s.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.Join(strings.Fields(text), "")
+		if text != "" {
+			lines = append(lines, line{n, text})
+		}
+	}
+	return lines, s.Err()
+}
+
+func address(sym string, symbols map[string]uint16, next *uint16) (uint16, error) {
+	if sym == "" {
+		return 0, fmt.Errorf("missing address")
+	}
+	if sym[0] >= '0' && sym[0] <= '9' {
+		v, err := strconv.Atoi(sym)
+		if err != nil || v > 32767 {
+			return 0, fmt.Errorf("invalid address %q", sym)
+		}
+		return uint16(v), nil
+	}
+	if v, ok := symbols[sym]; ok {
+		return v, nil
+	}
+	symbols[sym] = *next
+	*next++
+	return symbols[sym], nil
+}
+
+// Encode returns the machine code of a C-instruction such as "AM=M-1" or
+// "D;JGT".
+func Encode(inst string) (uint16, error) {
+	dest, comp, jump := "", inst, ""
+	if i := strings.Index(comp, "="); i >= 0 {
+		dest, comp = comp[:i], comp[i+1:]
+	}
+	if i := strings.Index(comp, ";"); i >= 0 {
+		comp, jump = comp[:i], comp[i+1:]
+	}
+	if alias, ok := compAlias[comp]; ok {
+		comp = alias
+	}
+	c, ok := Comp[comp]
+	if !ok {
+		return 0, fmt.Errorf("invalid comp %q in %q", comp, inst)
+	}
+
+	var d uint16
+	for _, r := range dest {
+		var bit uint16
+		switch r {
+		case 'A':
+			bit = 4
+		case 'D':
+			bit = 2
+		case 'M':
+			bit = 1
+		}
+		if bit == 0 || d&bit != 0 {
+			return 0, fmt.Errorf("invalid dest %q in %q", dest, inst)
+		}
+		d |= bit
+	}
+
+	j := -1
+	for i, m := range Jump {
+		if m == jump {
+			j = i
+		}
+	}
+	if j < 0 {
+		return 0, fmt.Errorf("invalid jump %q in %q", jump, inst)
+	}
+	return 0xE000 | c<<6 | d<<3 | uint16(j), nil
+}
+
+// WriteHack writes code in the textual .hack format, one 16-digit binary
+// word per line.
+func WriteHack(w io.Writer, code []uint16) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range code {
+		fmt.Fprintf(bw, "%016b\n", c)
+	}
+	return bw.Flush()
+}
+
+// ReadHack reads code in the textual .hack format.
+func ReadHack(r io.Reader) ([]uint16, error) {
+	var code []uint16
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(text, 2, 16)
+		if err != nil || len(text) != 16 {
+			return nil, &Error{n, fmt.Sprintf("invalid machine word %q", text)}
+		}
+		code = append(code, uint16(v))
+	}
+	return code, s.Err()
+}
@@ -0,0 +1,157 @@
+package assembler
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		inst    string
+		want    string
+		wantErr bool
+	}{
+		{"D=A", "D=A", "1110110000010000", false},
+		{"M=D", "M=D", "1110001100001000", false},
+		{"AM=M-1", "AM=M-1", "1111110010101000", false},
+		{"D;JGT", "D;JGT", "1110001100000001", false},
+		{"0;JMP", "0;JMP", "1110101010000111", false},
+		{"M=D+M", "M=D+M", "1111000010001000", false},
+		{"commuted", "M=M+D", "1111000010001000", false},
+		{"AMD=!A;JLE", "AMD=!A;JLE", "1110110001111110", false},
+		{"invalid comp", "D=X", "", true},
+		{"invalid dest", "X=D", "", true},
+		{"repeated dest", "DD=A", "", true},
+		{"invalid jump", "D;JXX", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.inst)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Encode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				b := bytes.NewBufferString("")
+				WriteHack(b, []uint16{got})
+				if strings.TrimSpace(b.String()) != tt.want {
+					t.Errorf("Encode() = %s, want %s", b, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	src := `
+// labels, variables and predefined symbols
+@i      // first variable: RAM[16]
+M=1
+(LOOP)
+@i
+D=M
+@100
+D=D-A
+@END    // label defined later
+D;JGT
+@sum    // second variable: RAM[17]
+M=D+M
+@R13
+M=D
+@SCREEN
+M=-1
+@LOOP
+0;JMP
+(END)
+@END
+0;JMP
+`
+	got, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+	want := []uint16{
+		16, 0xEFC8,
+		16, 0xFC10, 100, 0xE4D0, 16, 0xE301,
+		17, 0xF088, 13, 0xE308, 16384, 0xEE88, 2, 0xEA87,
+		16, 0xEA87,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Assemble() = %v, want %v", got, want)
+	}
+}
+
+func TestAssembleProgram(t *testing.T) {
+	src := `
+@i
+M=1
+(LOOP)
+@sum
+M=D
+@LOOP
+0;JMP
+(END)
+@R13
+@i
+@5
+`
+	p, err := AssembleProgram(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("AssembleProgram() error = %v", err)
+	}
+	if want := []Symbol{{"LOOP", 2}, {"END", 6}}; !reflect.DeepEqual(p.Labels, want) {
+		t.Errorf("Labels = %v, want %v", p.Labels, want)
+	}
+	if want := []Symbol{{"i", 16}, {"sum", 17}}; !reflect.DeepEqual(p.Variables, want) {
+		t.Errorf("Variables = %v, want %v", p.Variables, want)
+	}
+	if want := []string{"i", "", "sum", "", "LOOP", "", "R13", "i", ""}; !reflect.DeepEqual(p.Refs, want) {
+		t.Errorf("Refs = %q, want %q", p.Refs, want)
+	}
+	if want := []uint16{16, 0xEFC8, 17, 0xE308, 2, 0xEA87, 13, 16, 5}; !reflect.DeepEqual(p.Code, want) {
+		t.Errorf("Code = %v, want %v", p.Code, want)
+	}
+}
+
+func TestAssemble_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+	}{
+		{"duplicate label", "(A)\n@1\n(A)\n", 3},
+		{"invalid instruction", "@1\nD=Q\n", 2},
+		{"address too large", "@32768\n", 1},
+		{"unterminated label", "(LOOP\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(strings.NewReader(tt.src))
+			e, ok := err.(*Error)
+			if !ok || e.Line != tt.line {
+				t.Errorf("Assemble() error = %v, want error on line %d", err, tt.line)
+			}
+		})
+	}
+}
+
+func TestReadHack(t *testing.T) {
+	code := []uint16{0, 0x7FFF, 0xEA87, 0xFFFF}
+	b := bytes.NewBufferString("")
+	if err := WriteHack(b, code); err != nil {
+		t.Fatalf("WriteHack() error = %v", err)
+	}
+	got, err := ReadHack(b)
+	if err != nil {
+		t.Fatalf("ReadHack() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, code) {
+		t.Errorf("ReadHack() = %v, want %v", got, code)
+	}
+	if _, err := ReadHack(strings.NewReader("0101\n")); err == nil {
+		t.Errorf("ReadHack() accepted a short word")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"vmt/diag"
+	"vmt/project"
+)
+
+func batch(args []string) error {
+	var o options
+	fs := newFlagSet("batch", "[flags] [directory ...]")
+	o.register(fs)
+	workers := fs.Int("j", runtime.NumCPU(), "number of programs translated at the same time")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *workers < 1 {
+		return usageErrorf("-j must be at least 1, not %d", *workers)
+	}
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var programs []program
+	for _, root := range roots {
+		found, err := findPrograms(root)
+		if err != nil {
+			return err
+		}
+		programs = append(programs, found...)
+	}
+	if len(programs) == 0 {
+		return &os.PathError{Op: "batch", Path: strings.Join(roots, ", "), Err: errors.New("no .vm files")}
+	}
+
+	results := translateAll(programs, o, setFlags(fs), *workers)
+	writeSummary(os.Stdout, results)
+	return failures(results).Err()
+}
+
+// failures returns the diagnostics of the programs that failed. Those
+// without a file are put at the directory of their program.
+func failures(results []result) diag.List {
+	var l diag.List
+	for _, r := range results {
+		for _, d := range diag.From(r.err) {
+			if d.File == "" {
+				d.File = r.dir
+			}
+			l = append(l, d)
+		}
+	}
+	return l
+}
+
+// program is a directory translated by batch, with its manifest if it has
+// one.
+type program struct {
+	dir      string
+	manifest string
+}
+
+/*
+findPrograms walks the tree at root and returns every directory that
+contains .vm files or a manifest, in lexical order. Directories whose names
+start with "." are skipped, and so is everything below a manifest, whose
+inputs decide what belongs to the project.
+*/
+func findPrograms(root string) ([]program, error) {
+	var programs []program
+	err := filepath.Walk(root, func(path string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fInfo.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(fInfo.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if m := project.Find(path); m != "" {
+			programs = append(programs, program{dir: path, manifest: m})
+			return filepath.SkipDir
+		}
+		vms, err := filepath.Glob(filepath.Join(path, "*.vm"))
+		if err != nil {
+			return err
+		}
+		if len(vms) > 0 {
+			programs = append(programs, program{dir: path})
+		}
+		return nil
+	})
+	return programs, err
+}
+
+// result is the outcome of translating one program.
+type result struct {
+	program
+	output       string
+	files        int
+	instructions int
+	size         int
+	err          error
+}
+
+// translateAll translates programs with at most workers at a time. Every
+// program gets its own copy of o, with the flags in set overriding its
+// manifest. The results are in the order of programs.
+func translateAll(programs []program, o options, set map[string]bool, workers int) []result {
+	results := make([]result, len(programs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = translateProgram(programs[i], o, set)
+			}
+		}()
+	}
+	for i := range programs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func translateProgram(p program, o options, set map[string]bool) result {
+	r := result{program: p}
+	inputs := []string{p.dir}
+	if p.manifest != "" {
+		m, err := o.useManifest(p.manifest, set)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		inputs = m.Inputs
+	}
+	r.output, r.err = o.outputName(inputs, ".asm")
+	if r.err != nil {
+		return r
+	}
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.files = len(files)
+	asm, cw, err := o.translate(files)
+	if cw != nil {
+		r.instructions = cw.LinkMap().Instructions
+	}
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.size = len(asm)
+	r.err = writeOutput(r.output, bytes.NewBuffer(asm))
+	return r
+}
+
+// writeSummary prints a table of the results. The errors are reported with
+// the other diagnostics.
+func writeSummary(w io.Writer, results []result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "program\tstatus\tfiles\tinstructions\tbytes\toutput")
+	ok, files, instructions, size := 0, 0, 0, 0
+	for _, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = "FAIL"
+		} else {
+			ok++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\n", r.dir, status, r.files, r.instructions, r.size, r.output)
+		files += r.files
+		instructions += r.instructions
+		size += r.size
+	}
+	fmt.Fprintf(tw, "total\t%d/%d ok\t%d\t%d\t%d\t\n", ok, len(results), files, instructions, size)
+	tw.Flush()
+}
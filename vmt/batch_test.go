@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_findPrograms(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"a/Main.vm":         "",
+		"a/b/Main.vm":       "",
+		"c/README":          "",
+		"d/vmt.json":        `{"inputs": ["src"]}`,
+		"d/src/Main.vm":     "",
+		".git/objects/X.vm": "",
+	})
+	defer os.RemoveAll(dir)
+
+	got, err := findPrograms(dir)
+	if err != nil {
+		t.Fatalf("findPrograms() error = %v", err)
+	}
+	want := []program{
+		{dir: filepath.Join(dir, "a")},
+		{dir: filepath.Join(dir, "a/b")},
+		{dir: filepath.Join(dir, "d"), manifest: filepath.Join(dir, "d/vmt.json")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("findPrograms() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("findPrograms()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func Test_translateAll(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Good/Sys.vm":      "function Sys.init 0\nlabel END\ngoto END\n",
+		"Bad/Main.vm":      "push constant\n",
+		"Proj/vmt.json":    `{"inputs": ["src"], "output": "out.asm"}`,
+		"Proj/src/Main.vm": "push constant 1\n",
+	})
+	defer os.RemoveAll(dir)
+
+	programs, err := findPrograms(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := translateAll(programs, options{bootstrap: true}, nil, 2)
+	if len(results) != 3 {
+		t.Fatalf("translateAll() = %d results, want 3", len(results))
+	}
+	for _, r := range results {
+		failed := filepath.Base(r.dir) == "Bad"
+		if (r.err != nil) != failed {
+			t.Errorf("%s: error = %v", r.dir, r.err)
+		}
+		if failed {
+			continue
+		}
+		if fInfo, err := os.Stat(r.output); err != nil || int(fInfo.Size()) != r.size {
+			t.Errorf("%s: output %s has not %d bytes: %v", r.dir, r.output, r.size, err)
+		}
+	}
+	if want := filepath.Join(dir, "Good/Good.asm"); results[1].output != want {
+		t.Errorf("output = %s, want %s", results[1].output, want)
+	}
+	if want := filepath.Join(dir, "Proj/out.asm"); results[2].output != want {
+		t.Errorf("output = %s, want %s", results[2].output, want)
+	}
+
+	b := bytes.NewBufferString("")
+	writeSummary(b, results)
+	for _, s := range []string{"FAIL", "2/3 ok"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("writeSummary() does not contain %q:\n%s", s, b)
+		}
+	}
+
+	l := failures(results)
+	if len(l) != 1 {
+		t.Fatalf("failures() = %v, want 1 diagnostic", l)
+	}
+	if want := filepath.Join(dir, "Bad/Main.vm"); l[0].File != want || l[0].Line != 1 || l[0].Rule != "missing-argument" {
+		t.Errorf("failures() = %+v, want %s:1 [missing-argument]", l[0], want)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Package cache keeps the code generated for single VM files on disk, so that
+translating a program again only regenerates the files that changed.
+
+Fragments are stored by codewriter.Key together with the version of the tool,
+a hash of the running executable, so that a rebuilt vmt never reuses code an
+older build generated. The cache is best effort: a fragment that cannot be
+read or written is simply generated again. Memory keeps fragments for the
+life of the process instead.
+*/
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"vmt/codewriter"
+)
+
+// Dir is a codewriter.Cache in a directory.
+type Dir struct {
+	path    string
+	version string
+
+	hits, misses int64
+}
+
+// Open returns the cache in dir, creating the directory if needed.
+func Open(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: dir, version: toolVersion()}, nil
+}
+
+// Get returns the fragment stored under key.
+func (d *Dir) Get(key string) (*codewriter.Fragment, bool) {
+	f, err := os.Open(d.file(key))
+	if err != nil {
+		atomic.AddInt64(&d.misses, 1)
+		return nil, false
+	}
+	defer f.Close()
+	fr := &codewriter.Fragment{}
+	if err := gob.NewDecoder(f).Decode(fr); err != nil {
+		atomic.AddInt64(&d.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&d.hits, 1)
+	return fr, true
+}
+
+// Put stores fr under key. The file is written under a temporary name and
+// renamed, so that concurrent runs never see half a fragment.
+func (d *Dir) Put(key string, fr *codewriter.Fragment) {
+	name := d.file(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "tmp-")
+	if err != nil {
+		return
+	}
+	err = gob.NewEncoder(tmp).Encode(fr)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// Stats returns the number of fragments found and not found so far.
+func (d *Dir) Stats() (hits, misses int) {
+	return int(atomic.LoadInt64(&d.hits)), int(atomic.LoadInt64(&d.misses))
+}
+
+// file returns the path for key, in a subdirectory by its first two digits
+// like the Go build cache.
+func (d *Dir) file(key string) string {
+	h := sha256.Sum256([]byte(d.version + "\n" + key))
+	sum := hex.EncodeToString(h[:])
+	return filepath.Join(d.path, sum[:2], sum)
+}
+
+var (
+	versionOnce sync.Once
+	version     string
+)
+
+// toolVersion returns codewriter.Version and a hash of the executable, or
+// only codewriter.Version when the executable cannot be read.
+func toolVersion() string {
+	versionOnce.Do(func() {
+		version = codewriter.Version
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return
+		}
+		version += " " + hex.EncodeToString(h.Sum(nil))
+	})
+	return version
+}
+
+// Memory is a codewriter.Cache that lives as long as the process.
+type Memory struct {
+	mu     sync.Mutex
+	frags  map[string]*codewriter.Fragment
+	hits   int
+	misses int
+}
+
+// NewMemory returns an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{frags: map[string]*codewriter.Fragment{}}
+}
+
+// Get returns the fragment stored under key.
+func (m *Memory) Get(key string) (*codewriter.Fragment, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fr, ok := m.frags[key]
+	if ok {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	return fr, ok
+}
+
+// Put stores fr under key.
+func (m *Memory) Put(key string, fr *codewriter.Fragment) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frags[key] = fr
+}
+
+// Stats returns the number of fragments found and not found so far.
+func (m *Memory) Stats() (hits, misses int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hits, m.misses
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"vmt/codewriter"
+	"vmt/generator"
+)
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, ok := d.Get("missing"); ok {
+		t.Errorf("Get() found a missing key")
+	}
+	fr := &codewriter.Fragment{
+		File:      "Main",
+		Asm:       []byte("@SP\nM=M+1\n"),
+		ROM:       2,
+		Lines:     2,
+		Mappings:  []codewriter.Mapping{{Start: 0, End: 2, AsmStart: 1, AsmEnd: 3, File: "Main.vm", Line: 1, Command: "push constant 0"}},
+		Functions: []codewriter.Function{{Name: "Main.main", File: "Main.vm"}},
+		Labels:    map[string]int{"Main.main": 0},
+		Refs:      []string{"Main.0"},
+		Function:  "Main.main",
+	}
+	d.Put("key", fr)
+	got, ok := d.Get("key")
+	if !ok || !reflect.DeepEqual(got, fr) {
+		t.Errorf("Get() = %+v, %v, want %+v", got, ok, fr)
+	}
+
+	// a corrupt fragment is a miss
+	os.MkdirAll(filepath.Dir(d.file("bad")), 0755)
+	if err := ioutil.WriteFile(d.file("bad"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Get("bad"); ok {
+		t.Errorf("Get() decoded a corrupt fragment")
+	}
+	if hits, misses := d.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Stats() = %d, %d, want 1, 2", hits, misses)
+	}
+}
+
+func TestDir_translate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := generator.Generate(3, generator.DefaultConfig)
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		d, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := bytes.NewBufferString("")
+		if _, err := codewriter.TranslateCached(b, files, codewriter.DefaultOptions, 2, d); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, b.String())
+		if hits, _ := d.Stats(); hits != i*len(files) {
+			t.Errorf("run %d: %d hits, want %d", i, hits, i*len(files))
+		}
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("cached translation differs")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	fr := &codewriter.Fragment{File: "Main", Asm: []byte("@SP\n")}
+	if _, ok := m.Get("key"); ok {
+		t.Errorf("Get() found a missing key")
+	}
+	m.Put("key", fr)
+	if got, ok := m.Get("key"); !ok || got != fr {
+		t.Errorf("Get() = %v, %v, want %v", got, ok, fr)
+	}
+	if hits, misses := m.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Stats() = %d, %d, want 1, 1", hits, misses)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Package cgen translates VM code into a single C99 file, to run VM programs
+natively.
+
+The file models the Hack computer the way the assembly of the codewriter
+package uses it: a RAM of 32768 16-bit words, with the stack pointer and the
+segment pointers in RAM 0-4, the temp segment in RAM 5-12 and static
+variables from RAM 16 on. Arithmetic wraps around at 16 bits, and the
+comparisons subtract like the Hack code does. Each VM function and label is
+a C label in one function, vm_run; calls push the ROM address the Hack code
+would return to and returns jump back through a switch over those addresses,
+so a program leaves the same RAM behind as on the Hack CPU emulator.
+
+The program stops at the loop the emulator halts at, label X followed by
+goto X, or at the end of its code. Reads of the keyboard and writes to the
+screen go through the hooks vm_keyboard and vm_screen when they are set. The
+file has a main function, unless it is compiled with VM_NO_MAIN, that sets
+RAM from its arguments such as 0=256, runs the program and prints the RAM
+words that are not 0.
+*/
+package cgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"vmt/codewriter"
+	"vmt/parser"
+)
+
+// Writer translates VM commands into C. It implements codewriter.Backend.
+type Writer struct {
+	codewriter.Native
+	w io.Writer
+}
+
+// New returns a Writer that writes C to w when it is closed, with the
+// Options of codewriter.Native.Init.
+func New(w io.Writer, opt codewriter.Options) *Writer {
+	cw := &Writer{w: w}
+	cw.Init(cw, opt)
+	return cw
+}
+
+func (cw *Writer) Comment(text string) string {
+	return fmt.Sprintf("/* %s */", text)
+}
+
+func (cw *Writer) Bootstrap() {
+	cw.Printf("SP = 256;")
+}
+
+var binary = map[string]string{
+	"add": "x + y",
+	"sub": "x - y",
+	"and": "x & y",
+	"or":  "x | y",
+	"eq":  "(uint16_t)(x - y) == 0 ? 0xFFFF : 0",
+	"gt":  "(uint16_t)(x - y) != 0 && (uint16_t)(x - y) < 0x8000 ? 0xFFFF : 0",
+	"lt":  "(uint16_t)(x - y) >= 0x8000 ? 0xFFFF : 0",
+}
+
+func (cw *Writer) Arithmetic(cmd string) {
+	cw.Printf("vm_%s();", cmd)
+}
+
+// segments are the RAM addresses of the segment pointers.
+var segments = map[string]string{"local": "LCL", "argument": "ARG", "this": "THIS", "that": "THAT"}
+
+func (cw *Writer) PushPop(cmd parser.Type, segment string, index int) {
+	// the address of the word, for the segments that are not pointers
+	addr := ""
+	switch segment {
+	case "pointer":
+		addr = fmt.Sprint(3 + index)
+	case "temp":
+		addr = fmt.Sprint(5 + index)
+	case "static":
+		addr = static(cw.Static(index))
+	}
+	switch {
+	case cmd == parser.PUSH && segment == "constant":
+		cw.Printf("push(%d);", index)
+	case cmd == parser.PUSH && addr != "":
+		cw.Printf("push(vm_ram[%s]);", addr)
+	case cmd == parser.PUSH:
+		cw.Printf("R13 = %s + %d;", segments[segment], index)
+		cw.Printf("push(rd(R13));")
+	case addr != "":
+		cw.Printf("vm_ram[%s] = pop();", addr)
+	default:
+		cw.Printf("SP--;")
+		cw.Printf("R13 = %s + %d;", segments[segment], index)
+		cw.Printf("wr(R13, rd(SP));")
+	}
+}
+
+func (cw *Writer) Goto(name string) {
+	cw.Printf("goto %s;", name)
+}
+
+func (cw *Writer) Halt() {
+	cw.Printf("return 0; /* halt */")
+}
+
+func (cw *Writer) If(name string) {
+	cw.Printf("if (pop()) goto %s;", name)
+}
+
+func (cw *Writer) PushZero() {
+	cw.Printf("push(0);")
+}
+
+func (cw *Writer) Call(name string, numargs, ret int) {
+	cw.Printf("push(%d);", ret)
+	cw.Printf("push(LCL);")
+	cw.Printf("push(ARG);")
+	cw.Printf("push(THIS);")
+	cw.Printf("push(THAT);")
+	cw.Printf("ARG = SP - %d - 5;", numargs)
+	cw.Printf("LCL = SP;")
+	cw.Printf("goto %s;", name)
+}
+
+func (cw *Writer) Return() {
+	cw.Printf("vm_return();")
+	cw.Printf("goto dispatch;")
+}
+
+// WriteProgram writes the C file.
+func (cw *Writer) WriteProgram() error {
+	w := bufio.NewWriter(cw.w)
+	io.WriteString(w, prelude)
+	fmt.Fprintln(w)
+	for _, v := range cw.Statics() {
+		fmt.Fprintf(w, "#define %s %d\n", static(v.Symbol), v.Address)
+	}
+	for _, op := range []string{"add", "sub", "and", "or", "eq", "gt", "lt"} {
+		fmt.Fprintf(w, "\nstatic inline void vm_%s(void) {\n", op)
+		fmt.Fprintf(w, "\tuint16_t y = pop();\n\tuint16_t x = pop();\n\tpush(%s);\n}\n", binary[op])
+	}
+
+	fmt.Fprint(w, "\n/* vm_run runs the program; it returns 0 when the program halts or runs\n   past its code and -1 when it jumps to an address without code. */\n")
+	fmt.Fprint(w, "int vm_run(void) {\n")
+	cw.WriteBody(w)
+	fmt.Fprint(w, "\treturn 0;\n")
+	if cw.Returned() {
+		fmt.Fprint(w, "\ndispatch:\n\tswitch (R14) {\n")
+		for _, ret := range cw.Returns() {
+			fmt.Fprintf(w, "\tcase %d: goto R_%d;\n", ret, ret)
+		}
+		fmt.Fprint(w, "\t}\n\treturn -1;\n")
+	}
+	for _, name := range cw.Missing() {
+		fmt.Fprintf(w, "%s:\n\treturn -1;\n", name)
+	}
+	fmt.Fprint(w, "}\n")
+	io.WriteString(w, mainFunc)
+	return w.Flush()
+}
+
+// static returns the C name of the static variable sym.
+func static(sym string) string {
+	return "S_" + codewriter.Identifier(sym)
+}
+
+const prelude = `/* Generated by vmt from VM code. */
+#include <stdint.h>
+#include <stdio.h>
+
+#define VM_RAM_SIZE 32768
+#define VM_SCREEN 16384
+#define VM_KBD 24576
+
+uint16_t vm_ram[VM_RAM_SIZE];
+
+/* vm_screen is called after every write to the screen, vm_keyboard for every
+   read of KBD; when they are not set, both are plain RAM. */
+void (*vm_screen)(uint16_t addr, uint16_t value);
+uint16_t (*vm_keyboard)(void);
+
+#define SP vm_ram[0]
+#define LCL vm_ram[1]
+#define ARG vm_ram[2]
+#define THIS vm_ram[3]
+#define THAT vm_ram[4]
+#define R13 vm_ram[13]
+#define R14 vm_ram[14]
+
+static inline uint16_t rd(uint16_t a) {
+	a &= 0x7FFF;
+	if (a == VM_KBD && vm_keyboard)
+		return vm_keyboard();
+	return vm_ram[a];
+}
+
+static inline void wr(uint16_t a, uint16_t v) {
+	a &= 0x7FFF;
+	vm_ram[a] = v;
+	if (a >= VM_SCREEN && a < VM_KBD && vm_screen)
+		vm_screen(a, v);
+}
+
+static inline void push(uint16_t v) {
+	wr(SP, v);
+	SP++;
+}
+
+static inline uint16_t pop(void) {
+	SP--;
+	return rd(SP);
+}
+
+static inline void vm_neg(void) {
+	push(0 - pop());
+}
+
+static inline void vm_not(void) {
+	push(~pop());
+}
+
+/* vm_return restores the frame of the caller and leaves the return address
+   in R14. */
+static inline void vm_return(void) {
+	R13 = LCL;
+	R14 = rd(R13 - 5);
+	SP--;
+	wr(ARG, rd(SP));
+	SP = ARG + 1;
+	THAT = rd(R13 - 1);
+	THIS = rd(R13 - 2);
+	ARG = rd(R13 - 3);
+	LCL = rd(R13 - 4);
+}
+`
+
+const mainFunc = `
+#ifndef VM_NO_MAIN
+int main(int argc, char **argv) {
+	int i;
+	for (i = 1; i < argc; i++) {
+		long a, v;
+		if (sscanf(argv[i], "%ld=%ld", &a, &v) != 2 || a < 0 || a >= VM_RAM_SIZE) {
+			fprintf(stderr, "usage: %s [address=value ...]\n", argv[0]);
+			return 2;
+		}
+		vm_ram[a] = (uint16_t)v;
+	}
+	if (vm_run() != 0) {
+		fprintf(stderr, "jump to an address without code\n");
+		return 1;
+	}
+	for (i = 0; i < VM_RAM_SIZE; i++) {
+		if (vm_ram[i] != 0)
+			printf("RAM[%d]\t%ld\n", i, vm_ram[i] < 0x8000 ? (long)vm_ram[i] : (long)vm_ram[i] - 65536);
+	}
+	return 0;
+}
+#endif
+`
@@ -0,0 +1,146 @@
+package cgen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vmt/codewriter"
+	"vmt/internal/nativetest"
+	"vmt/parser"
+)
+
+func TestWriter(t *testing.T) {
+	b := bytes.NewBufferString("")
+	cw := New(b, codewriter.Options{})
+	cw.SetFileName("Main")
+	for _, c := range []parser.Command{
+		{Type: parser.PUSH, Arg1: "local", Arg2: 2},
+		{Type: parser.POP, Arg1: "static", Arg2: 1},
+		{Type: parser.LABEL, Arg1: "END"},
+		{Type: parser.GOTO, Arg1: "END"},
+	} {
+		codewriter.Dispatch(cw, c)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"#define S_Main_2e1 16\n",
+		"\t/* push local 2 */\n\tR13 = LCL + 2;\n\tpush(rd(R13));\n",
+		"\tvm_ram[S_Main_2e1] = pop();\n",
+		"\treturn 0; /* halt */\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("C code without %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(b.String(), "L_Main_24END:") || strings.Contains(b.String(), "dispatch:") {
+		t.Errorf("C code with unused labels:\n%s", b)
+	}
+}
+
+// compile compiles files to C and then with the system C compiler, and
+// returns the executable.
+func compile(t *testing.T, dir string, files []parser.File, opt codewriter.Options) string {
+	t.Helper()
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	b := bytes.NewBufferString("")
+	if err := codewriter.TranslateTo(New(b, opt), files); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "prog.c")
+	if err := ioutil.WriteFile(src, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe := filepath.Join(dir, "prog")
+	out, err := exec.Command(cc, "-std=c99", "-pedantic", "-Wall", "-Werror", "-O1", "-o", exe, src).CombinedOutput()
+	if err != nil {
+		t.Fatalf("cc: %v\n%s", err, out)
+	}
+	return exe
+}
+
+// The compiled program must leave the same RAM behind as the Hack code on
+// the emulator, word for word.
+func TestWriter_generated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nativetest.Generated(t, 5, func(files []parser.File, opt codewriter.Options) []byte {
+		out, err := exec.Command(compile(t, dir, files, opt)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+}
+
+func TestWriter_hooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// copy the keyboard to the screen
+	files := []parser.File{{Name: "Main", Commands: []parser.Command{
+		{Type: parser.PUSH, Arg1: "constant", Arg2: 24576},
+		{Type: parser.POP, Arg1: "pointer", Arg2: 1},
+		{Type: parser.PUSH, Arg1: "that", Arg2: 0},
+		{Type: parser.PUSH, Arg1: "constant", Arg2: 16384},
+		{Type: parser.POP, Arg1: "pointer", Arg2: 1},
+		{Type: parser.POP, Arg1: "that", Arg2: 5},
+	}}}
+	b := bytes.NewBufferString("")
+	if err := codewriter.TranslateTo(New(b, codewriter.Options{}), files); err != nil {
+		t.Fatal(err)
+	}
+	prog := filepath.Join(dir, "prog.c")
+	if err := ioutil.WriteFile(prog, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := filepath.Join(dir, "host.c")
+	err = ioutil.WriteFile(host, []byte(`#include <stdint.h>
+#include <stdio.h>
+extern uint16_t vm_ram[];
+extern void (*vm_screen)(uint16_t, uint16_t);
+extern uint16_t (*vm_keyboard)(void);
+int vm_run(void);
+static uint16_t key(void) { return 75; }
+static void draw(uint16_t addr, uint16_t value) { printf("%u=%u\n", addr, value); }
+int main(void) {
+	vm_ram[0] = 256;
+	vm_keyboard = key;
+	vm_screen = draw;
+	return vm_run();
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	exe := filepath.Join(dir, "host")
+	if out, err := exec.Command(cc, "-std=c99", "-DVM_NO_MAIN", "-o", exe, host, prog).CombinedOutput(); err != nil {
+		t.Fatalf("cc: %v\n%s", err, out)
+	}
+	out, err := exec.Command(exe).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "16389=75\n"; string(out) != want {
+		t.Errorf("hooks saw %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,110 @@
+package codewriter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"vmt/parser"
+)
+
+// Backend is a target VM code is translated to. CodeWriter is the backend
+// for Hack assembly; the others are constructed with Options like it, write
+// the bootstrap code first when asked to, and may hold their output back
+// until they are closed.
+type Backend interface {
+	SetFileName(fn string)
+	WriteArithmetic(cmd string)
+	WritePushPop(cmd parser.Type, segment string, index int)
+	WriteLabel(label string)
+	WriteGoto(label string)
+	WriteIf(label string)
+	WriteFunction(funcname string, numlocal int)
+	WriteCall(funcname string, numargs int)
+	WriteReturn()
+	Close() error
+}
+
+// Dispatch writes c to b with the method for its type.
+func Dispatch(b Backend, c parser.Command) {
+	switch c.Type {
+	case parser.ARITHMETIC:
+		b.WriteArithmetic(c.Arg1)
+	case parser.PUSH, parser.POP:
+		b.WritePushPop(c.Type, c.Arg1, c.Arg2)
+	case parser.LABEL:
+		b.WriteLabel(c.Arg1)
+	case parser.IF:
+		b.WriteIf(c.Arg1)
+	case parser.GOTO:
+		b.WriteGoto(c.Arg1)
+	case parser.FUNCTION:
+		b.WriteFunction(c.Arg1, c.Arg2)
+	case parser.RETURN:
+		b.WriteReturn()
+	case parser.CALL:
+		b.WriteCall(c.Arg1, c.Arg2)
+	}
+}
+
+// TranslateTo writes files to b in order and closes it.
+func TranslateTo(b Backend, files []parser.File) error {
+	for _, f := range files {
+		b.SetFileName(f.Name)
+		for _, c := range f.Commands {
+			Dispatch(b, c)
+		}
+	}
+	return b.Close()
+}
+
+// Close does nothing; a CodeWriter writes every command as it comes.
+func (cw *CodeWriter) Close() error {
+	return nil
+}
+
+/*
+Shadow returns a CodeWriter that writes nothing, for backends that leave the
+same RAM behind as the Hack program: they write every command to it as well
+and take the return addresses calls push from ROM, and the addresses of
+static variables from LinkMap.
+*/
+func Shadow(opt Options) *CodeWriter {
+	opt.Mode = Compact
+	return NewWithOptions(ioutil.Discard, opt)
+}
+
+// ROM returns the address of the next instruction.
+func (cw *CodeWriter) ROM() int {
+	return cw.rom
+}
+
+// LabelSymbol returns the symbol of a VM label in the current function or,
+// outside of functions, the current file.
+func (cw *CodeWriter) LabelSymbol(label string) string {
+	return cw.labelSymbol(label)
+}
+
+// Halts reports whether goto label here is the loop the emulator halts at,
+// that is whether label is defined at the current address.
+func (cw *CodeWriter) Halts(label string) bool {
+	rom, ok := cw.link.labels[cw.labelSymbol(label)]
+	return ok && rom == cw.rom
+}
+
+// Identifier turns a symbol into an identifier for languages such as C and
+// Go. Letters and digits stay, "_" doubles and every other character becomes
+// "_" and its hex code, so that different symbols stay different.
+func Identifier(sym string) string {
+	var b strings.Builder
+	for _, r := range sym {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '_':
+			b.WriteString("__")
+		default:
+			fmt.Fprintf(&b, "_%02x", r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package codewriter
+
+import (
+	"bytes"
+	"testing"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+func TestTranslateTo(t *testing.T) {
+	files := generator.Generate(1, generator.DefaultConfig)
+	want := bytes.NewBufferString("")
+	if _, err := Translate(want, files, DefaultOptions, 1); err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBufferString("")
+	if err := TranslateTo(NewWithOptions(b, DefaultOptions), files); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want.String() {
+		t.Errorf("TranslateTo() wrote other assembly than Translate()")
+	}
+}
+
+func TestCodeWriter_Halts(t *testing.T) {
+	cw := Shadow(Options{Bootstrap: true})
+	cw.SetFileName("Sys")
+	for _, c := range []parser.Command{
+		{Type: parser.FUNCTION, Arg1: "Sys.init"},
+		{Type: parser.LABEL, Arg1: "LOOP"},
+		{Type: parser.PUSH, Arg1: "constant", Arg2: 1},
+		{Type: parser.LABEL, Arg1: "END"},
+		{Type: parser.LABEL, Arg1: "STOP"},
+	} {
+		Dispatch(cw, c)
+	}
+	for label, want := range map[string]bool{"LOOP": false, "END": true, "STOP": true, "NONE": false} {
+		if got := cw.Halts(label); got != want {
+			t.Errorf("Halts(%q) = %v, want %v", label, got, want)
+		}
+	}
+	if want := 60; cw.ROM() != want {
+		t.Errorf("ROM() = %d, want %d", cw.ROM(), want)
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	tests := []struct {
+		sym  string
+		want string
+	}{
+		{"Main.main", "Main_2emain"},
+		{"Main.main$LOOP_1", "Main_2emain_24LOOP__1"},
+		{"a:b", "a_3ab"},
+		{"a_3ab", "a__3ab"},
+	}
+	for _, tt := range tests {
+		if got := Identifier(tt.sym); got != tt.want {
+			t.Errorf("Identifier(%q) = %q, want %q", tt.sym, got, tt.want)
+		}
+	}
+}
@@ -1,29 +1,113 @@
 package codewriter
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"strings"
 	"vmt/parser"
 )
 
 type CodeWriter struct {
-	w       io.Writer
-	addr    int
-	callcnt int
-	fn      string
+	w        io.Writer
+	addr     int
+	callcnt  int
+	fn       string
+	function string
+
+	opt Options
+	a   string // symbol known to be in the A register, see write
+
+	// position in the output, see write
+	rom   int
+	lines int
+	smap  SourceMap
+	link  linker
 }
 
+// Options control code generation.
+type Options struct {
+	// Bootstrap writes the code that sets SP to 256 and calls Sys.init.
+	Bootstrap bool
+	// Entry is the function the bootstrap code calls instead of Sys.init.
+	Entry string
+	// Optimize is the optimization level. Level 1 drops A-instructions that
+	// load the value the A register already holds.
+	Optimize int
+	// Mode is the form the assembly is written in.
+	Mode Mode
+}
+
+// Mode is a form of the generated assembly. All forms assemble to the same
+// machine code.
+type Mode int
+
+const (
+	// Verbose writes every template with its comments, each VM command
+	// after a blank line.
+	Verbose Mode = iota
+	// Compact writes only labels and instructions.
+	Compact
+	// Annotated writes every VM command as a comment with its file and
+	// line, followed by its labels and instructions, each instruction with
+	// its ROM address in a comment.
+	Annotated
+)
+
+var modes = []string{"verbose", "compact", "annotated"}
+
+func (m Mode) String() string {
+	if m < 0 || int(m) >= len(modes) {
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+	return modes[m]
+}
+
+// ParseMode returns the Mode named s.
+func ParseMode(s string) (Mode, error) {
+	for i, name := range modes {
+		if s == name {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown assembly mode %q, want verbose, compact or annotated", s)
+}
+
+// DefaultOptions are the options New uses.
+var DefaultOptions = Options{Bootstrap: true}
+
 func New(w io.Writer) *CodeWriter {
+	return NewWithOptions(w, DefaultOptions)
+}
+
+// NewWithOptions returns a CodeWriter that writes to w as opt says.
+func NewWithOptions(w io.Writer, opt Options) *CodeWriter {
 	cw := &CodeWriter{
-		w: w,
+		w:   w,
+		opt: opt,
+	}
+	if opt.Bootstrap {
+		cw.writeInit()
 	}
-	cw.writeInit()
 	return cw
 }
 
+// SetFileName starts the file fn. Generated labels are numbered per file and
+// the optimizer forgets the A register, so that the code of a file does not
+// depend on the files before it; see Translate.
 func (cw *CodeWriter) SetFileName(fn string) {
 	cw.fn = fn
+	cw.function = ""
+	cw.addr = 0
+	cw.callcnt = 0
+	cw.a = ""
+}
+
+// WriteCommand writes the assembly for a single parsed VM command and
+// records it in the source map.
+func (cw *CodeWriter) WriteCommand(c parser.Command) {
+	m := cw.beginMapping(c.Line, c.String())
+	defer cw.endMapping(m)
+	Dispatch(cw, c)
 }
 
 func (cw *CodeWriter) WriteArithmetic(cmd string) {
@@ -91,19 +175,17 @@ func (cw *CodeWriter) WritePushPop(cmd parser.Type, segment string, index int) {
 }
 
 func (cw *CodeWriter) WriteLabel(label string) {
-	symbol := fmt.Sprintf("%s$%s", cw.fn, label)
+	symbol := cw.labelSymbol(label)
 	asm := `
 // write label %s
 (%s)
 `
 	asm = fmt.Sprintf(asm, symbol, symbol)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 func (cw *CodeWriter) WriteIf(label string) {
-	symbol := fmt.Sprintf("%s$%s", cw.fn, label)
+	symbol := cw.labelSymbol(label)
 	asm := `
 // if-goto label %s
 @SP
@@ -115,22 +197,18 @@ D=M
 D;JNE
 `
 	asm = fmt.Sprintf(asm, symbol, symbol)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 func (cw *CodeWriter) WriteGoto(label string) {
-	symbol := fmt.Sprintf("%s$%s", cw.fn, label)
+	symbol := cw.labelSymbol(label)
 	asm := `
 // goto label %s
 @%s
 0;JMP
 `
 	asm = fmt.Sprintf(asm, symbol, symbol)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 func (cw *CodeWriter) WriteReturn() {
@@ -191,20 +269,22 @@ M=D
 A=M
 0;JMP
 `
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 func (cw *CodeWriter) WriteFunction(funcname string, numlocal int) {
+	cw.function = funcname
+	cw.link.functions = append(cw.link.functions, Function{
+		Name:  funcname,
+		File:  cw.fn + ".vm",
+		Start: cw.rom,
+	})
 	asm := `
 // function %s local nums %d
 (%s)
 `
 	asm = fmt.Sprintf(asm, funcname, numlocal, funcname)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 	// initialize local variable to 0
 	for i := 0; i < numlocal; i++ {
 		cw.writePushConstant(0)
@@ -212,7 +292,7 @@ func (cw *CodeWriter) WriteFunction(funcname string, numlocal int) {
 }
 
 func (cw *CodeWriter) WriteCall(funcname string, numargs int) {
-	rlabel := fmt.Sprintf("%s$%d", funcname, cw.callcnt)
+	rlabel := cw.generatedSymbol("ret", cw.callcnt)
 	asm := `
 // call %s args nums %d
 @%s
@@ -224,9 +304,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, funcname, numargs, rlabel)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 
 	// push LCL, ARG, THIS, THAT
 	cw.writePushRegisterByName("LCL")
@@ -253,14 +331,83 @@ M=D
 (%s)
 `
 	rasm = fmt.Sprintf(rasm, numargs, funcname, rlabel)
-	w = bufio.NewWriter(cw.w)
-	w.WriteString(rasm)
-	w.Flush()
+	cw.write(rasm)
 
 	cw.callcnt++
 }
 
+// write writes a template and advances the ROM address by the number of
+// instructions in it. Blank lines, comments and labels take no ROM.
+//
+// From optimization level 1 on, an A-instruction is dropped when the A
+// register is known to hold its value already: A is known from the last
+// A-instruction until an instruction assigns A or a label makes the next
+// instruction reachable from elsewhere.
+//
+// In the Compact and Annotated modes blank lines and comments are left out,
+// and in Annotated every instruction gets its ROM address.
+func (cw *CodeWriter) write(asm string) {
+	var out strings.Builder
+	for _, line := range strings.SplitAfter(asm, "\n") {
+		inst := strings.TrimSpace(line)
+		if cw.opt.Mode != Verbose {
+			line = inst + "\n"
+		}
+		switch {
+		case inst == "" || strings.HasPrefix(inst, "//"):
+			if cw.opt.Mode != Verbose {
+				continue
+			}
+		case strings.HasPrefix(inst, "("):
+			cw.link.define(strings.Trim(inst, "()"), cw.rom)
+			cw.a = ""
+		case strings.HasPrefix(inst, "@"):
+			if cw.opt.Optimize > 0 && inst[1:] == cw.a {
+				continue
+			}
+			cw.a = inst[1:]
+			cw.link.reference(inst[1:])
+			if cw.opt.Mode == Annotated {
+				line = fmt.Sprintf("%-15s // %d\n", inst, cw.rom)
+			}
+			cw.rom++
+		default:
+			if i := strings.Index(inst, "="); i >= 0 && strings.Contains(inst[:i], "A") {
+				cw.a = ""
+			}
+			if cw.opt.Mode == Annotated {
+				line = fmt.Sprintf("%-15s // %d\n", inst, cw.rom)
+			}
+			cw.rom++
+		}
+		out.WriteString(line)
+	}
+	cw.lines += strings.Count(out.String(), "\n")
+	io.WriteString(cw.w, out.String())
+}
+
+// labelSymbol scopes a VM label to the enclosing function, so that two
+// functions in the same file may reuse a label name. Outside of any function
+// the file name is used instead.
+func (cw *CodeWriter) labelSymbol(label string) string {
+	if cw.function != "" {
+		return fmt.Sprintf("%s$%s", cw.function, label)
+	}
+	return fmt.Sprintf("%s$%s", cw.fn, label)
+}
+
+// generatedSymbol returns the n-th label of a kind the translator generates,
+// such as "Main$ret$0" for the return address of the first call in Main.vm.
+// The parser rejects "$" in VM labels, so these never clash with a
+// labelSymbol. The bootstrap code has no file and gets "$ret$0".
+func (cw *CodeWriter) generatedSymbol(kind string, n int) string {
+	return fmt.Sprintf("%s$%s$%d", cw.fn, kind, n)
+}
+
 func (cw *CodeWriter) writeInit() {
+	m := cw.beginMapping(0, "bootstrap")
+	defer cw.endMapping(m)
+
 	asm := `
 // initialize asm
 @256
@@ -268,10 +415,13 @@ D=A
 @SP
 M=D
 `
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
-	cw.WriteCall("Sys.init", 0)
+	cw.write(asm)
+	entry := cw.opt.Entry
+	if entry == "" {
+		entry = "Sys.init"
+	}
+	cw.WriteCall(entry, 0)
+	cw.link.bootstrap = cw.rom
 }
 
 /*
@@ -315,9 +465,7 @@ A=M
 M=M+1
 `
 	asm = fmt.Sprintf(asm, op, op)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -349,9 +497,7 @@ A=M
 M=M+1
 `
 	asm = fmt.Sprintf(asm, op, op)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -380,8 +526,8 @@ e.g.. eq
 	- A=M
 	- M=-1 //0xFFFF
 
-6. if D register is 0, jump to LABEL1
-	- @LABEL1
+6. if D register is 0, jump to Main$cmp$1 (the first comparison in Main.vm)
+	- @Main$cmp$1
 	- D;JEQ
 
 7. Set FALSE to the memory pointed to by the stack pointer
@@ -390,7 +536,7 @@ e.g.. eq
 	- M=0 //0x0000
 
 8. Jump destination label
-	- (LABEL1)
+	- (Main$cmp$1)
 
 9. increase stack pointer by one.（Initialize stack pointer）
 	- @SP
@@ -412,19 +558,18 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL%d
+@%s
 D;%s
 @SP
 A=M
 M=0
-(LABEL%d)
+(%s)
 @SP
 M=M+1
 `
-	asm = fmt.Sprintf(asm, op, cw.addr, op, cw.addr)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	label := cw.generatedSymbol("cmp", cw.addr)
+	asm = fmt.Sprintf(asm, op, label, op, label)
+	cw.write(asm)
 }
 
 /*
@@ -458,9 +603,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, index, index)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -512,9 +655,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, symbol, index, index, symbol)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -550,9 +691,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, number, number)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 func (cw *CodeWriter) writePushRegisterByName(register string) {
@@ -567,9 +706,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, register, register)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -606,9 +743,7 @@ M=D
 M=M+1
 `
 	asm = fmt.Sprintf(asm, static, static)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -660,9 +795,7 @@ A=M
 M=D
 `
 	asm = fmt.Sprintf(asm, symbol, index, index, symbol)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -698,9 +831,7 @@ D=M
 M=D
 `
 	asm = fmt.Sprintf(asm, index, index)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
 
 /*
@@ -737,7 +868,5 @@ D=M
 M=D
 `
 	asm = fmt.Sprintf(asm, static, static)
-	w := bufio.NewWriter(cw.w)
-	w.WriteString(asm)
-	w.Flush()
+	cw.write(asm)
 }
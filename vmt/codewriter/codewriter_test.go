@@ -3,6 +3,7 @@ package codewriter
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 	"vmt/parser"
 )
@@ -175,12 +176,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JEQ
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -201,12 +202,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JGT
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -227,12 +228,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JLT
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -275,12 +276,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JEQ
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -301,12 +302,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JEQ
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 
@@ -322,12 +323,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL2
+@$cmp$2
 D;JGT
 @SP
 A=M
 M=0
-(LABEL2)
+($cmp$2)
 @SP
 M=M+1
 `,
@@ -348,12 +349,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JEQ
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 
@@ -369,12 +370,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL2
+@$cmp$2
 D;JGT
 @SP
 A=M
 M=0
-(LABEL2)
+($cmp$2)
 @SP
 M=M+1
 
@@ -390,12 +391,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL3
+@$cmp$3
 D;JLT
 @SP
 A=M
 M=0
-(LABEL3)
+($cmp$3)
 @SP
 M=M+1
 `,
@@ -532,12 +533,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JEQ
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -558,12 +559,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JGT
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -584,12 +585,12 @@ D=M-D
 @SP
 A=M
 M=-1
-@LABEL1
+@$cmp$1
 D;JLT
 @SP
 A=M
 M=0
-(LABEL1)
+($cmp$1)
 @SP
 M=M+1
 `,
@@ -1368,13 +1369,20 @@ func TestCodeWriter_SetFileName(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			b := bytes.NewBufferString("")
 			cw := &CodeWriter{
-				w: b,
+				w:        b,
+				addr:     3,
+				callcnt:  2,
+				function: "Other.f",
+				a:        "SP",
 			}
 			cw.SetFileName(tt.fn)
 
 			if !reflect.DeepEqual(cw.fn, tt.want) {
 				t.Errorf("SetFileName() = %v, want %v", cw.fn, tt.want)
 			}
+			if cw.addr != 0 || cw.callcnt != 0 || cw.function != "" || cw.a != "" {
+				t.Errorf("SetFileName() keeps the state of the previous file: %+v", cw)
+			}
 		})
 	}
 }
@@ -1461,6 +1469,85 @@ func TestCodeWriter_WriteLabel(t *testing.T) {
 	}
 }
 
+func TestCodeWriter_labelSymbol(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       string
+		function string
+		label    string
+		want     string
+	}{
+		{"outside function", "TestFile", "", "LOOP", "TestFile$LOOP"},
+		{"inside function", "TestFile", "TestFile.main", "LOOP", "TestFile.main$LOOP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := &CodeWriter{
+				fn:       tt.fn,
+				function: tt.function,
+			}
+			if got := cw.labelSymbol(tt.label); got != tt.want {
+				t.Errorf("labelSymbol() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeWriter_WriteCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmds []parser.Command
+		want string
+	}{
+		{
+			"label scoped to function",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "TestFile.main", Arg2: 0},
+				{Type: parser.LABEL, Arg1: "LOOP"},
+			},
+			`
+// function TestFile.main local nums 0
+(TestFile.main)
+
+// write label TestFile.main$LOOP
+(TestFile.main$LOOP)
+`,
+		},
+		{
+			"push constant",
+			[]parser.Command{
+				{Type: parser.PUSH, Arg1: "constant", Arg2: 7},
+			},
+			`
+// push constant 7
+@7
+D=A
+@SP
+A=M
+M=D
+@SP
+M=M+1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bytes.NewBufferString("")
+			cw := &CodeWriter{
+				w:  b,
+				fn: "TestFile",
+			}
+			for _, c := range tt.cmds {
+				cw.WriteCommand(c)
+			}
+
+			if string(b.Bytes()) != tt.want {
+				t.Errorf("WriteCommand() = %s, want %v", b, tt.want)
+			}
+		})
+	}
+}
+
 func TestCodeWriter_WriteIf(t *testing.T) {
 	type args struct {
 		label string
@@ -1698,7 +1785,7 @@ func TestCodeWriter_WriteCall(t *testing.T) {
 			},
 			`
 // call TestFunc args nums 1
-@TestFunc$0
+@$ret$0
 D=A
 @SP
 A=M
@@ -1757,7 +1844,7 @@ D=M
 M=D
 @TestFunc
 0;JMP
-(TestFunc$0)
+($ret$0)
 `,
 		},
 	}
@@ -1827,7 +1914,7 @@ D=A
 M=D
 
 // call Sys.init args nums 0
-@Sys.init$0
+@$ret$0
 D=A
 @SP
 A=M
@@ -1886,7 +1973,7 @@ D=M
 M=D
 @Sys.init
 0;JMP
-(Sys.init$0)
+($ret$0)
 `,
 		},
 	}
@@ -1904,3 +1991,16 @@ M=D
 		})
 	}
 }
+
+func TestNewWithOptions_entry(t *testing.T) {
+	b := bytes.NewBufferString("")
+	NewWithOptions(b, Options{Bootstrap: true, Entry: "Main.main"})
+	for _, s := range []string{"@Main.main\n0;JMP\n", "($ret$0)\n"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("NewWithOptions() does not call the entry %q:\n%s", s, b)
+		}
+	}
+	if strings.Contains(b.String(), "Sys.init") {
+		t.Errorf("NewWithOptions() calls Sys.init:\n%s", b)
+	}
+}
@@ -0,0 +1,78 @@
+package codewriter
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+var (
+	labelDef = regexp.MustCompile(`(?m)^\(([^)]+)\)$`)
+	labelRef = regexp.MustCompile(`(?m)^@([A-Za-z_.$:][A-Za-z0-9_.$:]*)$`)
+
+	// symbols the translator generates for jump targets
+	generated = regexp.MustCompile(`\$`)
+)
+
+// FuzzCodeWriter translates generated programs, checks that every jump
+// target in the assembly is defined exactly once and that the Hack code
+// computes what the VM commands do.
+func FuzzCodeWriter(f *testing.F) {
+	for seed := int64(0); seed < 8; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		b := bytes.NewBufferString("")
+		cw := New(b)
+		files := generator.Generate(seed, generator.DefaultConfig)
+		for _, file := range files {
+			cw.SetFileName(file.Name)
+			for _, c := range file.Commands {
+				cw.WriteCommand(c)
+			}
+		}
+		asm := b.String()
+
+		defined := map[string]int{}
+		for _, m := range labelDef.FindAllStringSubmatch(asm, -1) {
+			defined[m[1]]++
+		}
+		for l, n := range defined {
+			if n > 1 {
+				t.Errorf("seed %d: label %s defined %d times", seed, l, n)
+			}
+		}
+		for _, m := range labelRef.FindAllStringSubmatch(asm, -1) {
+			sym := m[1]
+			if _, ok := defined[sym]; ok || !generated.MatchString(sym) {
+				continue
+			}
+			t.Errorf("seed %d: jump to undefined label %s", seed, sym)
+		}
+		if t.Failed() {
+			return
+		}
+		differential(t, files, DefaultOptions)
+	})
+}
+
+func FuzzCodeWriter_WriteCommand(f *testing.F) {
+	f.Add(int(parser.PUSH), "constant", 7)
+	f.Add(int(parser.POP), "static", 3)
+	f.Add(int(parser.ARITHMETIC), "eq", 0)
+	f.Add(int(parser.CALL), "Main.main", 2)
+	f.Add(int(parser.FUNCTION), "Main.main", -1)
+	f.Fuzz(func(t *testing.T, typ int, arg1 string, arg2 int) {
+		if arg2 > 1000 || arg2 < -1000 {
+			// function locals are unrolled, keep the output small
+			return
+		}
+		cw := &CodeWriter{
+			w:  bytes.NewBufferString(""),
+			fn: "Fuzz",
+		}
+		cw.WriteCommand(parser.Command{Type: parser.Type(typ), Arg1: arg1, Arg2: arg2})
+	})
+}
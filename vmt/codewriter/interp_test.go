@@ -0,0 +1,259 @@
+package codewriter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"vmt/assembler"
+	"vmt/emulator"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+// machine runs VM commands directly, as the VM specification describes
+// them, to check the Hack code the CodeWriter writes for the same program.
+// It shares the RAM layout of the Hack platform for the segment pointers,
+// temp and the heap, but keeps statics by name and return addresses on a
+// stack of its own, so that it knows nothing of ROM.
+type machine struct {
+	cmds    []parser.Command
+	files   []string       // the file of each command
+	labels  map[string]int // scoped label or function name to command index
+	scope   []string       // the function of each command
+	ram     [emulator.RAMSize]int16
+	statics map[string]int16
+	frames  []int // command indexes to return to
+	pc      int
+	halted  bool
+}
+
+func newMachine(files []parser.File) *machine {
+	m := &machine{labels: map[string]int{}, statics: map[string]int16{}}
+	for _, f := range files {
+		fn := f.Name
+		for _, c := range f.Commands {
+			switch c.Type {
+			case parser.FUNCTION:
+				fn = c.Arg1
+				m.labels[fn] = len(m.cmds)
+			case parser.LABEL:
+				m.labels[fn+"$"+c.Arg1] = len(m.cmds)
+			}
+			m.cmds = append(m.cmds, c)
+			m.files = append(m.files, f.Name)
+			m.scope = append(m.scope, fn)
+		}
+	}
+	return m
+}
+
+func (m *machine) push(v int16) {
+	m.ram[m.ram[0]] = v
+	m.ram[0]++
+}
+
+func (m *machine) pop() int16 {
+	m.ram[0]--
+	return m.ram[m.ram[0]]
+}
+
+// address returns the RAM address of segment i, or -1 for constant and
+// static.
+func (m *machine) address(segment string, i int) int {
+	switch segment {
+	case "local":
+		return int(m.ram[1]) + i
+	case "argument":
+		return int(m.ram[2]) + i
+	case "this":
+		return int(m.ram[3]) + i
+	case "that":
+		return int(m.ram[4]) + i
+	case "pointer":
+		return 3 + i
+	case "temp":
+		return 5 + i
+	}
+	return -1
+}
+
+func truth(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+// call enters the function at command target with nargs arguments on the
+// stack, to return to command ret.
+func (m *machine) call(target, nargs, ret int) {
+	m.frames = append(m.frames, ret)
+	m.push(0) // the return address is on m.frames
+	for _, r := range []int{1, 2, 3, 4} {
+		m.push(m.ram[r])
+	}
+	m.ram[2] = m.ram[0] - int16(nargs) - 5
+	m.ram[1] = m.ram[0]
+	m.pc = target
+}
+
+// step runs one command.
+func (m *machine) step() error {
+	if m.pc >= len(m.cmds) {
+		m.halted = true
+		return nil
+	}
+	c := m.cmds[m.pc]
+	m.pc++
+	switch c.Type {
+	case parser.ARITHMETIC:
+		if c.Arg1 == "neg" || c.Arg1 == "not" {
+			x := m.pop()
+			if c.Arg1 == "neg" {
+				m.push(-x)
+			} else {
+				m.push(^x)
+			}
+			break
+		}
+		y, x := m.pop(), m.pop()
+		switch c.Arg1 {
+		case "add":
+			m.push(x + y)
+		case "sub":
+			m.push(x - y)
+		case "and":
+			m.push(x & y)
+		case "or":
+			m.push(x | y)
+		// the translator compares through x - y, which wraps around
+		case "eq":
+			m.push(truth(x-y == 0))
+		case "gt":
+			m.push(truth(x-y > 0))
+		case "lt":
+			m.push(truth(x-y < 0))
+		}
+	case parser.PUSH:
+		switch c.Arg1 {
+		case "constant":
+			m.push(int16(c.Arg2))
+		case "static":
+			m.push(m.statics[fmt.Sprintf("%s.%d", m.files[m.pc-1], c.Arg2)])
+		default:
+			m.push(m.ram[m.address(c.Arg1, c.Arg2)])
+		}
+	case parser.POP:
+		if c.Arg1 == "static" {
+			m.statics[fmt.Sprintf("%s.%d", m.files[m.pc-1], c.Arg2)] = m.pop()
+			break
+		}
+		a := m.address(c.Arg1, c.Arg2)
+		m.ram[a] = m.pop()
+	case parser.LABEL, parser.FUNCTION:
+		if c.Type == parser.FUNCTION {
+			for i := 0; i < c.Arg2; i++ {
+				m.push(0)
+			}
+		}
+	case parser.GOTO, parser.IF:
+		if c.Type == parser.IF && m.pop() == 0 {
+			break
+		}
+		target, ok := m.labels[m.scope[m.pc-1]+"$"+c.Arg1]
+		if !ok {
+			return fmt.Errorf("jump to undefined label %s", c.Arg1)
+		}
+		if c.Type == parser.GOTO && target == m.pc-2 {
+			m.halted = true // label X, goto X
+		}
+		m.pc = target
+	case parser.CALL:
+		target, ok := m.labels[c.Arg1]
+		if !ok {
+			return fmt.Errorf("call of undefined function %s", c.Arg1)
+		}
+		m.call(target, c.Arg2, m.pc)
+	case parser.RETURN:
+		frame := m.ram[1]
+		m.ram[m.ram[2]] = m.pop()
+		m.ram[0] = m.ram[2] + 1
+		for i, r := range []int{4, 3, 2, 1} {
+			m.ram[r] = m.ram[frame-int16(i)-1]
+		}
+		if len(m.frames) == 0 {
+			return fmt.Errorf("return without a call")
+		}
+		m.pc = m.frames[len(m.frames)-1]
+		m.frames = m.frames[:len(m.frames)-1]
+	}
+	return nil
+}
+
+// run runs the program from Sys.init, as the bootstrap code does, for at
+// most max commands.
+func (m *machine) run(max int) error {
+	m.ram[0] = 256
+	target, ok := m.labels["Sys.init"]
+	if !ok {
+		return fmt.Errorf("no Sys.init")
+	}
+	m.call(target, 0, len(m.cmds))
+	for n := 0; n < max && !m.halted; n++ {
+		if err := m.step(); err != nil {
+			return err
+		}
+	}
+	if !m.halted {
+		return fmt.Errorf("did not halt after %d commands", max)
+	}
+	return nil
+}
+
+// differential translates files with opt, runs the Hack code on the
+// emulator and files on a machine, and reports every word of RAM the two
+// disagree on. Only the stack is not compared, where the emulator keeps
+// ROM addresses.
+func differential(t *testing.T, files []parser.File, opt Options) {
+	t.Helper()
+	m := newMachine(files)
+	if err := m.run(1000000); err != nil {
+		t.Fatalf("machine: %v", err)
+	}
+
+	b := bytes.NewBufferString("")
+	if _, err := Translate(b, files, opt, 1); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := assembler.AssembleProgram(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := emulator.New(prog.Code)
+	if err := cpu.Run(50000000); err != nil || !cpu.Halted {
+		t.Fatalf("emulator did not halt: %v", err)
+	}
+
+	for a, w := range cpu.RAM {
+		if a >= 13 && a < 2048 {
+			continue // R13-R15, statics and the stack
+		}
+		if int16(w) != m.ram[a] {
+			t.Errorf("%+v: RAM[%d] = %d, want %d", opt, a, int16(w), m.ram[a])
+		}
+	}
+	for _, v := range prog.Variables {
+		if got, want := int16(cpu.RAM[v.Address]), m.statics[v.Name]; got != want {
+			t.Errorf("%+v: static %s = %d, want %d", opt, v.Name, got, want)
+		}
+	}
+}
+
+func TestCodeWriter_machine(t *testing.T) {
+	for seed := int64(0); seed < 8; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		for _, opt := range []Options{DefaultOptions, {Bootstrap: true, Optimize: 1}} {
+			differential(t, files, opt)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package codewriter
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"vmt/diag"
+	"vmt/statics"
+)
+
+// ROMSize is the number of instructions the Hack ROM holds.
+const ROMSize = 32768
+
+var predefined = regexp.MustCompile(`^(SP|LCL|ARG|THIS|THAT|SCREEN|KBD|R(1[0-5]|[0-9]))$`)
+
+// LinkMap describes where the translated program ends up in ROM and RAM.
+type LinkMap struct {
+	Bootstrap    int        // instructions before the first function
+	Functions    []Function // in ROM order
+	Statics      []Variable // in RAM order
+	Unresolved   []Variable // symbols the assembler would turn into variables
+	Instructions int        // total ROM used
+
+	overflow error // of the statics, see statics.Layout
+}
+
+// Function is a VM function placed in ROM at [Start, Start+Size).
+type Function struct {
+	Name  string
+	File  string
+	Start int
+	Size  int
+}
+
+// Variable is a symbol the assembler allocates in RAM.
+type Variable struct {
+	Symbol  string
+	File    string // static variables only
+	Index   int    // static variables only
+	Address int
+}
+
+// linker mirrors the symbol handling of the Hack assembler: labels are
+// resolved first, every other symbol becomes a variable allocated from RAM 16
+// in order of first appearance.
+type linker struct {
+	bootstrap int
+	functions []Function
+	labels    map[string]int
+	refs      []string
+	seen      map[string]bool
+}
+
+func (l *linker) define(label string, rom int) {
+	if l.labels == nil {
+		l.labels = map[string]int{}
+	}
+	l.labels[label] = rom
+}
+
+func (l *linker) reference(symbol string) {
+	if _, err := strconv.Atoi(symbol); err == nil || predefined.MatchString(symbol) {
+		return
+	}
+	if l.seen == nil {
+		l.seen = map[string]bool{}
+	}
+	if !l.seen[symbol] {
+		l.seen[symbol] = true
+		l.refs = append(l.refs, symbol)
+	}
+}
+
+// LinkMap returns the layout of everything written so far.
+func (cw *CodeWriter) LinkMap() *LinkMap {
+	lm := &LinkMap{
+		Bootstrap:    cw.link.bootstrap,
+		Instructions: cw.rom,
+	}
+	for i, f := range cw.link.functions {
+		end := cw.rom
+		if i+1 < len(cw.link.functions) {
+			end = cw.link.functions[i+1].Start
+		}
+		f.Size = end - f.Start
+		lm.Functions = append(lm.Functions, f)
+	}
+
+	var symbols []string
+	for _, sym := range cw.link.refs {
+		if _, ok := cw.link.labels[sym]; !ok {
+			symbols = append(symbols, sym)
+		}
+	}
+	alloc, err := statics.Layout(symbols)
+	lm.overflow = err
+	for _, s := range alloc.Statics {
+		lm.Statics = append(lm.Statics, Variable{Symbol: s.Symbol, File: s.File + ".vm", Index: s.Index, Address: s.Address})
+	}
+	for _, u := range alloc.Unresolved {
+		lm.Unresolved = append(lm.Unresolved, Variable{Symbol: u.Symbol, Address: u.Address})
+	}
+	return lm
+}
+
+// Check reports an error when the program does not fit in ROM or its
+// variables run past the static segment into the stack.
+func (lm *LinkMap) Check() error {
+	if lm.Instructions > ROMSize {
+		return diag.Errorf("rom-overflow", "program needs %d instructions, ROM holds %d", lm.Instructions, ROMSize)
+	}
+	return lm.overflow
+}
+
+// WriteTo writes the link map as a human readable table.
+func (lm *LinkMap) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ROM\tfile\t start\t  size")
+	fmt.Fprintf(tw, "(bootstrap)\t\t%6d\t%6d\n", 0, lm.Bootstrap)
+	for _, f := range lm.Functions {
+		fmt.Fprintf(tw, "%s\t%s\t%6d\t%6d\n", f.Name, f.File, f.Start, f.Size)
+	}
+	fmt.Fprintf(tw, "total\t\t\t%6d  %.1f%% of %d\n", lm.Instructions, percent(lm.Instructions, ROMSize), ROMSize)
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "RAM\tfile\tstatic\taddress")
+	for _, v := range lm.Statics {
+		fmt.Fprintf(tw, "%s\t%s\t%6d\t%6d\n", v.Symbol, v.File, v.Index, v.Address)
+	}
+	fmt.Fprintf(tw, "total\t\t\t%6d  %.1f%% of %d\n", len(lm.Statics), percent(len(lm.Statics), statics.Slots), statics.Slots)
+	tw.Flush()
+
+	if len(lm.Unresolved) > 0 {
+		fmt.Fprintln(cw)
+		fmt.Fprintln(cw, "unresolved symbols, allocated as variables by the assembler:")
+		for _, v := range lm.Unresolved {
+			fmt.Fprintf(cw, "  %s %d\n", v.Symbol, v.Address)
+		}
+	}
+	if err := lm.Check(); err != nil {
+		fmt.Fprintf(cw, "\nerror: %v\n", err)
+	}
+	return cw.n, cw.err
+}
+
+func percent(n, total int) float64 {
+	return float64(n) * 100 / float64(total)
+}
+
+// countWriter remembers the bytes written and the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,144 @@
+package codewriter
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/diag"
+	"vmt/generator"
+	"vmt/parser"
+	"vmt/statics"
+)
+
+func TestCodeWriter_LinkMap(t *testing.T) {
+	b := bytes.NewBufferString("")
+	cw := New(b)
+	program := []parser.File{
+		{
+			Name: "Main",
+			Commands: []parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.main", Arg2: 0},
+				{Type: parser.PUSH, Arg1: "static", Arg2: 3},
+				{Type: parser.CALL, Arg1: "Math.abs", Arg2: 1},
+				{Type: parser.POP, Arg1: "static", Arg2: 0},
+				{Type: parser.RETURN},
+			},
+		},
+		{
+			Name: "Sys",
+			Commands: []parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Sys.init", Arg2: 0},
+				{Type: parser.CALL, Arg1: "Main.main", Arg2: 0},
+				{Type: parser.POP, Arg1: "static", Arg2: 0},
+				{Type: parser.LABEL, Arg1: "END"},
+				{Type: parser.GOTO, Arg1: "END"},
+			},
+		},
+	}
+	for _, f := range program {
+		cw.SetFileName(f.Name)
+		for _, c := range f.Commands {
+			cw.WriteCommand(c)
+		}
+	}
+
+	lm := cw.LinkMap()
+	if lm.Bootstrap != 53 {
+		t.Errorf("Bootstrap = %d, want 53", lm.Bootstrap)
+	}
+	if len(lm.Functions) != 2 || lm.Functions[0].Start != 53 || lm.Functions[1].Name != "Sys.init" {
+		t.Fatalf("Functions = %+v", lm.Functions)
+	}
+	if end := lm.Functions[1].Start + lm.Functions[1].Size; end != lm.Instructions {
+		t.Errorf("last function ends at %d, want %d", end, lm.Instructions)
+	}
+	if lm.Functions[0].Start+lm.Functions[0].Size != lm.Functions[1].Start {
+		t.Errorf("functions are not contiguous: %+v", lm.Functions)
+	}
+
+	wantStatics := []Variable{
+		{Symbol: "Main.3", File: "Main.vm", Index: 3, Address: 16},
+		{Symbol: "Main.0", File: "Main.vm", Index: 0, Address: 18},
+		{Symbol: "Sys.0", File: "Sys.vm", Index: 0, Address: 19},
+	}
+	if !reflect.DeepEqual(lm.Statics, wantStatics) {
+		t.Errorf("Statics = %+v, want %+v", lm.Statics, wantStatics)
+	}
+	wantUnresolved := []Variable{{Symbol: "Math.abs", Address: 17}}
+	if !reflect.DeepEqual(lm.Unresolved, wantUnresolved) {
+		t.Errorf("Unresolved = %+v, want %+v", lm.Unresolved, wantUnresolved)
+	}
+	if err := lm.Check(); err != nil {
+		t.Errorf("Check() = %v", err)
+	}
+
+	out := bytes.NewBufferString("")
+	lm.WriteTo(out)
+	for _, want := range []string{"Main.main", "Sys.init", "Main.3", "Math.abs"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteTo() does not mention %s:\n%s", want, out)
+		}
+	}
+}
+
+func TestLinkMap_Check(t *testing.T) {
+	lm := &LinkMap{Instructions: ROMSize + 1}
+	if err := lm.Check(); err == nil {
+		t.Errorf("Check() = nil for %d instructions", lm.Instructions)
+	}
+	out := bytes.NewBufferString("")
+	lm.WriteTo(out)
+	if !strings.Contains(out.String(), "error:") {
+		t.Errorf("WriteTo() does not report the overflow:\n%s", out)
+	}
+}
+
+// The link map reports the overflow of the statics as Allocate does.
+func TestCodeWriter_LinkMap_overflow(t *testing.T) {
+	f := parser.File{Name: "Big"}
+	for i := 0; i <= statics.Slots; i++ {
+		f.Commands = append(f.Commands, parser.Command{Type: parser.PUSH, Arg1: "static", Arg2: i, Line: i + 1})
+	}
+	cw := New(bytes.NewBufferString(""))
+	cw.SetFileName(f.Name)
+	for _, c := range f.Commands {
+		cw.WriteCommand(c)
+	}
+	_, want := statics.Allocate([]parser.File{f})
+	var got, d *diag.Diagnostic
+	if !errors.As(cw.LinkMap().Check(), &got) || !errors.As(want, &d) || got.Rule != d.Rule || got.Message != d.Message {
+		t.Errorf("Check() = %v, want %v", got, want)
+	}
+}
+
+// The static allocation predicted from the VM commands must match the one the
+// assembler makes from the generated code.
+func TestCodeWriter_LinkMap_matchesAllocate(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		cw := New(bytes.NewBufferString(""))
+		for _, f := range files {
+			cw.SetFileName(f.Name)
+			for _, c := range f.Commands {
+				cw.WriteCommand(c)
+			}
+		}
+		alloc, err := statics.Allocate(files)
+		if err != nil {
+			t.Fatalf("seed %d: Allocate() error = %v", seed, err)
+		}
+
+		lm := cw.LinkMap()
+		if len(lm.Statics) != len(alloc.Statics) || len(lm.Unresolved) != len(alloc.Unresolved) {
+			t.Fatalf("seed %d: LinkMap() has %d statics, %d unresolved; Allocate() %d, %d",
+				seed, len(lm.Statics), len(lm.Unresolved), len(alloc.Statics), len(alloc.Unresolved))
+		}
+		for i, s := range alloc.Statics {
+			if v := lm.Statics[i]; v.Symbol != s.Symbol || v.Address != s.Address {
+				t.Errorf("seed %d: LinkMap() static %s at %d, Allocate() %s at %d", seed, v.Symbol, v.Address, s.Symbol, s.Address)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package codewriter
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/assembler"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+func TestCodeWriter_write_mode(t *testing.T) {
+	tests := []struct {
+		mode Mode
+		want string
+	}{
+		{
+			Compact,
+			`@7
+D=A
+@SP
+A=M
+M=D
+@SP
+M=M+1
+(Main$L)
+@Main$L
+0;JMP
+`,
+		},
+		{
+			Annotated,
+			`// Main.vm:1: push constant 7
+@7              // 0
+D=A             // 1
+@SP             // 2
+A=M             // 3
+M=D             // 4
+@SP             // 5
+M=M+1           // 6
+// Main.vm:2: label L
+(Main$L)
+// Main.vm:3: goto L
+@Main$L         // 7
+0;JMP           // 8
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			b := bytes.NewBufferString("")
+			cw := NewWithOptions(b, Options{Mode: tt.mode})
+			cw.SetFileName("Main")
+			cw.WriteCommand(parser.Command{Type: parser.PUSH, Arg1: "constant", Arg2: 7, Line: 1})
+			cw.WriteCommand(parser.Command{Type: parser.LABEL, Arg1: "L", Line: 2})
+			cw.WriteCommand(parser.Command{Type: parser.GOTO, Arg1: "L", Line: 3})
+			if b.String() != tt.want {
+				t.Errorf("write() =\n%s\nwant\n%s", b, tt.want)
+			}
+			if want := strings.Count(tt.want, "\n"); cw.lines != want {
+				t.Errorf("lines = %d, want %d", cw.lines, want)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, m := range []Mode{Verbose, Compact, Annotated} {
+		if got, err := ParseMode(m.String()); got != m || err != nil {
+			t.Errorf("ParseMode(%q) = %v, %v", m, got, err)
+		}
+	}
+	if _, err := ParseMode("terse"); err == nil {
+		t.Error("ParseMode(terse) error = nil")
+	}
+}
+
+// Every mode must assemble to the same code, with source maps that differ
+// only in the lines of the assembly.
+func TestMode_generated(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		want, wcw := sequential(files, DefaultOptions)
+		wantCode, err := assembler.Assemble(strings.NewReader(want))
+		if err != nil {
+			t.Fatalf("seed %d: Assemble() error = %v", seed, err)
+		}
+		for _, mode := range []Mode{Compact, Annotated} {
+			opt := DefaultOptions
+			opt.Mode = mode
+			b := bytes.NewBufferString("")
+			cw, err := Translate(b, files, opt, 4)
+			if err != nil {
+				t.Fatalf("seed %d, %s: Translate() error = %v", seed, mode, err)
+			}
+			if s, _ := sequential(files, opt); b.String() != s {
+				t.Fatalf("seed %d, %s: Translate() differs from writing the files in order", seed, mode)
+			}
+			code, err := assembler.Assemble(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatalf("seed %d, %s: Assemble() error = %v", seed, mode, err)
+			}
+			if !reflect.DeepEqual(code, wantCode) {
+				t.Errorf("seed %d, %s: machine code differs from verbose", seed, mode)
+			}
+
+			lines := strings.Split(b.String(), "\n")
+			for i, m := range cw.SourceMap().Mappings {
+				w := wcw.SourceMap().Mappings[i]
+				if m.Start != w.Start || m.End != w.End || m.Command != w.Command {
+					t.Fatalf("seed %d, %s: mapping %+v, want %+v", seed, mode, m, w)
+				}
+				if mode == Annotated && !strings.HasSuffix(lines[m.AsmStart-1], m.Command) {
+					t.Fatalf("seed %d: line %d is %q, want the command %s", seed, m.AsmStart, lines[m.AsmStart-1], m.Command)
+				}
+			}
+			if got := len(lines) - 1; cw.lines != got {
+				t.Errorf("seed %d, %s: lines = %d, want %d", seed, mode, cw.lines, got)
+			}
+		}
+	}
+}
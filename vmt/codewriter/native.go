@@ -0,0 +1,240 @@
+package codewriter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"vmt/parser"
+)
+
+// Emitter writes the statements of a native target, such as C, for Native.
+// Its methods write to the body of the program with Printf; names are the
+// labels Native gives functions and VM labels.
+type Emitter interface {
+	// Comment returns text as a comment of the target.
+	Comment(text string) string
+	// Bootstrap sets SP to 256, before the call of the entry function.
+	Bootstrap()
+	Arithmetic(cmd string)
+	// PushPop pushes or pops a word; the symbol of a static variable is
+	// the Static of Native.
+	PushPop(cmd parser.Type, segment string, index int)
+	Goto(name string)
+	// Halt stops the program at the loop the emulator halts at.
+	Halt()
+	If(name string)
+	// PushZero pushes a local variable of a function.
+	PushZero()
+	// Call saves the frame of the caller, with the return address ret, and
+	// jumps to the function name with numargs arguments.
+	Call(name string, numargs, ret int)
+	// Return restores the frame of the caller and jumps to the return
+	// address, through the dispatch of Returns.
+	Return()
+	// WriteProgram writes the whole program when Native is closed.
+	WriteProgram() error
+}
+
+/*
+Native is the Backend part that native targets share. It writes every
+command to a Shadow as well, names functions, VM labels and return addresses
+with labels F_, L_ and R_ and the Identifier of their symbol, and keeps
+track of the labels that are jumped to, so that WriteBody can leave out the
+others and WriteProgram can make up the missing ones.
+
+A target embeds Native, calls Init with itself as the Emitter and writes its
+program in WriteProgram.
+*/
+type Native struct {
+	e      Emitter
+	shadow *CodeWriter
+	fn     string
+	body   bytes.Buffer
+
+	returns  []int             // return addresses, in the order of the calls
+	returned bool              // a function returns
+	defined  map[string]bool   // labels of functions and VM labels
+	jumps    map[string]string // labels jumped to, with their symbol
+	missing  []string
+}
+
+// Init sets up n to write the statements of e. Of opt, Bootstrap and Entry
+// apply, and Optimize places the return addresses where the optimized Hack
+// code has them.
+func (n *Native) Init(e Emitter, opt Options) {
+	*n = Native{
+		e:       e,
+		shadow:  Shadow(opt),
+		defined: map[string]bool{},
+		jumps:   map[string]string{},
+	}
+	if opt.Bootstrap {
+		entry := opt.Entry
+		if entry == "" {
+			entry = "Sys.init"
+		}
+		// the shadow has written the bootstrap code already
+		n.comment("bootstrap")
+		e.Bootstrap()
+		n.call(entry, 0, n.shadow.ROM())
+	}
+}
+
+// Printf writes a statement, indented by a tab.
+func (n *Native) Printf(format string, a ...interface{}) {
+	n.body.WriteString("\t")
+	fmt.Fprintf(&n.body, format, a...)
+	n.body.WriteString("\n")
+}
+
+func (n *Native) comment(format string, a ...interface{}) {
+	n.Printf("%s", n.e.Comment(fmt.Sprintf(format, a...)))
+}
+
+// label writes a label, which WriteBody leaves out when nothing jumps to it.
+func (n *Native) label(name string) {
+	n.defined[name] = true
+	fmt.Fprintf(&n.body, "%s:\n", name)
+}
+
+// Static returns the symbol of static variable index of the current file.
+func (n *Native) Static(index int) string {
+	return fmt.Sprintf("%s.%d", n.fn, index)
+}
+
+// SetFileName starts the file fn.
+func (n *Native) SetFileName(fn string) {
+	n.fn = fn
+	n.shadow.SetFileName(fn)
+	n.body.WriteString("\n")
+	n.comment("%s.vm", fn)
+}
+
+func (n *Native) WriteArithmetic(cmd string) {
+	n.shadow.WriteArithmetic(cmd)
+	n.e.Arithmetic(cmd)
+}
+
+func (n *Native) WritePushPop(cmd parser.Type, segment string, index int) {
+	n.shadow.WritePushPop(cmd, segment, index)
+	n.comment("%s", parser.Command{Type: cmd, Arg1: segment, Arg2: index})
+	n.e.PushPop(cmd, segment, index)
+}
+
+func (n *Native) WriteLabel(label string) {
+	name := "L_" + Identifier(n.shadow.LabelSymbol(label))
+	n.shadow.WriteLabel(label)
+	n.label(name)
+}
+
+func (n *Native) WriteGoto(label string) {
+	sym := n.shadow.LabelSymbol(label)
+	halts := n.shadow.Halts(label)
+	n.shadow.WriteGoto(label)
+	if halts {
+		n.e.Halt()
+		return
+	}
+	name := "L_" + Identifier(sym)
+	n.jumps[name] = sym
+	n.e.Goto(name)
+}
+
+func (n *Native) WriteIf(label string) {
+	sym := n.shadow.LabelSymbol(label)
+	n.shadow.WriteIf(label)
+	name := "L_" + Identifier(sym)
+	n.jumps[name] = sym
+	n.e.If(name)
+}
+
+func (n *Native) WriteFunction(funcname string, numlocal int) {
+	n.shadow.WriteFunction(funcname, numlocal)
+	n.body.WriteString("\n")
+	n.comment("function %s %d", funcname, numlocal)
+	n.label("F_" + Identifier(funcname))
+	for i := 0; i < numlocal; i++ {
+		n.e.PushZero()
+	}
+}
+
+func (n *Native) WriteCall(funcname string, numargs int) {
+	n.shadow.WriteCall(funcname, numargs)
+	n.comment("call %s %d", funcname, numargs)
+	n.call(funcname, numargs, n.shadow.ROM())
+}
+
+// call writes a call of funcname that returns to address ret.
+func (n *Native) call(funcname string, numargs int, ret int) {
+	name := "F_" + Identifier(funcname)
+	n.jumps[name] = funcname
+	n.returns = append(n.returns, ret)
+	n.e.Call(name, numargs, ret)
+	n.label(fmt.Sprintf("R_%d", ret))
+}
+
+func (n *Native) WriteReturn() {
+	n.shadow.WriteReturn()
+	n.returned = true
+	n.comment("return")
+	n.e.Return()
+}
+
+// Close writes the program with WriteProgram.
+func (n *Native) Close() error {
+	// jumps to undefined labels and functions go nowhere
+	n.missing = nil
+	for name := range n.jumps {
+		if !n.defined[name] {
+			n.missing = append(n.missing, name)
+		}
+	}
+	sort.Strings(n.missing)
+	if n.returned {
+		for _, ret := range n.returns {
+			n.jumps[fmt.Sprintf("R_%d", ret)] = ""
+		}
+	}
+	return n.e.WriteProgram()
+}
+
+// Statics returns the static variables, where the Hack code has them.
+func (n *Native) Statics() []Variable {
+	return n.shadow.LinkMap().Statics
+}
+
+// Returned reports whether a function returns, through a dispatch over the
+// return addresses to their labels R_.
+func (n *Native) Returned() bool {
+	return n.returned
+}
+
+// Returns returns the return addresses, in the order of the calls.
+func (n *Native) Returns() []int {
+	return n.returns
+}
+
+// Missing returns the labels that are jumped to but not defined, in order.
+func (n *Native) Missing() []string {
+	return n.missing
+}
+
+// Symbol returns the VM label or function a label jumped to stands for.
+func (n *Native) Symbol(name string) string {
+	return n.jumps[name]
+}
+
+// WriteBody writes the statements to w, without the labels nothing jumps to.
+func (n *Native) WriteBody(w io.Writer) error {
+	for _, line := range strings.SplitAfter(n.body.String(), "\n") {
+		if _, ok := n.jumps[strings.TrimSuffix(line, ":\n")]; strings.HasSuffix(line, ":\n") && !ok {
+			continue
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package codewriter
+
+import (
+	"bytes"
+	"testing"
+	"vmt/assembler"
+	"vmt/emulator"
+	"vmt/generator"
+)
+
+func TestCodeWriter_write_optimize(t *testing.T) {
+	b := bytes.NewBufferString("")
+	cw := &CodeWriter{
+		w:   b,
+		opt: Options{Optimize: 1},
+	}
+	cw.writePushConstant(7)
+	cw.writePopRegister(5)
+	want := `
+// push constant 7
+@7
+D=A
+@SP
+A=M
+M=D
+@SP
+M=M+1
+
+// pop register R5
+M=M-1
+A=M
+D=M
+@R5
+M=D
+`
+	if b.String() != want {
+		t.Errorf("write() = %s, want %s", b, want)
+	}
+	if cw.rom != 12 {
+		t.Errorf("rom = %d, want 12", cw.rom)
+	}
+}
+
+// Optimized and unoptimized code must leave the same RAM behind, apart from
+// return addresses: the stack frames and R14 hold ROM addresses, which move.
+func TestCodeWriter_optimize_generated(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		var ram [2]*emulator.CPU
+		for level := range ram {
+			b := bytes.NewBufferString("")
+			cw := NewWithOptions(b, Options{Bootstrap: true, Optimize: level})
+			for _, f := range generator.Generate(seed, generator.DefaultConfig) {
+				cw.SetFileName(f.Name)
+				for _, c := range f.Commands {
+					cw.WriteCommand(c)
+				}
+			}
+			code, err := assembler.Assemble(b)
+			if err != nil {
+				t.Fatalf("seed %d: Assemble() error = %v", seed, err)
+			}
+			cpu := emulator.New(code)
+			if err := cpu.Run(10000000); err != nil {
+				t.Fatalf("seed %d: Run() error = %v", seed, err)
+			}
+			if !cpu.Halted {
+				t.Fatalf("seed %d: program did not halt", seed)
+			}
+			ram[level] = cpu
+		}
+		for a := 0; a < emulator.RAMSize; a++ {
+			if a == 14 || a >= 256 && a < 2048 {
+				continue
+			}
+			if ram[0].RAM[a] != ram[1].RAM[a] {
+				t.Errorf("seed %d: -O1 changed RAM[%d] from %d to %d", seed, a, ram[0].RAM[a], ram[1].RAM[a])
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package codewriter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SourceMap ties ranges of the generated assembly back to the VM commands
+// they were generated from. Mappings are ordered by ROM address.
+type SourceMap struct {
+	Mappings []Mapping `json:"mappings"`
+}
+
+/*
+Mapping covers the code generated for one VM command.
+
+Instructions occupy ROM addresses [Start, End) and the template occupies
+lines [AsmStart, AsmEnd) of the .asm file, both counted as the Hack assembler
+does: ROM addresses from 0, lines from 1. Commands that generate no
+instructions, such as label, have Start == End.
+
+The bootstrap code has no File and the Command "bootstrap".
+*/
+type Mapping struct {
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	AsmStart int    `json:"asmStart"`
+	AsmEnd   int    `json:"asmEnd"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Function string `json:"function,omitempty"`
+	Command  string `json:"command"`
+}
+
+// SourceMap returns the mappings for everything written through WriteCommand
+// so far, including the bootstrap code written by New.
+func (cw *CodeWriter) SourceMap() *SourceMap {
+	return &cw.smap
+}
+
+// Lookup returns the mapping whose instructions include ROM address addr.
+func (sm *SourceMap) Lookup(addr int) (Mapping, bool) {
+	i := sort.Search(len(sm.Mappings), func(i int) bool {
+		return sm.Mappings[i].End > addr
+	})
+	for ; i < len(sm.Mappings); i++ {
+		m := sm.Mappings[i]
+		if m.Start > addr {
+			break
+		}
+		if m.Start < m.End {
+			return m, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// WriteTo writes the source map as JSON.
+func (sm *SourceMap) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+func (cw *CodeWriter) beginMapping(line int, cmd string) int {
+	file := ""
+	if cw.fn != "" {
+		file = cw.fn + ".vm"
+	}
+	cw.smap.Mappings = append(cw.smap.Mappings, Mapping{
+		Start:    cw.rom,
+		AsmStart: cw.lines + 1,
+		File:     file,
+		Line:     line,
+		Command:  cmd,
+	})
+	if cw.opt.Mode == Annotated {
+		if file != "" {
+			cmd = fmt.Sprintf("%s:%d: %s", file, line, cmd)
+		}
+		cw.lines++
+		fmt.Fprintf(cw.w, "// %s\n", cmd)
+	}
+	return len(cw.smap.Mappings) - 1
+}
+
+// endMapping closes mapping i. The function is taken afterwards so that a
+// function command maps to the function it declares.
+func (cw *CodeWriter) endMapping(i int) {
+	m := &cw.smap.Mappings[i]
+	m.End = cw.rom
+	m.AsmEnd = cw.lines + 1
+	m.Function = cw.function
+}
@@ -0,0 +1,68 @@
+package codewriter
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/parser"
+)
+
+func TestCodeWriter_SourceMap(t *testing.T) {
+	b := bytes.NewBufferString("")
+	cw := &CodeWriter{
+		w:  b,
+		fn: "Test",
+	}
+	cmds := []parser.Command{
+		{Type: parser.FUNCTION, Arg1: "Test.main", Arg2: 1, Line: 3},
+		{Type: parser.LABEL, Arg1: "LOOP", Line: 4},
+		{Type: parser.PUSH, Arg1: "constant", Arg2: 7, Line: 5},
+		{Type: parser.GOTO, Arg1: "LOOP", Line: 6},
+	}
+	for _, c := range cmds {
+		cw.WriteCommand(c)
+	}
+
+	want := []Mapping{
+		{Start: 0, End: 7, AsmStart: 1, AsmEnd: 13, File: "Test.vm", Line: 3, Function: "Test.main", Command: "function Test.main 1"},
+		{Start: 7, End: 7, AsmStart: 13, AsmEnd: 16, File: "Test.vm", Line: 4, Function: "Test.main", Command: "label LOOP"},
+		{Start: 7, End: 14, AsmStart: 16, AsmEnd: 25, File: "Test.vm", Line: 5, Function: "Test.main", Command: "push constant 7"},
+		{Start: 14, End: 16, AsmStart: 25, AsmEnd: 29, File: "Test.vm", Line: 6, Function: "Test.main", Command: "goto LOOP"},
+	}
+	if got := cw.SourceMap().Mappings; !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceMap() = %+v, want %+v", got, want)
+	}
+	if lines := strings.Count(b.String(), "\n"); lines != 28 {
+		t.Errorf("wrote %d lines, want 28", lines)
+	}
+}
+
+func TestSourceMap_Lookup(t *testing.T) {
+	sm := &SourceMap{
+		Mappings: []Mapping{
+			{Start: 0, End: 7, Command: "push constant 1"},
+			{Start: 7, End: 7, Command: "label LOOP"},
+			{Start: 7, End: 9, Command: "goto LOOP"},
+		},
+	}
+	tests := []struct {
+		name string
+		addr int
+		want string
+		ok   bool
+	}{
+		{"first", 0, "push constant 1", true},
+		{"last of first", 6, "push constant 1", true},
+		{"skips empty mapping", 7, "goto LOOP", true},
+		{"past the end", 9, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := sm.Lookup(tt.addr)
+			if ok != tt.ok || got.Command != tt.want {
+				t.Errorf("Lookup(%d) = %v, %v, want %v, %v", tt.addr, got.Command, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
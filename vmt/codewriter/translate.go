@@ -0,0 +1,176 @@
+package codewriter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"vmt/parser"
+)
+
+// Version identifies the code the translator generates. Change it whenever
+// a template changes, so that cached fragments are not reused.
+const Version = "2"
+
+/*
+Fragment is the code generated for one file, written from ROM address 0,
+together with its part of the source and link maps. Since SetFileName starts
+every file afresh, a fragment does not depend on the files before it and can
+be generated on its own, cached and appended anywhere.
+*/
+type Fragment struct {
+	File      string
+	Asm       []byte
+	ROM       int
+	Lines     int
+	Mappings  []Mapping
+	Functions []Function
+	Labels    map[string]int
+	Refs      []string
+	Function  string // function the file ends in
+}
+
+// Cache stores fragments by Key. Implementations must be safe for use by
+// several goroutines.
+type Cache interface {
+	Get(key string) (*Fragment, bool)
+	Put(key string, f *Fragment)
+}
+
+// Key returns the cache key of the fragment of f translated with opt: a hash
+// of Version, opt, the file name and the commands with their lines.
+func Key(f parser.File, opt Options) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "vmt %s\n%+v\n%s\n", Version, opt, f.Name)
+	for _, c := range f.Commands {
+		fmt.Fprintf(h, "%d %s\n", c.Line, c)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/*
+Translate writes the program files to w, generating the code of up to
+workers files at the same time.
+
+Each file is written to its own fragment, and the fragments are appended in
+the order of files, moving their source and link maps to where they end up.
+The result is byte for byte what writing the files in order through one
+CodeWriter gives.
+*/
+func Translate(w io.Writer, files []parser.File, opt Options, workers int) (*CodeWriter, error) {
+	return TranslateCached(w, files, opt, workers, nil)
+}
+
+// TranslateCached is Translate that takes the fragments of files whose Key
+// is in cache from there and adds the others. cache may be nil.
+//
+// The Annotated mode writes ROM addresses, which depend on the files before,
+// so its files are written in order through one CodeWriter: workers and
+// cache are ignored.
+func TranslateCached(w io.Writer, files []parser.File, opt Options, workers int, cache Cache) (*CodeWriter, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if opt.Mode == Annotated {
+		b := bytes.NewBufferString("")
+		cw := NewWithOptions(b, opt)
+		for _, f := range files {
+			cw.SetFileName(f.Name)
+			for _, c := range f.Commands {
+				cw.WriteCommand(c)
+			}
+		}
+		cw.w = w
+		_, err := w.Write(b.Bytes())
+		return cw, err
+	}
+	cw := NewWithOptions(w, opt)
+
+	frags := make([]*Fragment, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				frags[i] = fragment(files[i], opt, cache)
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, f := range frags {
+		if err := cw.append(f); err != nil {
+			return cw, err
+		}
+	}
+	return cw, nil
+}
+
+func fragment(f parser.File, opt Options, cache Cache) *Fragment {
+	key := ""
+	if cache != nil {
+		key = Key(f, opt)
+		if fr, ok := cache.Get(key); ok {
+			return fr
+		}
+	}
+
+	b := bytes.NewBufferString("")
+	p := &CodeWriter{w: b, opt: opt}
+	p.SetFileName(f.Name)
+	for _, c := range f.Commands {
+		p.WriteCommand(c)
+	}
+	fr := &Fragment{
+		File:      f.Name,
+		Asm:       b.Bytes(),
+		ROM:       p.rom,
+		Lines:     p.lines,
+		Mappings:  p.smap.Mappings,
+		Functions: p.link.functions,
+		Labels:    p.link.labels,
+		Refs:      p.link.refs,
+		Function:  p.function,
+	}
+	if cache != nil {
+		cache.Put(key, fr)
+	}
+	return fr
+}
+
+// append writes f after the code of cw and moves its maps there. Counters
+// and the A register are not carried over, SetFileName resets them anyway.
+func (cw *CodeWriter) append(f *Fragment) error {
+	for _, m := range f.Mappings {
+		m.Start += cw.rom
+		m.End += cw.rom
+		m.AsmStart += cw.lines
+		m.AsmEnd += cw.lines
+		cw.smap.Mappings = append(cw.smap.Mappings, m)
+	}
+	for _, fn := range f.Functions {
+		fn.Start += cw.rom
+		cw.link.functions = append(cw.link.functions, fn)
+	}
+	for label, rom := range f.Labels {
+		cw.link.define(label, rom+cw.rom)
+	}
+	for _, sym := range f.Refs {
+		cw.link.reference(sym)
+	}
+
+	cw.rom += f.ROM
+	cw.lines += f.Lines
+	cw.fn, cw.function = f.File, f.Function
+	cw.addr, cw.callcnt, cw.a = 0, 0, ""
+	_, err := cw.w.Write(f.Asm)
+	return err
+}
@@ -0,0 +1,149 @@
+package codewriter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+// sequential writes files in order through one CodeWriter.
+func sequential(files []parser.File, opt Options) (string, *CodeWriter) {
+	b := bytes.NewBufferString("")
+	cw := NewWithOptions(b, opt)
+	for _, f := range files {
+		cw.SetFileName(f.Name)
+		for _, c := range f.Commands {
+			cw.WriteCommand(c)
+		}
+	}
+	return b.String(), cw
+}
+
+func TestTranslate(t *testing.T) {
+	for seed := int64(0); seed < 16; seed++ {
+		for _, opt := range []Options{DefaultOptions, {Optimize: 1}} {
+			files := generator.Generate(seed, generator.DefaultConfig)
+			want, wcw := sequential(files, opt)
+			for _, workers := range []int{1, 3, 8} {
+				b := bytes.NewBufferString("")
+				cw, err := Translate(b, files, opt, workers)
+				if err != nil {
+					t.Fatalf("seed %d: Translate() error = %v", seed, err)
+				}
+				if b.String() != want {
+					t.Fatalf("seed %d, %d workers: Translate() differs from writing the files in order", seed, workers)
+				}
+				if !reflect.DeepEqual(cw.SourceMap(), wcw.SourceMap()) {
+					t.Errorf("seed %d, %d workers: SourceMap() differs", seed, workers)
+				}
+				if !reflect.DeepEqual(cw.LinkMap(), wcw.LinkMap()) {
+					t.Errorf("seed %d, %d workers: LinkMap() = %+v, want %+v", seed, workers, cw.LinkMap(), wcw.LinkMap())
+				}
+			}
+		}
+	}
+}
+
+func TestTranslate_fileIndependent(t *testing.T) {
+	// the code of a file does not change when files are added before it
+	files := generator.Generate(1, generator.DefaultConfig)
+	last := files[len(files)-1]
+	alone, _ := sequential([]parser.File{last}, Options{})
+	all, _ := sequential(files, Options{})
+	if !bytes.HasSuffix([]byte(all), []byte(alone)) {
+		t.Errorf("code of %s.vm depends on the files before it", last.Name)
+	}
+}
+
+// memCache is a Cache that counts its hits.
+type memCache struct {
+	mu    sync.Mutex
+	frags map[string]*Fragment
+	hits  int
+}
+
+func (c *memCache) Get(key string) (*Fragment, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.frags[key]
+	if ok {
+		c.hits++
+	}
+	return f, ok
+}
+
+func (c *memCache) Put(key string, f *Fragment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frags[key] = f
+}
+
+func TestTranslateCached(t *testing.T) {
+	files := generator.Generate(2, generator.DefaultConfig)
+	cache := &memCache{frags: map[string]*Fragment{}}
+	translate := func(opt Options) string {
+		b := bytes.NewBufferString("")
+		if _, err := TranslateCached(b, files, opt, 2, cache); err != nil {
+			t.Fatalf("TranslateCached() error = %v", err)
+		}
+		return b.String()
+	}
+
+	first := translate(DefaultOptions)
+	if cache.hits != 0 || len(cache.frags) != len(files) {
+		t.Fatalf("first run: %d hits, %d fragments", cache.hits, len(cache.frags))
+	}
+	if again := translate(DefaultOptions); again != first || cache.hits != len(files) {
+		t.Errorf("second run: %d hits, same output %v", cache.hits, again == first)
+	}
+
+	// a changed file and changed options are translated again
+	cache.hits = 0
+	files[1].Commands = append(files[1].Commands, parser.Command{Type: parser.ARITHMETIC, Arg1: "add", Line: 9999})
+	want, _ := sequential(files, DefaultOptions)
+	if got := translate(DefaultOptions); got != want || cache.hits != len(files)-1 {
+		t.Errorf("changed file: %d hits, output as written in order %v", cache.hits, got == want)
+	}
+	cache.hits = 0
+	translate(Options{Optimize: 1})
+	if cache.hits != 0 {
+		t.Errorf("changed options: %d hits", cache.hits)
+	}
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTranslateCached_writeError(t *testing.T) {
+	files := generator.Generate(3, generator.DefaultConfig)
+	for _, mode := range []Mode{Verbose, Compact, Annotated} {
+		opt := DefaultOptions
+		opt.Mode = mode
+		if _, err := TranslateCached(failWriter{}, files, opt, 2, nil); err == nil {
+			t.Errorf("%v: TranslateCached() error = nil, want the write error", mode)
+		}
+	}
+}
+
+func BenchmarkTranslate(b *testing.B) {
+	cfg := generator.DefaultConfig
+	cfg.Files, cfg.Functions = 32, 16
+	files := generator.Generate(1, cfg)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Translate(ioutil.Discard, files, DefaultOptions, workers)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Package diag describes problems found in VM programs so that both people and
+tools can read them.
+
+A Diagnostic is an error, so packages return it where they used to return a
+plain error. List collects several. Write prints diagnostics as text in the
+usual "file:line:column: severity: message" form, as JSON, or as SARIF 2.1.0
+for code scanning tools.
+*/
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Severity is how bad a problem is.
+type Severity string
+
+// Severities, named as in SARIF.
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+	Note    Severity = "note"
+)
+
+// Diagnostic is a problem at a place in a source file. Line and Column are
+// 1-based; 0 means unknown.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Message  string   `json:"message"`
+}
+
+// Errorf returns an error diagnostic for rule without a position.
+func Errorf(rule, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Rule: rule, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warningf returns a warning for rule without a position.
+func Warningf(rule, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Warning, Rule: rule, Message: fmt.Sprintf(format, args...)}
+}
+
+// At sets the position of d and returns it.
+func (d *Diagnostic) At(file string, line, column int) *Diagnostic {
+	d.File, d.Line, d.Column = file, line, column
+	return d
+}
+
+// Error returns the position and the message, e.g.
+// "Main.vm:3:6: missing second argument in "push constant"".
+func (d *Diagnostic) Error() string {
+	if pos := d.position(); pos != "" {
+		return pos + ": " + d.Message
+	}
+	return d.Message
+}
+
+// position returns "file:line:column" with the parts that are known, or
+// "line 3" when only the line is.
+func (d *Diagnostic) position() string {
+	if d.File == "" && d.Line > 0 {
+		return fmt.Sprintf("line %d", d.Line)
+	}
+	pos := d.File
+	if d.Line > 0 {
+		pos += fmt.Sprintf(":%d", d.Line)
+		if d.Column > 0 {
+			pos += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	return pos
+}
+
+// List is a list of diagnostics. It is an error when it is not empty.
+type List []*Diagnostic
+
+func (l List) Error() string {
+	var msgs []string
+	for _, d := range l {
+		msgs = append(msgs, d.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Err returns l as an error, or nil when l is empty.
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// HasErrors reports whether l contains an error.
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort sorts l by file, line and column.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// From returns the diagnostics in err: those of a List or a Diagnostic
+// anywhere in its chain, or else a single diagnostic for err itself. I/O
+// errors get the rule "io" and the path they refer to.
+func From(err error) List {
+	if err == nil {
+		return nil
+	}
+	var l List
+	if errors.As(err, &l) {
+		return l
+	}
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return List{d}
+	}
+	if IsIO(err) {
+		d := Errorf("io", "%v", err)
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			d.File, d.Message = pe.Path, fmt.Sprintf("%s: %v", pe.Op, pe.Err)
+		}
+		return List{d}
+	}
+	return List{Errorf("error", "%v", err)}
+}
+
+// IsIO reports whether err is a failure to read or write a file rather than
+// a problem in the program.
+func IsIO(err error) bool {
+	var pe *os.PathError
+	var le *os.LinkError
+	var se *os.SyscallError
+	return errors.As(err, &pe) || errors.As(err, &le) || errors.As(err, &se)
+}
+
+// Formats are the names Write accepts.
+var Formats = []string{"text", "json", "sarif"}
+
+// Write writes l to w in format, one of Formats.
+func Write(w io.Writer, format string, l List) error {
+	switch format {
+	case "text":
+		for _, d := range l {
+			line := fmt.Sprintf("%s: %s", d.Severity, d.Message)
+			if pos := d.position(); pos != "" {
+				line = pos + ": " + line
+			}
+			if d.Rule != "" {
+				line += " [" + d.Rule + "]"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		if l == nil {
+			l = List{}
+		}
+		return writeJSON(w, l)
+	case "sarif":
+		return writeJSON(w, sarif(l))
+	}
+	return fmt.Errorf("unknown diagnostics format %q, want one of %s", format, strings.Join(Formats, ", "))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,164 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDiagnostic_Error(t *testing.T) {
+	tests := []struct {
+		name string
+		d    *Diagnostic
+		want string
+	}{
+		{"file, line and column", Errorf("r", "msg").At("Main.vm", 3, 6), "Main.vm:3:6: msg"},
+		{"file and line", Errorf("r", "msg").At("Main.vm", 3, 0), "Main.vm:3: msg"},
+		{"file", Errorf("r", "msg").At("Main.vm", 0, 0), "Main.vm: msg"},
+		{"line", Errorf("r", "msg").At("", 3, 6), "line 3: msg"},
+		{"no position", Errorf("r", "msg"), "msg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	var l List
+	if l.Err() != nil {
+		t.Errorf("empty List.Err() = %v, want nil", l.Err())
+	}
+	l = List{
+		Warningf("w", "b").At("B.vm", 1, 0),
+		Warningf("w", "a2").At("A.vm", 2, 1),
+		Warningf("w", "a1").At("A.vm", 1, 5),
+	}
+	if l.HasErrors() {
+		t.Error("HasErrors() = true for warnings only")
+	}
+	l = append(l, Errorf("e", "a0").At("A.vm", 1, 2))
+	if !l.HasErrors() {
+		t.Error("HasErrors() = false")
+	}
+	l.Sort()
+	var got []string
+	for _, d := range l {
+		got = append(got, d.Message)
+	}
+	if want := []string{"a0", "a1", "a2", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sort() = %v, want %v", got, want)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	d := Errorf("invalid-command", "bad").At("Main.vm", 1, 1)
+	l := List{d, Warningf("undefined-label", "x")}
+	tests := []struct {
+		name string
+		err  error
+		want List
+	}{
+		{"nil", nil, nil},
+		{"list", l, l},
+		{"wrapped diagnostic", fmt.Errorf("reading: %w", d), List{d}},
+		{
+			"path error",
+			&os.PathError{Op: "open", Path: "Main.vm", Err: errors.New("no such file")},
+			List{Errorf("io", "open: no such file").At("Main.vm", 0, 0)},
+		},
+		{"other", errors.New("boom"), List{Errorf("error", "boom")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := From(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("From() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	l := List{
+		Errorf("missing-argument", `missing second argument in "push constant"`).At("Main.vm", 3, 15),
+		Warningf("undefined-function", "Foo.bar is not defined"),
+	}
+
+	b := bytes.NewBufferString("")
+	if err := Write(b, "text", l); err != nil {
+		t.Fatal(err)
+	}
+	want := `Main.vm:3:15: error: missing second argument in "push constant" [missing-argument]
+warning: Foo.bar is not defined [undefined-function]
+`
+	if b.String() != want {
+		t.Errorf("text = %q, want %q", b, want)
+	}
+
+	b.Reset()
+	if err := Write(b, "json", l); err != nil {
+		t.Fatal(err)
+	}
+	var got List
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, l) {
+		t.Errorf("json = %v, want %v", got, l)
+	}
+
+	b.Reset()
+	if err := Write(b, "json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[]\n" {
+		t.Errorf("json of nil = %q, want []", b)
+	}
+
+	b.Reset()
+	if err := Write(b, "sarif", l); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("sarif = %s", b)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "missing-argument" {
+		t.Errorf("sarif rules = %+v", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 2 || run.Results[0].Level != Error || run.Results[1].Level != Warning {
+		t.Fatalf("sarif results = %+v", run.Results)
+	}
+	region := run.Results[0].Locations[0].PhysicalLocation.Region
+	if region == nil || region.StartLine != 3 || region.StartColumn != 15 {
+		t.Errorf("sarif region = %+v, want 3:15", region)
+	}
+	if len(run.Results[1].Locations) != 0 {
+		t.Errorf("sarif locations = %+v, want none", run.Results[1].Locations)
+	}
+
+	if err := Write(b, "xml", l); err == nil {
+		t.Error("Write(xml) succeeded")
+	}
+}
+
+func TestRules(t *testing.T) {
+	for _, d := range []*Diagnostic{
+		Errorf("io", ""), Errorf("usage", ""), Errorf("error", ""),
+	} {
+		if Rules[d.Rule] == "" {
+			t.Errorf("rule %q has no description", d.Rule)
+		}
+	}
+}
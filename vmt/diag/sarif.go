@@ -0,0 +1,135 @@
+package diag
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Rules describes every rule a Diagnostic can name.
+var Rules = map[string]string{
+	"io":    "a file could not be read or written",
+	"usage": "the command line is invalid",
+	"error": "an error without a rule of its own",
+
+	// assembler
+	"assembly":     "the Hack assembly is invalid",
+	"machine-code": "a word of the machine code is not a Hack instruction",
+	"not-liftable": "the code was not generated by the templates of the translator",
+
+	// parser
+	"invalid-command":  "the line is not a VM command",
+	"missing-argument": "the command lacks an argument",
+	"invalid-argument": "an argument that must be a number is not",
+	"extra-argument":   "the command has more words than it takes",
+	"invalid-segment":  "push or pop names a segment that does not exist or cannot be popped to",
+	"invalid-name":     "a label or function name contains \"$\", which the translator keeps for its own labels",
+	"invalid-index":    "a number is negative, an index is outside its segment or a constant is above 32767",
+	"unformattable":    "vmt fmt cannot rewrite the line without changing its words",
+
+	// program
+	"duplicate-function": "a function is defined twice",
+	"duplicate-label":    "a label is defined twice in the same function",
+	"undefined-function": "a called function is not defined in the program",
+	"undefined-label":    "the target of goto or if-goto is not defined in the function",
+	"duplicate-file":     "two files of the program have the same name",
+	"static-overflow":    "the static variables do not fit in RAM 16-255",
+	"rom-overflow":       "the program does not fit in the 32768 words of ROM",
+
+	// stack
+	"stack-underflow": "a command takes more values from the stack than the function put there",
+	"stack-mismatch":  "a label is reached with different stack depths",
+	"stack-return":    "a function returns with other than one value on the stack",
+	"stack-overflow":  "the stack may grow into the heap or the screen",
+}
+
+// The subset of SARIF 2.1.0 that vmt writes.
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     Severity        `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysical `json:"physicalLocation"`
+	}
+	sarifPhysical struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           *sarifRegion  `json:"region,omitempty"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+)
+
+// sarif returns l as a SARIF log with one run, listing the rules it uses.
+func sarif(l List) *sarifLog {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "vmt",
+			InformationURI: "https://github.com/HagaSpa/vmt",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	used := map[string]bool{}
+	for _, d := range l {
+		r := sarifResult{
+			RuleID:  d.Rule,
+			Level:   d.Severity,
+			Message: sarifMessage{d.Message},
+		}
+		if d.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysical{
+				ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(d.File)},
+			}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			r.Locations = append(r.Locations, loc)
+		}
+		run.Results = append(run.Results, r)
+		used[d.Rule] = true
+	}
+	var ids []string
+	for id := range used {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: id, ShortDescription: sarifMessage{Rules[id]}})
+	}
+	return &sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
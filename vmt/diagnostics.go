@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"vmt/diag"
+)
+
+// Exit codes of vmt.
+const (
+	exitOK     = 0
+	exitSource = 1 // problems in the program, and anything else
+	exitUsage  = 2 // invalid command line
+	exitIO     = 3 // files that cannot be read or written
+)
+
+// usageError is an invalid command line that is reported with exitUsage.
+type usageError struct{ msg string }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func (e *usageError) Is(target error) bool { return target == errUsage }
+
+// diagnosticsFormat is the -diagnostics flag every subcommand accepts.
+type diagnosticsFormat string
+
+var diagnostics = diagnosticsFormat("text")
+
+func (f *diagnosticsFormat) String() string { return string(*f) }
+
+// Set also silences the progress log for the machine readable formats, so
+// that standard error holds nothing but the diagnostics.
+func (f *diagnosticsFormat) Set(s string) error {
+	for _, name := range diag.Formats {
+		if s == name {
+			*f = diagnosticsFormat(s)
+			if s != "text" {
+				log.SetOutput(ioutil.Discard)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("want one of %s", strings.Join(diag.Formats, ", "))
+}
+
+// warnings collects the warnings of a run; commands may translate several
+// programs at the same time.
+var warnings struct {
+	sync.Mutex
+	list diag.List
+}
+
+func warn(l diag.List) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	warnings.list = append(warnings.list, l...)
+}
+
+// report writes the warnings collected so far and the diagnostics of err to
+// w in the -diagnostics format and returns the exit code for err. Usage
+// errors other than the message itself are left to the flag package.
+func report(w io.Writer, err error) int {
+	warnings.Lock()
+	l := warnings.list
+	warnings.list = nil
+	warnings.Unlock()
+
+	code := exitOK
+	var ue *usageError
+	switch {
+	case err == nil:
+	case errors.As(err, &ue):
+		l = append(l, diag.Errorf("usage", "%s", ue.msg))
+		code = exitUsage
+	case errors.Is(err, errUsage):
+		code = exitUsage
+	default:
+		errs := diag.From(err)
+		l = append(l, errs...)
+		code = exitIO
+		for _, d := range errs {
+			if d.Rule != "io" {
+				code = exitSource
+			}
+		}
+	}
+	if len(l) > 0 || diagnostics != "text" {
+		diag.Write(w, string(diagnostics), l)
+	}
+	return code
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"vmt/diag"
+)
+
+func Test_report(t *testing.T) {
+	report(ioutil.Discard, nil) // warnings of other tests
+	tests := []struct {
+		name string
+		err  error
+		code int
+		want string
+	}{
+		{"ok", nil, exitOK, ""},
+		{"usage", usageErrorf("-o is required"), exitUsage, "error: -o is required [usage]\n"},
+		{"flags", errUsage, exitUsage, ""},
+		{
+			"source",
+			diag.List{diag.Errorf("invalid-command", `invalid command "pop"`).At("Main.vm", 2, 1)},
+			exitSource,
+			"Main.vm:2:1: error: invalid command \"pop\" [invalid-command]\n",
+		},
+		{
+			"io",
+			&os.PathError{Op: "open", Path: "Main.vm", Err: errors.New("permission denied")},
+			exitIO,
+			"Main.vm: error: open: permission denied [io]\n",
+		},
+		{
+			"io and source",
+			diag.List{diag.Errorf("io", "x"), diag.Errorf("static-overflow", "y")},
+			exitSource,
+			"error: x [io]\nerror: y [static-overflow]\n",
+		},
+		{"other", errors.New("boom"), exitSource, "error: boom [error]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := bytes.NewBufferString("")
+			if code := report(b, tt.err); code != tt.code {
+				t.Errorf("report() = %d, want %d", code, tt.code)
+			}
+			if b.String() != tt.want {
+				t.Errorf("report() wrote %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
+
+func Test_report_warnings(t *testing.T) {
+	report(ioutil.Discard, nil)
+	warn(diag.List{diag.Warningf("undefined-label", "label END is not defined")})
+	b := bytes.NewBufferString("")
+	if code := report(b, nil); code != exitOK {
+		t.Errorf("report() = %d, want %d", code, exitOK)
+	}
+	if !strings.Contains(b.String(), "warning: label END is not defined [undefined-label]") {
+		t.Errorf("report() wrote %q", b)
+	}
+
+	// warnings are reported once
+	b.Reset()
+	report(b, nil)
+	if b.Len() != 0 {
+		t.Errorf("report() wrote %q again", b)
+	}
+}
+
+func Test_options_translate_diagnostics(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm":  "function Sys.init 0\ncall Main.main 0\nlabel END\ngoto END\n",
+		"Main.vm": "function Main.main 0\npush constant\npop\nreturn\n",
+	})
+	o := options{bootstrap: true}
+	_, err := o.readProgram([]string{dir})
+	var l diag.List
+	if !errors.As(err, &l) || len(l) != 2 {
+		t.Fatalf("readProgram() = %v, want 2 diagnostics", err)
+	}
+	for _, d := range l {
+		if !strings.HasSuffix(d.File, "Main.vm") || d.Line < 2 {
+			t.Errorf("diagnostic %v has no position in Main.vm", d)
+		}
+	}
+
+	dir = testProgram(t, map[string]string{
+		"Sys.vm": "function Sys.init 0\ncall Main.main 0\nlabel END\ngoto END\n",
+	})
+	files, err := o.readProgram([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report(ioutil.Discard, nil)
+	if _, _, err := o.translate(files); err != nil {
+		t.Fatalf("translate() = %v, want only a warning", err)
+	}
+	b := bytes.NewBufferString("")
+	report(b, nil)
+	if !strings.Contains(b.String(), "[undefined-function]") {
+		t.Errorf("translate() warned %q, want undefined-function", b)
+	}
+}
+
+func Test_options_translate_stack(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm": "function Sys.init 0\ncall Sys.f 0\npop temp 0\nlabel END\ngoto END\nfunction Sys.f 0\nreturn\n",
+	})
+	o := options{bootstrap: true}
+	files, err := o.readProgram([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = o.translate(files)
+	var l diag.List
+	if !errors.As(err, &l) || len(l) != 1 || l[0].Rule != "stack-return" || l[0].Line != 7 {
+		t.Errorf("translate() = %v, want stack-return on line 7", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"vmt/assembler"
+	"vmt/diag"
+	"vmt/disasm"
+	"vmt/rom"
+)
+
+func disassemble(args []string) error {
+	fs := newFlagSet("disasm", "[flags] {hack or bin file | -}")
+	format := fs.String("format", "", `format of the input: hack or bin (default bin for .bin files, hack otherwise)`)
+	out := fs.String("o", stdio, `output file, "-" for standard output`)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("disasm takes one input file")
+	}
+	input := fs.Arg(0)
+	if *format == "" {
+		*format = "hack"
+		if filepath.Ext(input) == ".bin" {
+			*format = "bin"
+		}
+	}
+	if *format != "hack" && *format != "bin" {
+		return usageErrorf("unknown format %q, want hack or bin", *format)
+	}
+
+	code, err := readCode(input, *format)
+	if err != nil {
+		return err
+	}
+	b := bytes.NewBufferString("")
+	err = disasm.Write(b, code)
+	var de *disasm.Error
+	if errors.As(err, &de) {
+		return diag.Errorf("machine-code", "%s", de).At(input, 0, 0)
+	}
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, b)
+}
+
+// readCode reads the machine code in p, or standard input for "-", in the
+// format hack or bin.
+func readCode(p, format string) ([]uint16, error) {
+	var data []byte
+	var err error
+	if p == stdio {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if format == "bin" {
+		code, err := rom.ReadBinary(bytes.NewReader(data))
+		if err != nil {
+			return nil, diag.Errorf("machine-code", "%v", err).At(p, 0, 0)
+		}
+		return code, nil
+	}
+	code, err := assembler.ReadHack(bytes.NewReader(data))
+	var ae *assembler.Error
+	if errors.As(err, &ae) {
+		return nil, diag.Errorf("machine-code", "%s", ae.Msg).At(p, ae.Line, 0)
+	}
+	return code, err
+}
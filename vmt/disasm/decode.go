@@ -0,0 +1,50 @@
+package disasm
+
+import (
+	"fmt"
+	"vmt/assembler"
+)
+
+// dests are the dest fields by their d bits, spelled as in nand2tetris.
+var dests = []string{"", "M", "D", "MD", "A", "AM", "AD", "AMD"}
+
+// comps maps the a and c bits of a C-instruction to its comp field.
+var comps = map[uint16]string{}
+
+func init() {
+	for comp, bits := range assembler.Comp {
+		comps[bits] = comp
+	}
+}
+
+// Decode returns the Hack assembly of the machine word w, with the value of
+// an A-instruction as a number. Words that no Hack instruction assembles
+// to, such as C-instructions without both of their unused bits set, are an
+// error.
+func Decode(w uint16) (string, error) {
+	if w&0x8000 == 0 {
+		return fmt.Sprintf("@%d", w), nil
+	}
+	comp, ok := comps[w>>6&0x7F]
+	if w&0xE000 != 0xE000 || !ok {
+		return "", fmt.Errorf("%016b is not a Hack instruction", w)
+	}
+	inst := comp
+	if d := dests[w>>3&7]; d != "" {
+		inst = d + "=" + inst
+	}
+	if j := assembler.Jump[w&7]; j != "" {
+		inst += ";" + j
+	}
+	return inst, nil
+}
+
+// jumps reports whether the C-instruction w can jump.
+func jumps(w uint16) bool {
+	return w&0x8000 != 0 && w&7 != 0
+}
+
+// usesM reports whether the C-instruction w reads or writes M.
+func usesM(w uint16) bool {
+	return w&0x8000 != 0 && (w&0x1000 != 0 || w&0x08 != 0)
+}
@@ -0,0 +1,184 @@
+/*
+Package disasm turns Hack machine code back into Hack assembly.
+
+Labels are made up for the addresses code jumps to, and the code the
+translator generated is recognized by the templates of the codewriter
+package and annotated with the VM command it was most likely generated
+from. Assembling the result gives the machine code back word for word.
+*/
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"vmt/parser"
+)
+
+// Match is the code a codewriter template generated for one VM command.
+type Match struct {
+	Start, End int // ROM addresses [Start, End)
+	// Command is the VM command. Labels and function names do not survive
+	// assembly, so Arg1 of goto, if-goto and call is empty, and Arg2 of
+	// push static and pop static is the RAM address of the variable.
+	Command parser.Command
+	// Bootstrap is set for the bootstrap code instead of a Command.
+	Bootstrap bool
+	// Target is the ROM address goto and if-goto jump to, or the address of
+	// the function call and the bootstrap code call; -1 for the others.
+	Target int
+
+	refs []int // A-instructions that load a ROM address
+}
+
+// Recognize finds the code of codewriter templates in code, from the start
+// on, taking the longest template that fits at every address. Code that no
+// template generated is skipped. Since the optimizer drops instructions
+// from templates, little of optimized code is recognized.
+func Recognize(code []uint16) []Match {
+	var ms []Match
+	for i := 0; i < len(code); {
+		m, ok := recognizeAt(code, i)
+		if !ok {
+			i++
+			continue
+		}
+		ms = append(ms, m)
+		i = m.End
+	}
+	return ms
+}
+
+func recognizeAt(code []uint16, i int) (Match, bool) {
+	for _, p := range patterns {
+		if m, ok := p.match(code, i); ok {
+			return m, true
+		}
+	}
+	return Match{}, false
+}
+
+// registers are the names of the predefined symbols by their address, for
+// A-instructions that address memory.
+var registers = map[uint16]string{
+	0: "SP", 1: "LCL", 2: "ARG", 3: "THIS", 4: "THAT",
+	16384: "SCREEN", 24576: "KBD",
+}
+
+func init() {
+	for i := uint16(5); i < 16; i++ {
+		registers[i] = fmt.Sprintf("R%d", i)
+	}
+}
+
+// Error is a word that is not an instruction.
+type Error struct {
+	Addr int
+	Word uint16
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ROM[%d]: %016b is not a Hack instruction", e.Addr, e.Word)
+}
+
+/*
+Write writes code to w as Hack assembly, one instruction per line followed
+by its ROM address.
+
+Addresses that are jumped to get a label: F123 for functions called from
+recognized calls, RET123 for their return addresses and L123 for the
+others, and the A-instructions that load them use the label. An
+A-instruction whose address the next instruction reads or writes uses the
+predefined symbol of the address, such as SP or R13, if there is one. Every
+recognized template starts with a comment that names its VM command.
+*/
+func Write(w io.Writer, code []uint16) error {
+	text := make([]string, len(code))
+	for i, c := range code {
+		inst, err := Decode(c)
+		if err != nil {
+			return &Error{i, c}
+		}
+		text[i] = inst
+	}
+
+	matches := Recognize(code)
+	labels := map[int]string{}
+	name := func(addr int, prefix string) {
+		if addr <= len(code) && labels[addr] == "" {
+			labels[addr] = fmt.Sprintf("%s%d", prefix, addr)
+		}
+	}
+	refs := map[int]bool{}
+	for _, m := range matches {
+		if m.Bootstrap || m.Command.Type == parser.CALL {
+			name(m.Target, "F")
+		}
+	}
+	for _, m := range matches {
+		if m.Bootstrap || m.Command.Type == parser.CALL {
+			name(m.End, "RET")
+		}
+	}
+	for _, m := range matches {
+		for _, r := range m.refs {
+			name(int(code[r]), "L")
+			refs[r] = true
+		}
+	}
+	for i := 1; i < len(code); i++ {
+		if jumps(code[i]) && code[i-1]&0x8000 == 0 {
+			name(int(code[i-1]), "L")
+			refs[i-1] = true
+		}
+	}
+
+	comments := map[int]string{}
+	for _, m := range matches {
+		comments[m.Start] = comment(m, labels)
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, inst := range text {
+		if l := labels[i]; l != "" {
+			fmt.Fprintf(bw, "(%s)\n", l)
+		}
+		if c := comments[i]; c != "" {
+			fmt.Fprintf(bw, "// %s\n", c)
+		}
+		if c := code[i]; c&0x8000 == 0 {
+			if l := labels[int(c)]; refs[i] && l != "" {
+				inst = "@" + l
+			} else if r := registers[c]; r != "" && i+1 < len(code) && usesM(code[i+1]) {
+				inst = "@" + r
+			}
+		}
+		fmt.Fprintf(bw, "%-15s // %d\n", inst, i)
+	}
+	if l := labels[len(code)]; l != "" {
+		fmt.Fprintf(bw, "(%s)\n", l)
+	}
+	return bw.Flush()
+}
+
+// comment describes the VM command of m.
+func comment(m Match, labels map[int]string) string {
+	target := labels[m.Target]
+	if target == "" {
+		target = fmt.Sprint(m.Target)
+	}
+	c := m.Command
+	switch {
+	case m.Bootstrap:
+		return "bootstrap, call " + target
+	case c.Type == parser.CALL:
+		return fmt.Sprintf("call %s %d", target, c.Arg2)
+	case c.Type == parser.GOTO || c.Type == parser.IF:
+		c.Arg1 = target
+	case c.Arg1 == "static":
+		op := strings.Fields(c.String())[0]
+		return fmt.Sprintf("%s static (RAM %d)", op, c.Arg2)
+	}
+	return c.String()
+}
@@ -0,0 +1,198 @@
+package disasm
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"vmt/assembler"
+	"vmt/codewriter"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		w    uint16
+		want string
+	}{
+		{0x0000, "@0"},
+		{0x7FFF, "@32767"},
+		{0xEA87, "0;JMP"},
+		{0xFC88, "M=M-1"},
+		{0xE329, "AM=D;JGT"},
+		{0xF57F, "AMD=D|M;JMP"},
+	}
+	for _, tt := range tests {
+		if got, err := Decode(tt.w); got != tt.want || err != nil {
+			t.Errorf("Decode(%016b) = %q, %v, want %q", tt.w, got, err, tt.want)
+		}
+	}
+
+	valid := 0
+	for w := 0x8000; w <= 0xFFFF; w++ {
+		inst, err := Decode(uint16(w))
+		if err != nil {
+			continue
+		}
+		valid++
+		if c, err := assembler.Encode(inst); c != uint16(w) || err != nil {
+			t.Errorf("Encode(Decode(%016b)) = %016b, %v", w, c, err)
+		}
+	}
+	if want := len(assembler.Comp) * 8 * 8; valid != want {
+		t.Errorf("%d C-instructions decode, want %d", valid, want)
+	}
+}
+
+// translate returns the machine code of files.
+func translate(t *testing.T, files []parser.File, opt codewriter.Options) []uint16 {
+	t.Helper()
+	b := bytes.NewBufferString("")
+	if _, err := codewriter.Translate(b, files, opt, 1); err != nil {
+		t.Fatal(err)
+	}
+	code, err := assembler.Assemble(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// Every word of translated code is recognized, as the commands it was
+// translated from.
+func TestRecognize(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		ms := Recognize(translate(t, files, codewriter.DefaultOptions))
+		if len(ms) == 0 || !ms[0].Bootstrap {
+			t.Fatalf("seed %d: bootstrap code not recognized", seed)
+		}
+		var want, got []parser.Command
+		for _, f := range files {
+			for _, c := range f.Commands {
+				switch c.Type {
+				case parser.LABEL:
+					continue
+				case parser.FUNCTION:
+					for i := 0; i < c.Arg2; i++ {
+						want = append(want, parser.Command{Type: parser.PUSH, Arg1: "constant"})
+					}
+					continue
+				case parser.GOTO, parser.IF, parser.CALL:
+					c.Arg1 = ""
+				}
+				if c.Arg1 == "static" {
+					c.Arg2 = 0
+				}
+				c.Line = 0
+				want = append(want, c)
+			}
+		}
+		end := ms[0].End
+		for _, m := range ms[1:] {
+			if m.Start != end {
+				t.Fatalf("seed %d: ROM[%d:%d] not recognized", seed, end, m.Start)
+			}
+			end = m.End
+			c := m.Command
+			if c.Arg1 == "static" {
+				if c.Arg2 < 16 {
+					t.Errorf("seed %d: static at RAM %d", seed, c.Arg2)
+				}
+				c.Arg2 = 0
+			}
+			got = append(got, c)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("seed %d: Recognize() found other commands than were translated", seed)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	src := `@256
+D=A
+@SP
+M=D
+(LOOP)
+@LOOP
+0;JMP
+(END)
+@END
+D;JEQ
+`
+	code, err := assembler.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.NewBufferString("")
+	if err := Write(b, code); err != nil {
+		t.Fatal(err)
+	}
+	want := `@256            // 0
+D=A             // 1
+@SP             // 2
+M=D             // 3
+(L4)
+// goto L4
+@L4             // 4
+0;JMP           // 5
+(L6)
+@L6             // 6
+D;JEQ           // 7
+`
+	if b.String() != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", b, want)
+	}
+
+	var e *Error
+	if err := Write(b, []uint16{0, 0x8000}); !errors.As(err, &e) || e.Addr != 1 {
+		t.Errorf("Write() error = %v, want an error at ROM[1]", err)
+	}
+}
+
+// Disassembled code must assemble to the same machine code.
+func TestWrite_roundTrip(t *testing.T) {
+	var programs [][]uint16
+	for seed := int64(0); seed < 10; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		programs = append(programs,
+			translate(t, files, codewriter.DefaultOptions),
+			translate(t, files, codewriter.Options{Bootstrap: true, Optimize: 1}))
+	}
+	var comps []uint16
+	for _, c := range assembler.Comp {
+		comps = append(comps, c)
+	}
+	sort.Slice(comps, func(i, j int) bool { return comps[i] < comps[j] })
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		code := make([]uint16, 1+r.Intn(200))
+		for j := range code {
+			w := uint16(r.Intn(0x8000))
+			if r.Intn(2) == 0 {
+				w = 0xE000 | comps[r.Intn(len(comps))]<<6 | uint16(r.Intn(64))
+			}
+			code[j] = w
+		}
+		programs = append(programs, code)
+	}
+
+	for i, code := range programs {
+		b := bytes.NewBufferString("")
+		if err := Write(b, code); err != nil {
+			t.Fatal(err)
+		}
+		got, err := assembler.Assemble(strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatalf("program %d: Assemble() error = %v", i, err)
+		}
+		if !reflect.DeepEqual(got, code) {
+			t.Errorf("program %d: assembled disassembly differs", i)
+		}
+	}
+}
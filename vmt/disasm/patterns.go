@@ -0,0 +1,195 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"vmt/assembler"
+	"vmt/codewriter"
+	"vmt/parser"
+)
+
+// Values the templates are written with to find where they put the
+// arguments of a command. No template holds them otherwise.
+const (
+	sentinel     = 7001
+	sentinelArgs = 7002
+	sentinelFunc = "G7001"
+	sentinelFile = "F"
+)
+
+// hole is what an A-instruction of a pattern loads.
+type hole int
+
+const (
+	fixed    hole = iota // the value of the template
+	argument             // Arg2 of the command
+	static               // RAM address of a static variable
+	args                 // number of arguments of call
+	jump                 // ROM address goto and if-goto jump to
+	function             // ROM address of the function call calls
+	local                // ROM address of a label within the pattern
+)
+
+type word struct {
+	w    uint16
+	hole hole
+	at   int // offset of the label a local hole loads
+}
+
+// pattern is the code a template generates, with holes where it depends on
+// the arguments of the command.
+type pattern struct {
+	command   parser.Command
+	bootstrap bool
+	words     []word
+}
+
+// patterns are the code of every template, longest first, so that a call is
+// not taken for the push it starts with.
+var patterns []*pattern
+
+func init() {
+	var cmds []parser.Command
+	for _, op := range []string{"add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not"} {
+		cmds = append(cmds, parser.Command{Type: parser.ARITHMETIC, Arg1: op})
+	}
+	for _, seg := range []string{"constant", "local", "argument", "this", "that", "static"} {
+		cmds = append(cmds, parser.Command{Type: parser.PUSH, Arg1: seg, Arg2: sentinel})
+		if seg != "constant" {
+			cmds = append(cmds, parser.Command{Type: parser.POP, Arg1: seg, Arg2: sentinel})
+		}
+	}
+	for _, reg := range []struct {
+		seg string
+		n   int
+	}{{"pointer", 2}, {"temp", 8}} {
+		for i := 0; i < reg.n; i++ {
+			cmds = append(cmds,
+				parser.Command{Type: parser.PUSH, Arg1: reg.seg, Arg2: i},
+				parser.Command{Type: parser.POP, Arg1: reg.seg, Arg2: i})
+		}
+	}
+	cmds = append(cmds,
+		parser.Command{Type: parser.GOTO, Arg1: "L7001"},
+		parser.Command{Type: parser.IF, Arg1: "L7001"},
+		parser.Command{Type: parser.CALL, Arg1: sentinelFunc, Arg2: sentinelArgs},
+		parser.Command{Type: parser.RETURN})
+
+	for _, c := range cmds {
+		b := bytes.NewBufferString("")
+		cw := codewriter.NewWithOptions(b, codewriter.Options{Mode: codewriter.Compact})
+		cw.SetFileName(sentinelFile)
+		cw.WriteCommand(c)
+		patterns = append(patterns, compile(c, b.String()))
+	}
+	b := bytes.NewBufferString("")
+	codewriter.NewWithOptions(b, codewriter.Options{Bootstrap: true, Entry: sentinelFunc, Mode: codewriter.Compact})
+	p := compile(parser.Command{}, b.String())
+	p.bootstrap = true
+	patterns = append(patterns, p)
+
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return len(patterns[i].words) > len(patterns[j].words)
+	})
+}
+
+// compile turns the compact assembly of a template for c into a pattern.
+func compile(c parser.Command, asm string) *pattern {
+	lines := strings.Fields(asm)
+	labels := map[string]int{}
+	n := 0
+	for _, l := range lines {
+		if strings.HasPrefix(l, "(") {
+			labels[strings.Trim(l, "()")] = n
+			continue
+		}
+		n++
+	}
+
+	p := &pattern{command: c}
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "("):
+		case strings.HasPrefix(l, "@"):
+			p.words = append(p.words, symbol(l[1:], labels))
+		default:
+			w, err := assembler.Encode(l)
+			if err != nil {
+				panic(fmt.Sprintf("disasm: template of %s: %v", c, err))
+			}
+			p.words = append(p.words, word{w: w})
+		}
+	}
+	return p
+}
+
+// symbol returns the word of the A-instruction @sym in a template.
+func symbol(sym string, labels map[string]int) word {
+	if at, ok := labels[sym]; ok {
+		return word{hole: local, at: at}
+	}
+	if a, ok := assembler.Predefined(sym); ok {
+		return word{w: a}
+	}
+	switch {
+	case sym == strconv.Itoa(sentinel):
+		return word{hole: argument}
+	case sym == strconv.Itoa(sentinelArgs):
+		return word{hole: args}
+	case sym == sentinelFunc:
+		return word{hole: function}
+	case sym == fmt.Sprintf("%s.%d", sentinelFile, sentinel):
+		return word{hole: static}
+	case strings.HasSuffix(sym, "$L7001"):
+		return word{hole: jump}
+	}
+	n, err := strconv.ParseUint(sym, 10, 15)
+	if err != nil {
+		panic(fmt.Sprintf("disasm: unknown symbol %s in a template", sym))
+	}
+	return word{w: uint16(n)}
+}
+
+// match returns the Match of p at address start of code, if it is there.
+func (p *pattern) match(code []uint16, start int) (Match, bool) {
+	if start+len(p.words) > len(code) {
+		return Match{}, false
+	}
+	m := Match{Start: start, End: start + len(p.words), Command: p.command, Bootstrap: p.bootstrap, Target: -1}
+	for i, pw := range p.words {
+		w := code[start+i]
+		if pw.hole == fixed {
+			if w != pw.w {
+				return Match{}, false
+			}
+			continue
+		}
+		if w&0x8000 != 0 {
+			return Match{}, false
+		}
+		switch pw.hole {
+		case argument, args:
+			m.Command.Arg2 = int(w)
+		case static:
+			if w < 16 || w > 255 {
+				return Match{}, false
+			}
+			m.Command.Arg2 = int(w)
+		case jump, function:
+			m.Target = int(w)
+			m.refs = append(m.refs, start+i)
+		case local:
+			if int(w) != start+pw.at {
+				return Match{}, false
+			}
+			m.refs = append(m.refs, start+i)
+		}
+	}
+	if m.Command.Type == parser.GOTO || m.Command.Type == parser.IF || m.Command.Type == parser.CALL {
+		m.Command.Arg1 = ""
+	}
+	return m, true
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vmt/diag"
+)
+
+func Test_disassemble(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		// push constant 7, then an endless loop
+		"Main.hack": "0000000000000111\n1110110000010000\n0000000000000000\n1111110000100000\n" +
+			"1110001100001000\n0000000000000000\n1111110111001000\n0000000000000111\n1110101010000111\n",
+		"Main.bin": "\x00\x07\xEA\x87",
+		"Bad.hack": "0000000000000000\n1000000000000000\n",
+		"Odd.bin":  "\x00",
+	})
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		input string
+		want  []string
+	}{
+		{"Main.hack", []string{"// push constant 7\n@7", "(L7)\n// goto L7\n@L7             // 7\n"}},
+		{"Main.bin", []string{"@7              // 0\n0;JMP           // 1\n"}},
+	} {
+		out := filepath.Join(dir, "out.asm")
+		if err := disassemble([]string{"-o", out, filepath.Join(dir, tt.input)}); err != nil {
+			t.Fatal(err)
+		}
+		asm, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(string(asm), s) {
+				t.Errorf("disasm %s wrote\n%s\nwithout %q", tt.input, asm, s)
+			}
+		}
+	}
+
+	for _, input := range []string{"Bad.hack", "Odd.bin"} {
+		err := disassemble([]string{"-o", os.DevNull, filepath.Join(dir, input)})
+		if l := diag.From(err); len(l) != 1 || l[0].Rule != "machine-code" {
+			t.Errorf("disasm %s = %v, want a machine-code error", input, err)
+		}
+	}
+	if err := disassemble([]string{"-format", "ihex", filepath.Join(dir, "Main.bin")}); !errors.Is(err, errUsage) {
+		t.Errorf("disasm -format ihex = %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Package emulator runs Hack machine code.
+
+The CPU implements the Hack ALU bit for bit and executes one instruction per
+Step. A program halts when it reaches the idiom every Hack program ends with,
+an unconditional jump to the A-instruction in front of it:
+
+	(END)
+	@END
+	0;JMP
+*/
+package emulator
+
+import (
+	"fmt"
+)
+
+// Hack memory sizes and the memory mapped I/O.
+const (
+	RAMSize = 32768
+	Screen  = 16384
+	KBD     = 24576
+)
+
+// CPU is a Hack computer: ROM, RAM and the A, D and PC registers.
+type CPU struct {
+	ROM []uint16
+	RAM [RAMSize]uint16
+	A   uint16
+	D   uint16
+	PC  uint16
+
+	Cycles int
+	Halted bool
+}
+
+// New returns a CPU with rom loaded and RAM cleared.
+func New(rom []uint16) *CPU {
+	return &CPU{ROM: rom}
+}
+
+// Step executes the instruction at PC.
+func (c *CPU) Step() error {
+	if int(c.PC) >= len(c.ROM) {
+		return fmt.Errorf("pc %d: outside of the program (%d instructions)", c.PC, len(c.ROM))
+	}
+	pc := c.PC
+	inst := c.ROM[pc]
+	c.Cycles++
+	if inst&0x8000 == 0 {
+		c.A = inst
+		c.PC++
+		return nil
+	}
+
+	y := c.A
+	if inst&0x1000 != 0 {
+		if int(c.A) >= RAMSize {
+			return fmt.Errorf("pc %d: read from RAM[%d]", pc, c.A)
+		}
+		y = c.RAM[c.A]
+	}
+	out := ALU(c.D, y, inst>>6&0x3F)
+
+	// the address of M is the value of A before this instruction
+	addr := c.A
+	if inst&0x08 != 0 {
+		if int(addr) >= RAMSize {
+			return fmt.Errorf("pc %d: write to RAM[%d]", pc, addr)
+		}
+		c.RAM[addr] = out
+	}
+	if inst&0x20 != 0 {
+		c.A = out
+	}
+	if inst&0x10 != 0 {
+		c.D = out
+	}
+
+	if jump(out, inst&0x07) {
+		c.PC = addr
+		if inst&0x07 == 7 && pc > 0 && addr == pc-1 && c.ROM[addr] == addr {
+			c.Halted = true
+		}
+		return nil
+	}
+	c.PC++
+	return nil
+}
+
+// Run steps until the program halts or maxCycles instructions have run.
+func (c *CPU) Run(maxCycles int) error {
+	for i := 0; i < maxCycles && !c.Halted; i++ {
+		if err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ALU computes the Hack ALU function selected by the six control bits
+// zx nx zy ny f no (most significant first).
+func ALU(x, y uint16, ctrl uint16) uint16 {
+	if ctrl&0x20 != 0 {
+		x = 0
+	}
+	if ctrl&0x10 != 0 {
+		x = ^x
+	}
+	if ctrl&0x08 != 0 {
+		y = 0
+	}
+	if ctrl&0x04 != 0 {
+		y = ^y
+	}
+	var out uint16
+	if ctrl&0x02 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if ctrl&0x01 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+func jump(out uint16, j uint16) bool {
+	v := int16(out)
+	return j&0x04 != 0 && v < 0 || j&0x02 != 0 && v == 0 || j&0x01 != 0 && v > 0
+}
@@ -0,0 +1,123 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+	"vmt/assembler"
+)
+
+func TestALU(t *testing.T) {
+	var x, y uint16 = 0x0011, 0x0003
+	tests := []struct {
+		comp string
+		want uint16
+	}{
+		{"0", 0},
+		{"1", 1},
+		{"-1", 0xFFFF},
+		{"D", x},
+		{"A", y},
+		{"!D", ^x},
+		{"-A", -y},
+		{"D+1", x + 1},
+		{"A-1", y - 1},
+		{"D+A", x + y},
+		{"D-A", x - y},
+		{"A-D", y - x},
+		{"D&A", x & y},
+		{"D|A", x | y},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comp, func(t *testing.T) {
+			if got := ALU(x, y, assembler.Comp[tt.comp]&0x3F); got != tt.want {
+				t.Errorf("ALU(%s) = %#x, want %#x", tt.comp, got, tt.want)
+			}
+		})
+	}
+}
+
+func load(t *testing.T, src string) *CPU {
+	t.Helper()
+	code, err := assembler.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+	return New(code)
+}
+
+func TestCPU_Run(t *testing.T) {
+	// RAM[2] = RAM[0] * RAM[1]
+	cpu := load(t, `
+@2
+M=0
+(LOOP)
+@1
+D=M
+@END
+D;JEQ
+@0
+D=M
+@2
+M=D+M
+@1
+M=M-1
+@LOOP
+0;JMP
+(END)
+@END
+0;JMP
+`)
+	cpu.RAM[0] = 7
+	cpu.RAM[1] = 6
+	if err := cpu.Run(1000); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !cpu.Halted {
+		t.Errorf("Run() did not halt after %d cycles", cpu.Cycles)
+	}
+	if cpu.RAM[2] != 42 {
+		t.Errorf("RAM[2] = %d, want 42", cpu.RAM[2])
+	}
+}
+
+func TestCPU_Step_jumps(t *testing.T) {
+	tests := []struct {
+		jump string
+		d    int16
+		want bool
+	}{
+		{"JGT", 1, true},
+		{"JGT", 0, false},
+		{"JEQ", 0, true},
+		{"JGE", -1, false},
+		{"JLT", -1, true},
+		{"JNE", 0, false},
+		{"JLE", 0, true},
+		{"JMP", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jump, func(t *testing.T) {
+			cpu := load(t, "@10\nD;"+tt.jump+"\n")
+			cpu.D = uint16(tt.d)
+			cpu.Step()
+			cpu.Step()
+			if got := cpu.PC == 10; got != tt.want {
+				t.Errorf("D=%d;%s jumped = %v, want %v", tt.d, tt.jump, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCPU_Step_errors(t *testing.T) {
+	cpu := load(t, "@0\nA=!A\nM=1\n")
+	cpu.Step()
+	cpu.Step()
+	if err := cpu.Step(); err == nil {
+		t.Errorf("Step() wrote outside of RAM without error")
+	}
+
+	cpu = load(t, "@1\n")
+	if err := cpu.Run(10); err == nil {
+		t.Errorf("Run() ran past the end of ROM without error")
+	}
+}
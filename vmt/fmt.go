@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"vmt/diag"
+	"vmt/format"
+)
+
+func vmfmt(args []string) error {
+	fs := newFlagSet("fmt", "[flags] {vm file, directory, glob pattern or -} ...")
+	write := fs.Bool("w", false, "write the result to the file instead of standard output")
+	diff := fs.Bool("d", false, "print a diff of the changes instead of the result")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{stdio}
+	}
+	paths, err := expand(inputs)
+	if err != nil {
+		return err
+	}
+
+	// format every file that parses and report the others
+	var errs diag.List
+	for _, p := range paths {
+		if p == stdio && *write {
+			return usageErrorf("-w cannot write standard input")
+		}
+		err := formatFile(p, *write, *diff)
+		var l diag.List
+		if errors.As(err, &l) {
+			errs = append(errs, l...)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errs.Err()
+}
+
+// formatFile formats p, or standard input for "-", and prints the result or
+// its diff, or writes it back to p when it changed.
+func formatFile(p string, write, diff bool) error {
+	var src []byte
+	var err error
+	if p == stdio {
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(p)
+	}
+	if err != nil {
+		return err
+	}
+	res, err := format.Source(src)
+	var l diag.List
+	if errors.As(err, &l) {
+		for _, d := range l {
+			d.File = p
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if diff {
+		if d := format.Diff(p+".orig", src, p, res); d != nil {
+			if _, err := os.Stdout.Write(d); err != nil {
+				return err
+			}
+		}
+	}
+	if write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		fInfo, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(p, res, fInfo.Mode().Perm())
+	}
+	if !diff {
+		_, err = os.Stdout.Write(res)
+	}
+	return err
+}
@@ -0,0 +1,185 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// context is the number of unchanged lines around every change in a diff.
+const context = 3
+
+// Diff returns the unified diff of the files a and b named oldName and
+// newName, or nil when they are equal.
+//
+// Lines that occur once in each file anchor the diff, as in patience diff,
+// and the lines between anchors are matched from both ends. That is not
+// always the shortest diff, but it is fast and reads well for the line by
+// line changes of formatting.
+func Diff(oldName string, a []byte, newName string, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	x, y := lines(a), lines(b)
+
+	out := bytes.NewBufferString("")
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", oldName, newName)
+	var (
+		hunk    []string
+		start   pair // first line of the hunk
+		counted pair // lines of the hunk in each file
+		done    pair // lines written or skipped so far
+	)
+	for _, m := range anchors(x, y) {
+		if m.x < done.x {
+			continue
+		}
+		// extend the match in both directions
+		first, end := m, m
+		for first.x > done.x && first.y > done.y && x[first.x-1] == y[first.y-1] {
+			first.x--
+			first.y--
+		}
+		for end.x < len(x) && end.y < len(y) && x[end.x] == y[end.y] {
+			end.x++
+			end.y++
+		}
+
+		for _, s := range x[done.x:first.x] {
+			hunk = append(hunk, "-"+s)
+			counted.x++
+		}
+		for _, s := range y[done.y:first.y] {
+			hunk = append(hunk, "+"+s)
+			counted.y++
+		}
+
+		// a short run of equal lines does not end the hunk
+		eof := end.x == len(x) && end.y == len(y)
+		equal := end.x - first.x
+		if !eof && (equal < context || len(hunk) > 0 && equal < 2*context) {
+			for _, s := range x[first.x:end.x] {
+				hunk = append(hunk, " "+s)
+				counted.x++
+				counted.y++
+			}
+			done = end
+			continue
+		}
+
+		if len(hunk) > 0 {
+			n := equal
+			if n > context {
+				n = context
+			}
+			for _, s := range x[first.x : first.x+n] {
+				hunk = append(hunk, " "+s)
+				counted.x++
+				counted.y++
+			}
+			// line numbers are 1-based, except for an empty range
+			from, to := start.x, start.y
+			if counted.x > 0 {
+				from++
+			}
+			if counted.y > 0 {
+				to++
+			}
+			fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", from, counted.x, to, counted.y)
+			for _, s := range hunk {
+				out.WriteString(s)
+			}
+			hunk, counted = hunk[:0], pair{}
+		}
+		if eof {
+			break
+		}
+
+		// start the next hunk with the lines before the next change
+		start = pair{end.x - context, end.y - context}
+		if start.x < first.x || start.y < first.y {
+			start = first
+		}
+		for _, s := range x[start.x:end.x] {
+			hunk = append(hunk, " "+s)
+			counted.x++
+			counted.y++
+		}
+		done = end
+	}
+	return out.Bytes()
+}
+
+type pair struct{ x, y int }
+
+// lines splits b into lines ending in "\n". A last line without one is
+// marked as in diff.
+func lines(b []byte) []string {
+	l := strings.SplitAfter(string(b), "\n")
+	if l[len(l)-1] == "" {
+		return l[:len(l)-1]
+	}
+	l[len(l)-1] += "\n\\ No newline at end of file\n"
+	return l
+}
+
+/*
+anchors returns the longest increasing sequence of lines that occur exactly
+once in x and once in y, as pairs of indexes, between the pairs {0, 0} and
+{len(x), len(y)}.
+
+It is the longest increasing subsequence of the y indexes of those lines in
+the order of x, found by patience sorting.
+*/
+func anchors(x, y []string) []pair {
+	count := map[string]pair{}
+	for _, s := range x {
+		c := count[s]
+		c.x++
+		count[s] = c
+	}
+	for _, s := range y {
+		c := count[s]
+		c.y++
+		count[s] = c
+	}
+	yIndex := map[string]int{}
+	for i, s := range y {
+		if count[s] == (pair{1, 1}) {
+			yIndex[s] = i
+		}
+	}
+	var unique []pair
+	for i, s := range x {
+		if j, ok := yIndex[s]; ok {
+			unique = append(unique, pair{i, j})
+		}
+	}
+
+	// tops[k] is the index in unique of the smallest y that ends an
+	// increasing sequence of length k+1, prev the element before each
+	var tops []int
+	prev := make([]int, len(unique))
+	for i, p := range unique {
+		k := sort.Search(len(tops), func(k int) bool { return unique[tops[k]].y >= p.y })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tops[k-1]
+		}
+		if k == len(tops) {
+			tops = append(tops, i)
+		} else {
+			tops[k] = i
+		}
+	}
+
+	seq := make([]pair, len(tops)+2)
+	seq[len(seq)-1] = pair{len(x), len(y)}
+	if len(tops) > 0 {
+		for i, k := tops[len(tops)-1], len(tops); i >= 0; i, k = prev[i], k-1 {
+			seq[k] = unique[i]
+		}
+	}
+	return seq
+}
@@ -0,0 +1,183 @@
+/*
+Package format rewrites VM source in the canonical layout that vmt fmt
+writes.
+
+Commands are written with a single space between their words, the commands
+of a function body are indented and labels and functions are not. Comments
+are kept: comments on their own line are indented like the command they
+precede, and the comments at the end of consecutive lines are aligned. Runs
+of blank lines become one, and every function is preceded by a blank line.
+Formatting formatted source changes nothing, and neither does formatting
+change the words of a command, apart from the spelling of numbers: a line
+that would lose or change a word is an error instead.
+*/
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+// indent is the indentation of function bodies.
+const indent = "    "
+
+// line is a line of output. A line without code and comment is blank.
+type line struct {
+	indent     bool
+	code       string
+	hasComment bool
+	comment    string
+}
+
+func (l line) blank() bool { return l.code == "" && !l.hasComment }
+
+// Source formats the VM source src. Syntax errors are returned as the
+// diag.List of parser.Parse.
+func Source(src []byte) ([]byte, error) {
+	lines, err := parser.ParseLines(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(src, lines); err != nil {
+		return nil, err
+	}
+
+	// commands, comments and single blank lines
+	var out []line
+	inFunction := false
+	for _, l := range lines {
+		o := line{hasComment: l.HasComment, comment: comment(l.Comment)}
+		if c := l.Command; c != nil {
+			o.code = c.String()
+			if c.Type == parser.FUNCTION {
+				inFunction = true
+			}
+			o.indent = inFunction && c.Type != parser.FUNCTION && c.Type != parser.LABEL
+		} else {
+			// until the next command, comments belong to the body
+			o.indent = inFunction
+		}
+		if o.blank() && (len(out) == 0 || out[len(out)-1].blank()) {
+			continue
+		}
+		out = append(out, o)
+	}
+	for len(out) > 0 && out[len(out)-1].blank() {
+		out = out[:len(out)-1]
+	}
+
+	// comments directly above a command are indented like it, and a
+	// function and its comments are set off by a blank line
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].code == "" {
+			continue
+		}
+		j := i
+		for j > 0 && out[j-1].code == "" && !out[j-1].blank() {
+			j--
+			out[j].indent = out[i].indent
+		}
+		if strings.HasPrefix(out[i].code, "function ") && j > 0 && !out[j-1].blank() {
+			out = append(out[:j], append([]line{{}}, out[j:]...)...)
+		}
+		i = j
+	}
+
+	b := bytes.NewBufferString("")
+	for i := 0; i < len(out); {
+		// consecutive commands with comments are aligned
+		j, width := i, 0
+		for ; j < len(out) && out[j].code != "" && out[j].hasComment; j++ {
+			if w := len(out[j].prefix()); w > width {
+				width = w
+			}
+		}
+		if j > i {
+			for _, l := range out[i:j] {
+				p := l.prefix()
+				b.WriteString(p + strings.Repeat(" ", width-len(p)) + " //" + l.comment + "\n")
+			}
+			i = j
+			continue
+		}
+
+		l := out[i]
+		b.WriteString(l.prefix())
+		if l.hasComment {
+			b.WriteString("//" + l.comment)
+		}
+		b.WriteString("\n")
+		i++
+	}
+	return b.Bytes(), nil
+}
+
+// verify fails with a diag.List when the canonical form of a command of src
+// does not have the same words as the command itself, so that formatting
+// never changes what the source means.
+func verify(src []byte, lines []parser.Line) error {
+	var l diag.List
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for i := 0; i < len(lines) && s.Scan(); i++ {
+		c := lines[i].Command
+		if c == nil {
+			continue
+		}
+		code := s.Text()
+		if j := strings.Index(code, "//"); j >= 0 {
+			code = code[:j]
+		}
+		if words := strings.Fields(code); !sameWords(words, strings.Fields(c.String())) {
+			l = append(l, diag.Errorf("unformattable", "%q cannot be formatted without changing it", strings.Join(words, " ")).At("", i+1, 0))
+		}
+	}
+	if len(l) > 0 {
+		return l
+	}
+	return nil
+}
+
+// sameWords reports whether a and b have the same words, taking numbers
+// such as 007 and 7 to be the same.
+func sameWords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		x, errx := strconv.Atoi(a[i])
+		y, erry := strconv.Atoi(b[i])
+		if errx != nil || erry != nil || x != y {
+			return false
+		}
+	}
+	return true
+}
+
+// prefix returns l up to its comment.
+func (l line) prefix() string {
+	if l.indent && !l.blank() {
+		return indent + l.code
+	}
+	return l.code
+}
+
+// comment returns the text of a comment without trailing space and with a
+// space after "//" when it starts with a letter or digit.
+func comment(text string) string {
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return " " + text
+		}
+		break
+	}
+	return text
+}
@@ -0,0 +1,168 @@
+package format
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"spaces between words",
+			"push   constant\t7\r\n  add  \n",
+			"push constant 7\nadd\n",
+		},
+		{
+			"function bodies",
+			"function Main.main 1\npush local 0\nlabel LOOP\ngoto LOOP\nreturn\n",
+			"function Main.main 1\n    push local 0\nlabel LOOP\n    goto LOOP\n    return\n",
+		},
+		{
+			"blank lines",
+			"\n\npush constant 1\n\n\n\nadd\n\n\n",
+			"push constant 1\n\nadd\n",
+		},
+		{
+			"blank line before functions",
+			"function A.a 0\nreturn\n// B.b\nfunction B.b 0\nreturn\n",
+			"function A.a 0\n    return\n\n// B.b\nfunction B.b 0\n    return\n",
+		},
+		{
+			"comments",
+			"//header\nfunction A.a 0\n  // push\npush constant 1   \n//\n//---\nreturn\n// end\n",
+			"// header\nfunction A.a 0\n    // push\n    push constant 1\n    //\n    //---\n    return\n    // end\n",
+		},
+		{
+			"comment above a label",
+			"function A.a 0\n// loop\nlabel LOOP\ngoto LOOP\n",
+			"function A.a 0\n// loop\nlabel LOOP\n    goto LOOP\n",
+		},
+		{
+			"aligned comments",
+			"function A.a 0 // a\npush constant 1 // one\nadd // sum\nreturn\nlabel X // x\n",
+			"function A.a 0      // a\n    push constant 1 // one\n    add             // sum\n    return\nlabel X // x\n",
+		},
+		{"empty", "\n\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source([]byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Source() =\n%s\nwant\n%s", got, tt.want)
+			}
+			again, err := Source(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("Source() of formatted source =\n%s\nwant\n%s", again, got)
+			}
+		})
+	}
+}
+
+func TestSource_commands(t *testing.T) {
+	src := "function Main.main 0 // x\n  push constant 007\n\tcall Math.multiply 2\n\nif-goto END\nlabel END\nreturn\n"
+	got, err := Source([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := parser.Parse(strings.NewReader(src))
+	after, _ := parser.Parse(strings.NewReader(string(got)))
+	if len(before) != len(after) {
+		t.Fatalf("Source() has %d commands, want %d", len(after), len(before))
+	}
+	for i := range before {
+		if before[i].String() != after[i].String() {
+			t.Errorf("command %d = %s, want %s", i, after[i], before[i])
+		}
+	}
+}
+
+func TestSource_error(t *testing.T) {
+	_, err := Source([]byte("push constant 1\npush local\n"))
+	var l diag.List
+	if !errors.As(err, &l) || len(l) != 1 || l[0].Line != 2 {
+		t.Errorf("Source() error = %v, want a diagnostic on line 2", err)
+	}
+}
+
+// Words the parser does not take must never be dropped silently.
+func TestSource_extraWords(t *testing.T) {
+	for _, src := range []string{"push constant 7 8\n", "add foo\n", "return 0\n"} {
+		if got, err := Source([]byte(src)); err == nil {
+			t.Errorf("Source(%q) = %q, want an error", src, got)
+		}
+	}
+}
+
+func Test_verify(t *testing.T) {
+	src := "push constant 007\nadd // add\n"
+	lines := []parser.Line{
+		{Command: &parser.Command{Type: parser.PUSH, Arg1: "constant", Arg2: 7, Line: 1}},
+		{Command: &parser.Command{Type: parser.ARITHMETIC, Arg1: "add", Line: 2}, HasComment: true, Comment: " add"},
+	}
+	if err := verify([]byte(src), lines); err != nil {
+		t.Errorf("verify() error = %v, want nil", err)
+	}
+
+	// a command whose canonical form has other words
+	lines[0].Command.Arg1 = "local"
+	var l diag.List
+	if err := verify([]byte(src), lines); !errors.As(err, &l) || len(l) != 1 || l[0].Line != 1 || l[0].Rule != "unformattable" {
+		t.Errorf("verify() error = %v, want unformattable on line 1", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{
+			"changed line",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			"1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			"--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			"two hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			"added and removed lines",
+			"a\nb\nc\n",
+			"a\nc\nd\n",
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n c\n+d\n",
+		},
+		{
+			"no newline at end",
+			"a\nb",
+			"a\nb\n",
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{"empty file", "", "a\n", "--- a\n+++ b\n@@ -0,0 +1,1 @@\n+a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff("a", []byte(tt.a), "b", []byte(tt.b))
+			if string(got) != tt.want {
+				t.Errorf("Diff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzSource(f *testing.F) {
+	seeds := []string{
+		"function Main.main 2\npush local 0 // x\nreturn\n",
+		"//a\n\n\nlabel LOOP // comment\n  if-goto LOOP\ngoto LOOP\n",
+		"push constant 1//c\r\n\t//\t\n\n",
+		"function A.a 0\nreturn\n// b\nfunction B.b 0\n",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		got, err := Source([]byte(src))
+		if err != nil {
+			return
+		}
+		// formatting formatted source changes nothing
+		again, err := Source(got)
+		if err != nil {
+			t.Fatalf("Source() of %q is invalid: %v", got, err)
+		}
+		if !bytes.Equal(again, got) {
+			t.Errorf("Source() of %q = %q, want it unchanged", got, again)
+		}
+	})
+}
@@ -0,0 +1,326 @@
+/*
+Package generator builds random but well-formed VM programs for fuzzing the
+translator.
+
+Every generated program
+
+  - consists of Sys.vm plus Config.Files class files,
+  - keeps the stack balanced: each statement leaves the stack as it found it
+    and every function returns exactly one value,
+  - only calls functions later in a fixed random order, so there is no
+    recursion,
+  - only loops on dedicated counter locals that count down to zero,
+
+and therefore always terminates in the halt loop at the end of Sys.init.
+The same seed and Config always produce the same program.
+*/
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"vmt/parser"
+)
+
+// Config bounds the shape of a generated program.
+type Config struct {
+	Files      int // class files besides Sys.vm
+	Functions  int // functions per class file
+	MaxArgs    int // arguments per function
+	MaxLocals  int // locals per function, not counting loop counters
+	MaxStatics int // static variables per file
+	MaxStmts   int // statements per block
+	MaxDepth   int // nesting of blocks and expressions
+	MaxCalls   int // call sites per function
+}
+
+// DefaultConfig produces programs of about a thousand commands.
+var DefaultConfig = Config{
+	Files:      3,
+	Functions:  3,
+	MaxArgs:    3,
+	MaxLocals:  3,
+	MaxStatics: 4,
+	MaxStmts:   4,
+	MaxDepth:   3,
+	MaxCalls:   2,
+}
+
+// heap areas used as the targets of pointer 0 and pointer 1
+const (
+	thisBase = 3000
+	thatBase = 3100
+	heapSize = 8
+)
+
+type function struct {
+	file    string
+	name    string
+	nargs   int
+	nlocals int
+}
+
+type gen struct {
+	r     *rand.Rand
+	cfg   Config
+	funcs []function // in call order; funcs[i] only calls funcs[j] with j > i
+
+	// state of the function being generated
+	cur      int
+	cmds     []parser.Command
+	labels   int
+	calls    int
+	counters int
+	loops    int
+	pointers bool // pointer 0 and 1 point into the heap areas
+}
+
+// Generate returns a program built from seed. Sys.vm is always the first file.
+func Generate(seed int64, cfg Config) []parser.File {
+	g := &gen{
+		r:   rand.New(rand.NewSource(seed)),
+		cfg: cfg,
+	}
+	for i := 0; i < cfg.Files; i++ {
+		file := fmt.Sprintf("Class%d", i)
+		for j := 0; j < cfg.Functions; j++ {
+			g.funcs = append(g.funcs, function{
+				file:    file,
+				name:    fmt.Sprintf("%s.f%d", file, j),
+				nargs:   g.r.Intn(cfg.MaxArgs + 1),
+				nlocals: g.r.Intn(cfg.MaxLocals + 1),
+			})
+		}
+	}
+	g.r.Shuffle(len(g.funcs), func(i, j int) {
+		g.funcs[i], g.funcs[j] = g.funcs[j], g.funcs[i]
+	})
+
+	files := []parser.File{{Name: "Sys", Commands: g.sysInit()}}
+	for i := 0; i < cfg.Files; i++ {
+		files = append(files, parser.File{Name: fmt.Sprintf("Class%d", i)})
+	}
+	for i, fn := range g.funcs {
+		for j := range files {
+			if files[j].Name == fn.file {
+				files[j].Commands = append(files[j].Commands, g.function(i)...)
+			}
+		}
+	}
+	return files
+}
+
+// sysInit calls the first functions in call order, stores their results in
+// Sys statics and halts.
+func (g *gen) sysInit() []parser.Command {
+	g.begin(-1)
+	g.emit(parser.FUNCTION, "Sys.init", 0)
+	for i := 0; i < len(g.funcs) && i < 3; i++ {
+		for a := 0; a < g.funcs[i].nargs; a++ {
+			g.emit(parser.PUSH, "constant", g.constant())
+		}
+		g.emit(parser.CALL, g.funcs[i].name, g.funcs[i].nargs)
+		g.emit(parser.POP, "static", i)
+	}
+	g.emit(parser.LABEL, "HALT", 0)
+	g.emit(parser.GOTO, "HALT", 0)
+	return g.cmds
+}
+
+func (g *gen) function(i int) []parser.Command {
+	g.begin(i)
+	fn := g.funcs[i]
+	if g.r.Intn(2) == 0 {
+		g.pointers = true
+		g.emit(parser.PUSH, "constant", thisBase)
+		g.emit(parser.POP, "pointer", 0)
+		g.emit(parser.PUSH, "constant", thatBase)
+		g.emit(parser.POP, "pointer", 1)
+	}
+	g.block(g.cfg.MaxDepth)
+	g.expr(g.cfg.MaxDepth)
+	g.emit(parser.RETURN, "", 0)
+
+	header := parser.Command{Type: parser.FUNCTION, Arg1: fn.name, Arg2: fn.nlocals + g.counters}
+	return append([]parser.Command{header}, g.cmds...)
+}
+
+func (g *gen) begin(i int) {
+	g.cur = i
+	g.cmds = nil
+	g.labels = 0
+	g.calls = 0
+	g.counters = 0
+	g.loops = 0
+	g.pointers = false
+}
+
+func (g *gen) emit(t parser.Type, arg1 string, arg2 int) {
+	g.cmds = append(g.cmds, parser.Command{Type: t, Arg1: arg1, Arg2: arg2})
+}
+
+func (g *gen) label(prefix string) string {
+	l := fmt.Sprintf("%s%d", prefix, g.labels)
+	g.labels++
+	return l
+}
+
+func (g *gen) constant() int {
+	if g.r.Intn(4) == 0 {
+		return g.r.Intn(32768)
+	}
+	return g.r.Intn(16)
+}
+
+func (g *gen) block(depth int) {
+	n := 1 + g.r.Intn(g.cfg.MaxStmts)
+	for i := 0; i < n; i++ {
+		g.statement(depth)
+	}
+}
+
+func (g *gen) statement(depth int) {
+	choice := 0
+	if depth > 0 {
+		choice = g.r.Intn(4)
+	}
+	switch choice {
+	case 0, 1:
+		g.expr(depth)
+		g.store()
+	case 2:
+		g.expr(depth - 1)
+		tlabel, elabel := g.label("IF_TRUE"), g.label("IF_END")
+		g.emit(parser.IF, tlabel, 0)
+		g.block(depth - 1)
+		g.emit(parser.GOTO, elabel, 0)
+		g.emit(parser.LABEL, tlabel, 0)
+		g.block(depth - 1)
+		g.emit(parser.LABEL, elabel, 0)
+	case 3:
+		g.loop(depth)
+	}
+}
+
+// loop counts a dedicated local down from a small constant to zero. Calls
+// are not generated inside loops to keep the run time of a program bounded.
+func (g *gen) loop(depth int) {
+	counter := g.funcs[g.cur].nlocals + g.loops
+	g.loops++
+	if g.loops > g.counters {
+		g.counters = g.loops
+	}
+	calls := g.calls
+	g.calls = g.cfg.MaxCalls
+
+	top, end := g.label("WHILE_EXP"), g.label("WHILE_END")
+	g.emit(parser.PUSH, "constant", 1+g.r.Intn(3))
+	g.emit(parser.POP, "local", counter)
+	g.emit(parser.LABEL, top, 0)
+	g.emit(parser.PUSH, "local", counter)
+	g.emit(parser.PUSH, "constant", 0)
+	g.emit(parser.ARITHMETIC, "eq", 0)
+	g.emit(parser.IF, end, 0)
+	g.block(depth - 1)
+	g.emit(parser.PUSH, "local", counter)
+	g.emit(parser.PUSH, "constant", 1)
+	g.emit(parser.ARITHMETIC, "sub", 0)
+	g.emit(parser.POP, "local", counter)
+	g.emit(parser.GOTO, top, 0)
+	g.emit(parser.LABEL, end, 0)
+
+	g.calls = calls
+	g.loops--
+}
+
+// store pops the top of the stack into a writable location.
+func (g *gen) store() {
+	fn := g.funcs[g.cur]
+	switch g.r.Intn(5) {
+	case 0:
+		if fn.nlocals > 0 {
+			g.emit(parser.POP, "local", g.r.Intn(fn.nlocals))
+			return
+		}
+	case 1:
+		g.emit(parser.POP, "static", g.r.Intn(g.cfg.MaxStatics))
+		return
+	case 2:
+		if g.pointers {
+			g.emit(parser.POP, "this", g.r.Intn(heapSize))
+			return
+		}
+	case 3:
+		if g.pointers {
+			g.emit(parser.POP, "that", g.r.Intn(heapSize))
+			return
+		}
+	}
+	g.emit(parser.POP, "temp", g.r.Intn(8))
+}
+
+// expr pushes exactly one value.
+func (g *gen) expr(depth int) {
+	choice := 0
+	if depth > 0 {
+		choice = g.r.Intn(5)
+	}
+	switch choice {
+	case 1:
+		g.expr(depth - 1)
+		g.emit(parser.ARITHMETIC, []string{"neg", "not"}[g.r.Intn(2)], 0)
+		return
+	case 2, 3:
+		g.expr(depth - 1)
+		g.expr(depth - 1)
+		ops := []string{"add", "sub", "and", "or", "eq", "gt", "lt"}
+		g.emit(parser.ARITHMETIC, ops[g.r.Intn(len(ops))], 0)
+		return
+	case 4:
+		if g.call(depth) {
+			return
+		}
+	}
+	g.leaf()
+}
+
+func (g *gen) call(depth int) bool {
+	if g.calls >= g.cfg.MaxCalls || g.cur+1 >= len(g.funcs) {
+		return false
+	}
+	g.calls++
+	callee := g.funcs[g.cur+1+g.r.Intn(len(g.funcs)-g.cur-1)]
+	for i := 0; i < callee.nargs; i++ {
+		g.expr(depth - 1)
+	}
+	g.emit(parser.CALL, callee.name, callee.nargs)
+	return true
+}
+
+func (g *gen) leaf() {
+	fn := g.funcs[g.cur]
+	switch g.r.Intn(7) {
+	case 1:
+		if fn.nargs > 0 {
+			g.emit(parser.PUSH, "argument", g.r.Intn(fn.nargs))
+			return
+		}
+	case 2:
+		if fn.nlocals > 0 {
+			g.emit(parser.PUSH, "local", g.r.Intn(fn.nlocals))
+			return
+		}
+	case 3:
+		g.emit(parser.PUSH, "static", g.r.Intn(g.cfg.MaxStatics))
+		return
+	case 4:
+		g.emit(parser.PUSH, "temp", g.r.Intn(8))
+		return
+	case 5:
+		if g.pointers {
+			g.emit(parser.PUSH, []string{"this", "that"}[g.r.Intn(2)], g.r.Intn(heapSize))
+			return
+		}
+	}
+	g.emit(parser.PUSH, "constant", g.constant())
+}
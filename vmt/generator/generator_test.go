@@ -0,0 +1,104 @@
+package generator
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"vmt/parser"
+)
+
+func TestGenerate_deterministic(t *testing.T) {
+	a := Generate(42, DefaultConfig)
+	b := Generate(42, DefaultConfig)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Generate() is not deterministic for the same seed")
+	}
+	c := Generate(43, DefaultConfig)
+	if reflect.DeepEqual(a, c) {
+		t.Errorf("Generate() returned the same program for different seeds")
+	}
+}
+
+func TestGenerate_wellFormed(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		files := Generate(seed, DefaultConfig)
+		if len(files) != DefaultConfig.Files+1 || files[0].Name != "Sys" {
+			t.Fatalf("seed %d: unexpected files %v", seed, files)
+		}
+
+		funcs := map[string]int{}
+		for _, f := range files {
+			for _, c := range f.Commands {
+				if c.Type == parser.FUNCTION {
+					funcs[c.Arg1] = 0
+				}
+			}
+		}
+		for _, f := range files {
+			// the printed program must parse back to the same commands
+			b := bytes.NewBufferString("")
+			f.WriteTo(b)
+			cmds, err := parser.Parse(b)
+			if err != nil {
+				t.Fatalf("seed %d: %s.vm does not parse: %v", seed, f.Name, err)
+			}
+			if len(cmds) != len(f.Commands) {
+				t.Fatalf("seed %d: %s.vm parsed to %d commands, want %d", seed, f.Name, len(cmds), len(f.Commands))
+			}
+			checkFunctions(t, seed, f, funcs)
+		}
+	}
+}
+
+// checkFunctions verifies the stack balance, labels and calls of each function.
+func checkFunctions(t *testing.T, seed int64, f parser.File, funcs map[string]int) {
+	depth := 0
+	labels := map[string]bool{}
+	var jumps []string
+	for i, c := range f.Commands {
+		switch c.Type {
+		case parser.FUNCTION:
+			if i > 0 && f.Commands[i-1].Type != parser.RETURN {
+				t.Errorf("seed %d: %s does not end with return before %s", seed, f.Name, c.Arg1)
+			}
+			depth = 0
+			labels = map[string]bool{}
+			jumps = nil
+		case parser.PUSH:
+			depth++
+		case parser.POP:
+			depth--
+		case parser.IF:
+			depth--
+			jumps = append(jumps, c.Arg1)
+		case parser.ARITHMETIC:
+			if c.Arg1 != "neg" && c.Arg1 != "not" {
+				depth--
+			}
+		case parser.CALL:
+			if _, ok := funcs[c.Arg1]; !ok {
+				t.Errorf("seed %d: call to undefined function %s", seed, c.Arg1)
+			}
+			depth -= c.Arg2 - 1
+		case parser.LABEL:
+			if labels[c.Arg1] {
+				t.Errorf("seed %d: label %s defined twice", seed, c.Arg1)
+			}
+			labels[c.Arg1] = true
+		case parser.GOTO:
+			jumps = append(jumps, c.Arg1)
+		case parser.RETURN:
+			if depth != 1 {
+				t.Errorf("seed %d: %s returns with stack depth %d", seed, f.Name, depth)
+			}
+			for _, j := range jumps {
+				if !labels[j] {
+					t.Errorf("seed %d: jump to undefined label %s", seed, j)
+				}
+			}
+		}
+		if depth < 0 {
+			t.Fatalf("seed %d: %s line %d: stack underflow", seed, f.Name, i)
+		}
+	}
+}
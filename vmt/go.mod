@@ -1,3 +1,3 @@
 module vmt
 
-go 1.14
+go 1.18
@@ -0,0 +1,282 @@
+/*
+Package gogen translates VM code into Go source, to embed VM programs in Go
+programs and run them in-process.
+
+The source declares a Machine with the 32768 words of Hack RAM as int16, so
+that arithmetic wraps around at 16 bits like on the Hack CPU, and a method
+Run that runs the program on it. The segments live in RAM where the assembly
+of the codewriter package keeps them. Run is one function in which every VM
+function and label is a Go label; calls push the ROM address the Hack code
+would return to and returns jump back through a switch over those addresses,
+so a program leaves the same RAM behind as on the Hack CPU emulator.
+
+Run returns at the loop the emulator halts at, label X followed by goto X,
+or at the end of the code. Reads of the keyboard and writes to the screen go
+through the Keyboard and Screen hooks of the Machine when they are set. In
+package main the source also has a main function, which sets RAM from its
+arguments such as 0=256, runs the program and prints the RAM words that are
+not 0.
+*/
+package gogen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"vmt/codewriter"
+	"vmt/parser"
+)
+
+// Writer translates VM commands into Go. It implements codewriter.Backend.
+type Writer struct {
+	// Package is the package of the source, main unless set before Close.
+	Package string
+
+	codewriter.Native
+	w io.Writer
+}
+
+// New returns a Writer that writes Go to w when it is closed, with the
+// Options of codewriter.Native.Init.
+func New(w io.Writer, opt codewriter.Options) *Writer {
+	cw := &Writer{Package: "main", w: w}
+	cw.Init(cw, opt)
+	return cw
+}
+
+func (cw *Writer) Comment(text string) string {
+	return "// " + text
+}
+
+func (cw *Writer) Bootstrap() {
+	cw.Printf("m.RAM[0] = 256")
+}
+
+var binary = map[string]string{
+	"add": "x + y",
+	"sub": "x - y",
+	"and": "x & y",
+	"or":  "x | y",
+	"eq":  "truth(x-y == 0)",
+	"gt":  "truth(x-y > 0)",
+	"lt":  "truth(x-y < 0)",
+}
+
+func (cw *Writer) Arithmetic(cmd string) {
+	cw.Printf("m.%s()", cmd)
+}
+
+// segments are the RAM addresses of the segment pointers.
+var segments = map[string]int{"local": 1, "argument": 2, "this": 3, "that": 4}
+
+func (cw *Writer) PushPop(cmd parser.Type, segment string, index int) {
+	// the address of the word, for the segments that are not pointers
+	addr := ""
+	switch segment {
+	case "pointer":
+		addr = fmt.Sprint(3 + index)
+	case "temp":
+		addr = fmt.Sprint(5 + index)
+	case "static":
+		addr = static(cw.Static(index))
+	}
+	switch {
+	case cmd == parser.PUSH && segment == "constant":
+		cw.Printf("m.push(%d)", index)
+	case cmd == parser.PUSH && addr != "":
+		cw.Printf("m.push(m.RAM[%s])", addr)
+	case cmd == parser.PUSH:
+		cw.Printf("m.RAM[13] = m.RAM[%d] + %d", segments[segment], index)
+		cw.Printf("m.push(m.read(m.RAM[13]))")
+	case addr != "":
+		cw.Printf("m.RAM[%s] = m.pop()", addr)
+	default:
+		cw.Printf("m.RAM[0]--")
+		cw.Printf("m.RAM[13] = m.RAM[%d] + %d", segments[segment], index)
+		cw.Printf("m.write(m.RAM[13], m.read(m.RAM[0]))")
+	}
+}
+
+func (cw *Writer) Goto(name string) {
+	cw.Printf("goto %s", name)
+}
+
+func (cw *Writer) Halt() {
+	cw.Printf("return nil // halt")
+}
+
+func (cw *Writer) If(name string) {
+	cw.Printf("if m.pop() != 0 {\n\t\tgoto %s\n\t}", name)
+}
+
+func (cw *Writer) PushZero() {
+	cw.Printf("m.push(0)")
+}
+
+func (cw *Writer) Call(name string, numargs, ret int) {
+	cw.Printf("m.call(%d, %d)", ret, numargs)
+	cw.Printf("goto %s", name)
+}
+
+func (cw *Writer) Return() {
+	cw.Printf("m.ret()")
+	cw.Printf("goto dispatch")
+}
+
+// WriteProgram writes the Go source.
+func (cw *Writer) WriteProgram() error {
+	w := bufio.NewWriter(cw.w)
+	fmt.Fprintf(w, "// Code generated by vmt from VM code. DO NOT EDIT.\n\npackage %s\n", cw.Package)
+	switch {
+	case cw.Package == "main":
+		fmt.Fprint(w, "\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n")
+	case cw.Returned() || len(cw.Missing()) > 0:
+		fmt.Fprint(w, "\nimport \"fmt\"\n")
+	}
+	io.WriteString(w, prelude)
+
+	if statics := cw.Statics(); len(statics) > 0 {
+		fmt.Fprint(w, "\n// static variables\nconst (\n")
+		for _, v := range statics {
+			fmt.Fprintf(w, "\t%s = %d\n", static(v.Symbol), v.Address)
+		}
+		fmt.Fprint(w, ")\n")
+	}
+	for _, op := range []string{"add", "sub", "and", "or", "eq", "gt", "lt"} {
+		fmt.Fprintf(w, "\nfunc (m *Machine) %s() {\n", op)
+		fmt.Fprintf(w, "\ty := m.pop()\n\tx := m.pop()\n\tm.push(%s)\n}\n", binary[op])
+	}
+
+	fmt.Fprint(w, "\n// Run runs the program until it halts or runs past its code.\nfunc (m *Machine) Run() error {\n")
+	cw.WriteBody(w)
+	fmt.Fprint(w, "\treturn nil\n")
+	if cw.Returned() {
+		fmt.Fprint(w, "\ndispatch:\n\tswitch m.RAM[14] {\n")
+		for _, ret := range cw.Returns() {
+			fmt.Fprintf(w, "\tcase %d:\n\t\tgoto R_%d\n", ret, ret)
+		}
+		io.WriteString(w, "\t}\n\treturn fmt.Errorf(\"return to ROM[%d], where no call returns\", m.RAM[14])\n")
+	}
+	for _, name := range cw.Missing() {
+		fmt.Fprintf(w, "%s:\n\treturn fmt.Errorf(\"jump to %s, which is not defined\")\n", name, cw.Symbol(name))
+	}
+	fmt.Fprint(w, "}\n")
+	if cw.Package == "main" {
+		io.WriteString(w, mainFunc)
+	}
+	return w.Flush()
+}
+
+// static returns the Go name of the static variable sym.
+func static(sym string) string {
+	return "s_" + codewriter.Identifier(sym)
+}
+
+const prelude = `
+// Memory mapped I/O of the Hack computer.
+const (
+	Screen = 16384
+	KBD    = 24576
+)
+
+// Machine is the Hack RAM the program runs on.
+type Machine struct {
+	RAM [32768]int16
+
+	// Keyboard is called for every read of KBD and Screen after every write
+	// to the screen, when they are set.
+	Keyboard func() int16
+	Screen   func(addr int, value int16)
+}
+
+func (m *Machine) read(a int16) int16 {
+	i := int(uint16(a) & 0x7FFF)
+	if i == KBD && m.Keyboard != nil {
+		return m.Keyboard()
+	}
+	return m.RAM[i]
+}
+
+func (m *Machine) write(a, v int16) {
+	i := int(uint16(a) & 0x7FFF)
+	m.RAM[i] = v
+	if i >= Screen && i < KBD && m.Screen != nil {
+		m.Screen(i, v)
+	}
+}
+
+func (m *Machine) push(v int16) {
+	m.write(m.RAM[0], v)
+	m.RAM[0]++
+}
+
+func (m *Machine) pop() int16 {
+	m.RAM[0]--
+	return m.read(m.RAM[0])
+}
+
+// truth is the VM value of a condition.
+func truth(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+func (m *Machine) neg() {
+	m.push(-m.pop())
+}
+
+func (m *Machine) not() {
+	m.push(^m.pop())
+}
+
+// call saves the frame of the caller and sets up the frame of the callee.
+func (m *Machine) call(ret, args int16) {
+	m.push(ret)
+	m.push(m.RAM[1])
+	m.push(m.RAM[2])
+	m.push(m.RAM[3])
+	m.push(m.RAM[4])
+	m.RAM[2] = m.RAM[0] - args - 5
+	m.RAM[1] = m.RAM[0]
+}
+
+// ret restores the frame of the caller and leaves the return address in
+// RAM[14].
+func (m *Machine) ret() {
+	m.RAM[13] = m.RAM[1]
+	m.RAM[14] = m.read(m.RAM[13] - 5)
+	m.RAM[0]--
+	m.write(m.RAM[2], m.read(m.RAM[0]))
+	m.RAM[0] = m.RAM[2] + 1
+	m.RAM[4] = m.read(m.RAM[13] - 1)
+	m.RAM[3] = m.read(m.RAM[13] - 2)
+	m.RAM[2] = m.read(m.RAM[13] - 3)
+	m.RAM[1] = m.read(m.RAM[13] - 4)
+}
+`
+
+const mainFunc = `
+func main() {
+	m := &Machine{}
+	for _, arg := range os.Args[1:] {
+		var a int
+		var v int16
+		if _, err := fmt.Sscanf(arg, "%d=%d", &a, &v); err != nil || a < 0 || a >= len(m.RAM) {
+			fmt.Fprintf(os.Stderr, "usage: %s [address=value ...]\n", os.Args[0])
+			os.Exit(2)
+		}
+		m.RAM[a] = v
+	}
+	if err := m.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for a, v := range m.RAM {
+		if v != 0 {
+			fmt.Printf("RAM[%d]\t%d\n", a, v)
+		}
+	}
+}
+`
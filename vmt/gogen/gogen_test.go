@@ -0,0 +1,98 @@
+package gogen
+
+import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vmt/codewriter"
+	"vmt/internal/nativetest"
+	"vmt/parser"
+)
+
+// translate returns the Go source of files in package pkg.
+func translate(t *testing.T, files []parser.File, opt codewriter.Options, pkg string) []byte {
+	t.Helper()
+	b := bytes.NewBufferString("")
+	cw := New(b, opt)
+	cw.Package = pkg
+	if err := codewriter.TranslateTo(cw, files); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	files := []parser.File{{Name: "Main", Commands: []parser.Command{
+		{Type: parser.PUSH, Arg1: "local", Arg2: 2},
+		{Type: parser.POP, Arg1: "static", Arg2: 1},
+		{Type: parser.LABEL, Arg1: "END"},
+		{Type: parser.GOTO, Arg1: "END"},
+	}}}
+	src := translate(t, files, codewriter.Options{}, "prog")
+	for _, want := range []string{
+		"package prog\n",
+		"\ts_Main_2e1 = 16\n",
+		"\t// push local 2\n\tm.RAM[13] = m.RAM[1] + 2\n\tm.push(m.read(m.RAM[13]))\n",
+		"\tm.RAM[s_Main_2e1] = m.pop()\n",
+		"\treturn nil // halt\n",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Go source without %q:\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "L_Main_24END:") || strings.Contains(string(src), "import") {
+		t.Errorf("Go source with unused labels or imports:\n%s", src)
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(formatted, src) {
+		t.Errorf("Go source is not formatted:\n%s", src)
+	}
+}
+
+func TestWriter_undefined(t *testing.T) {
+	files := []parser.File{{Name: "Main", Commands: []parser.Command{
+		{Type: parser.CALL, Arg1: "Foo.bar", Arg2: 0},
+	}}}
+	src := translate(t, files, codewriter.Options{}, "prog")
+	if want := "F_Foo_2ebar:\n\treturn fmt.Errorf(\"jump to Foo.bar, which is not defined\")\n"; !strings.Contains(string(src), want) {
+		t.Errorf("Go source without %q:\n%s", want, src)
+	}
+}
+
+// The program must leave the same RAM behind as the Hack code on the
+// emulator, word for word.
+func TestWriter_generated(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	dir, err := ioutil.TempDir("", "gogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nativetest.Generated(t, 3, func(files []parser.File, opt codewriter.Options) []byte {
+		src := filepath.Join(dir, "prog.go")
+		if err := ioutil.WriteFile(src, translate(t, files, opt, "main"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		exe := filepath.Join(dir, "prog")
+		if out, err := exec.Command(goTool, "build", "-o", exe, src).CombinedOutput(); err != nil {
+			t.Fatalf("go build: %v\n%s", err, out)
+		}
+		out, err := exec.Command(exe).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"vmt/analysis"
+	"vmt/diag"
+)
+
+func graph(args []string) error {
+	var o options
+	fs := newFlagSet("graph", "{-cfg Func.name | -calls} [flags] {vm file, directory, glob pattern or -} ...")
+	o.register(fs)
+	cfg := fs.String("cfg", "", "function to draw the control flow graph of")
+	calls := fs.Bool("calls", false, "draw the call graph of the program")
+	format := fs.String("format", "dot", "format of the call graph: dot or json")
+	out := fs.String("o", stdio, `output file, "-" for standard output`)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if (*cfg == "") == !*calls {
+		return usageErrorf("one of -cfg and -calls is required")
+	}
+	if *format != "dot" && *format != "json" {
+		return usageErrorf("unknown format %q, want dot or json", *format)
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		return err
+	}
+	b := bytes.NewBufferString("")
+	if *calls {
+		cg := analysis.Calls(files)
+		if entry := o.bootstrapEntry(); entry != "" {
+			warn(stackWarnings(cg, entry))
+		}
+		if *format == "json" {
+			err = cg.WriteJSON(b)
+		} else {
+			err = cg.WriteDOT(b)
+		}
+	} else {
+		g := analysis.Find(files, *cfg)
+		if g == nil {
+			return fmt.Errorf("function %s is not defined", *cfg)
+		}
+		err = g.WriteDOT(b)
+	}
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, b)
+}
+
+// stackWarnings warns when the stack of a program started at entry may grow
+// into the heap or the screen.
+func stackWarnings(cg *analysis.CallGraph, entry string) diag.List {
+	top := cg.Top(entry)
+	switch {
+	case top > analysis.ScreenBase:
+		return diag.List{diag.Warningf("stack-overflow", "the stack may grow to %d, into the screen at %d", top, analysis.ScreenBase)}
+	case top > analysis.HeapBase:
+		return diag.List{diag.Warningf("stack-overflow", "the stack may grow to %d, into the heap at %d", top, analysis.HeapBase)}
+	}
+	return nil
+}
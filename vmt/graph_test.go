@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_graph(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Main.vm": "function Main.main 0\nlabel L\npush constant 1\nif-goto L\npush constant 0\nreturn\n",
+	})
+	out := filepath.Join(dir, "main.dot")
+	if err := graph([]string{"-cfg", "Main.main", "-o", out, dir}); err != nil {
+		t.Fatal(err)
+	}
+	dot, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`digraph "Main.main"`, `b0 -> b1 [label="false"];`, `b0 -> b0 [label="true"];`} {
+		if !strings.Contains(string(dot), s) {
+			t.Errorf("graph wrote\n%s\nwithout %s", dot, s)
+		}
+	}
+
+	if err := graph([]string{"-cfg", "Main.other", dir}); err == nil {
+		t.Error("graph() of an undefined function succeeded")
+	}
+	if err := graph([]string{dir}); !errors.Is(err, errUsage) {
+		t.Errorf("graph() without -cfg = %v, want a usage error", err)
+	}
+}
+
+func Test_graph_calls(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm":  "function Sys.init 0\ncall Main.main 0\nlabel END\ngoto END\n",
+		"Main.vm": "function Main.main 1800\npush constant 0\nreturn\n",
+	})
+	out := filepath.Join(dir, "calls.json")
+	report(ioutil.Discard, nil)
+	if err := graph([]string{"-calls", "-format", "json", "-o", out, dir}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"name": "Main.main"`) {
+		t.Errorf("graph wrote\n%s", b)
+	}
+
+	// 256, the frames of Sys.init and Main.main, 1800 locals and 1 value
+	w := bytes.NewBufferString("")
+	report(w, nil)
+	if !strings.Contains(w.String(), "the stack may grow to 2067, into the heap at 2048 [stack-overflow]") {
+		t.Errorf("graph warned %q", w)
+	}
+
+	// without bootstrap code the program does not start at Sys.init
+	if err := graph([]string{"-calls", "-bootstrap=false", "-o", out, dir}); err != nil {
+		t.Fatal(err)
+	}
+	w.Reset()
+	report(w, nil)
+	if w.Len() > 0 {
+		t.Errorf("graph -bootstrap=false warned %q", w)
+	}
+
+	if err := graph([]string{"-calls", "-cfg", "Main.main", dir}); !errors.Is(err, errUsage) {
+		t.Errorf("graph() with -calls and -cfg = %v, want a usage error", err)
+	}
+	if err := graph([]string{"-calls", "-format", "xml", dir}); !errors.Is(err, errUsage) {
+		t.Errorf("graph() with -format xml = %v, want a usage error", err)
+	}
+}
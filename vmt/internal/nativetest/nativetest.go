@@ -0,0 +1,68 @@
+/*
+Package nativetest is for the tests of the backends that translate VM code
+into native programs, such as cgen, which check them against the Hack code
+of the codewriter package on the CPU emulator.
+
+The programs of those backends print the RAM words that are not 0 when they
+halt, a line RAM[address]<tab>value each; Dump prints the RAM the emulator
+leaves behind the same way.
+*/
+package nativetest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"vmt/assembler"
+	"vmt/codewriter"
+	"vmt/emulator"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+// Dump runs the Hack code of files on the emulator and returns the RAM words
+// that are not 0.
+func Dump(files []parser.File, opt codewriter.Options) (string, error) {
+	asm := bytes.NewBufferString("")
+	if _, err := codewriter.Translate(asm, files, opt, 1); err != nil {
+		return "", err
+	}
+	code, err := assembler.Assemble(asm)
+	if err != nil {
+		return "", err
+	}
+	cpu := emulator.New(code)
+	if err := cpu.Run(10000000); err != nil {
+		return "", err
+	}
+	if !cpu.Halted {
+		return "", fmt.Errorf("emulator did not halt")
+	}
+	var b bytes.Buffer
+	for a, w := range cpu.RAM {
+		if w != 0 {
+			fmt.Fprintf(&b, "RAM[%d]\t%d\n", a, int16(w))
+		}
+	}
+	return b.String(), nil
+}
+
+// Generated translates the programs of generator seeds 0 to seeds-1, with
+// the default options and with Optimize 1, and reports those whose output
+// of run differs from Dump. run builds and runs the native program.
+func Generated(t *testing.T, seeds int64, run func(files []parser.File, opt codewriter.Options) []byte) {
+	t.Helper()
+	for seed := int64(0); seed < seeds; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		for _, opt := range []codewriter.Options{codewriter.DefaultOptions, {Bootstrap: true, Optimize: 1}} {
+			out := run(files, opt)
+			want, err := Dump(files, opt)
+			if err != nil {
+				t.Fatalf("seed %d: %v", seed, err)
+			}
+			if string(out) != want {
+				t.Errorf("seed %d, %+v: RAM differs from the emulator", seed, opt)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"vmt/assembler"
+	"vmt/diag"
+	"vmt/lift"
+	"vmt/parser"
+)
+
+func decompile(args []string) error {
+	fs := newFlagSet("lift", "[flags] {asm, hack or bin file}")
+	format := fs.String("format", "", `format of the input: asm, hack or bin (default from the extension of the file)`)
+	out := fs.String("o", "lifted", `directory to write the lifted .vm files to, "-" to print them to standard output`)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return usageErrorf("lift takes one input file")
+	}
+	input := fs.Arg(0)
+	ext := filepath.Ext(input)
+	if *format == "" {
+		*format = strings.TrimPrefix(ext, ".")
+	}
+	// the file names the program when the code has no symbols
+	name := strings.TrimSuffix(filepath.Base(input), ext)
+
+	var p *lift.Program
+	var err error
+	switch *format {
+	case "asm":
+		var f *os.File
+		if f, err = os.Open(input); err != nil {
+			return err
+		}
+		defer f.Close()
+		p, err = lift.Asm(f, name)
+		var ae *assembler.Error
+		if errors.As(err, &ae) {
+			return diag.Errorf("assembly", "%s", ae.Msg).At(input, ae.Line, 0)
+		}
+	case "hack", "bin":
+		var code []uint16
+		if code, err = readCode(input, *format); err != nil {
+			return err
+		}
+		p, err = lift.Code(code, name)
+	default:
+		return usageErrorf("unknown format %q, want asm, hack or bin", *format)
+	}
+	var le *lift.Error
+	if errors.As(err, &le) {
+		return diag.Errorf("not-liftable", "%s", le).At(input, 0, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *out == stdio {
+		err = writeOutput(stdio, concat(p.Files))
+	} else {
+		err = writeProgram(*out, p.Files)
+	}
+	if err != nil {
+		return err
+	}
+	commands := 0
+	for _, f := range p.Files {
+		commands += len(f.Commands)
+	}
+	if *out != stdio {
+		log.Printf("lifted %d files, %d commands: %s", len(p.Files), commands, *out)
+	}
+	switch p.Entry {
+	case "":
+		log.Printf("no bootstrap code: translate with -bootstrap=false")
+	case "Sys.init":
+	default:
+		log.Printf("the bootstrap code calls %s: translate with -entry %s", p.Entry, p.Entry)
+	}
+	return nil
+}
+
+// concat returns files one after the other, each headed by a comment with
+// its file name.
+func concat(files []parser.File) *bytes.Buffer {
+	b := bytes.NewBufferString("")
+	for i, f := range files {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(b, "// %s.vm\n", f.Name)
+		f.WriteTo(b)
+	}
+	return b
+}
@@ -0,0 +1,416 @@
+/*
+Package lift reconstructs VM programs from the Hack code the translator
+generated for them.
+
+The code of every VM command is recognized by its template, see
+disasm.Recognize, and the program is rebuilt around the functions that are
+called and the labels that are jumped to. Assembly keeps the names of
+functions, labels, files and static variables in its symbols; machine code
+loses them, and they are made up.
+
+Translating a lifted program again gives the same machine code, and for
+assembly the same instructions and symbols; only comments may differ. The
+program itself may differ from the original where the code does not tell:
+a function whose first commands push 0 is lifted with those values as
+locals, and functions that are never called become part of the function
+before them.
+*/
+package lift
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"vmt/assembler"
+	"vmt/disasm"
+	"vmt/parser"
+)
+
+// Program is a lifted VM program.
+type Program struct {
+	Files []parser.File // in the order they were translated
+	// Entry is the function the bootstrap code calls, "" when there is no
+	// bootstrap code.
+	Entry string
+}
+
+// Error is code that cannot be lifted.
+type Error struct {
+	Addr int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ROM[%d]: %s", e.Addr, e.Msg)
+}
+
+/*
+Asm lifts translator-generated assembly read from r. Functions, labels and
+static variables get the names of their symbols, and files the names of the
+generated labels and static variables in their code, or else the class of
+their functions. Code that tells neither is put in the file name.
+*/
+func Asm(r io.Reader, name string) (*Program, error) {
+	p, err := assembler.AssembleProgram(r)
+	if err != nil {
+		return nil, err
+	}
+	l := &lifter{
+		code:      p.Code,
+		name:      name,
+		named:     true,
+		refs:      p.Refs,
+		generated: map[int][]string{},
+		variables: map[int]string{},
+	}
+	for _, s := range p.Labels {
+		a := int(s.Address)
+		switch {
+		case strings.Contains(s.Name, "$ret$") || strings.Contains(s.Name, "$cmp$"):
+			l.generated[a] = append(l.generated[a], s.Name)
+		case strings.Contains(s.Name, "$"):
+			l.addEvent(a, event{name: s.Name})
+		default:
+			l.addEvent(a, event{name: s.Name, function: true})
+		}
+	}
+	for _, s := range p.Variables {
+		l.variables[int(s.Address)] = s.Name
+	}
+	return l.lift()
+}
+
+/*
+Code lifts machine code. Everything is put in one file called name, so that
+the static variables keep their RAM addresses as static 0, 1 and so on. The
+function the bootstrap code calls is named Sys.init, the others name.f123
+after their ROM address, and labels L123.
+*/
+func Code(code []uint16, name string) (*Program, error) {
+	l := &lifter{code: code, name: name}
+	return l.lift()
+}
+
+// event is a function or label that starts at an address.
+type event struct {
+	name     string // symbol, with the scope of labels
+	function bool
+}
+
+type lifter struct {
+	code  []uint16
+	name  string
+	named bool     // functions and labels have their names from symbols
+	refs  []string // symbols of the A-instructions, when named
+
+	events    map[int][]event  // in the order they are defined
+	generated map[int][]string // labels of calls and comparisons
+	variables map[int]string   // static variables by RAM address
+
+	matches []disasm.Match
+	entry   int // ROM address of the entry function, or -1
+}
+
+func (l *lifter) addEvent(a int, e event) {
+	if l.events == nil {
+		l.events = map[int][]event{}
+	}
+	for _, old := range l.events[a] {
+		if old == e {
+			return
+		}
+	}
+	l.events[a] = append(l.events[a], e)
+}
+
+func (l *lifter) lift() (*Program, error) {
+	ms := disasm.Recognize(l.code)
+	end := 0
+	for _, m := range ms {
+		if m.Start != end {
+			break
+		}
+		end = m.End
+	}
+	if end != len(l.code) {
+		return nil, &Error{end, "code that no template of the translator generates, such as optimized code"}
+	}
+	l.entry = -1
+	var bootstrap disasm.Match
+	if len(ms) > 0 && ms[0].Bootstrap {
+		bootstrap = ms[0]
+		l.entry = bootstrap.Target
+		ms = ms[1:]
+	}
+	l.matches = ms
+
+	starts := map[int]bool{len(l.code): true}
+	for _, m := range ms {
+		if m.Bootstrap {
+			return nil, &Error{m.Start, "bootstrap code after the start"}
+		}
+		starts[m.Start] = true
+	}
+	if !l.named {
+		if l.entry >= 0 {
+			l.addEvent(l.entry, event{name: l.function(l.entry), function: true})
+		}
+		for _, m := range ms {
+			switch m.Command.Type {
+			case parser.CALL:
+				l.addEvent(m.Target, event{name: l.function(m.Target), function: true})
+			case parser.GOTO, parser.IF:
+				l.addEvent(m.Target, event{name: fmt.Sprintf("L%d", m.Target)})
+			}
+		}
+		// functions come before the labels at their start
+		for _, evs := range l.events {
+			sort.SliceStable(evs, func(i, j int) bool { return evs[i].function && !evs[j].function })
+		}
+	}
+	for a, evs := range l.events {
+		if !starts[a] {
+			return nil, &Error{a, fmt.Sprintf("%s is inside the code of a command", evs[0].name)}
+		}
+	}
+
+	prog := &Program{}
+	if l.entry >= 0 {
+		name, err := l.callee(bootstrap)
+		if err != nil {
+			return nil, err
+		}
+		prog.Entry = name
+	}
+	if err := l.commands(prog); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// function returns the made-up name of the function at address a.
+func (l *lifter) function(a int) string {
+	if a == l.entry {
+		return "Sys.init"
+	}
+	return fmt.Sprintf("%s.f%d", l.name, a)
+}
+
+// callee returns the name of the function m calls: the symbol m calls it by
+// or, without symbols, the one function at its address.
+func (l *lifter) callee(m disasm.Match) (string, error) {
+	a := m.Target
+	sym := l.ref(m)
+	for _, e := range l.events[a] {
+		if e.function && (!l.named || e.name == sym) {
+			return e.name, nil
+		}
+	}
+	return "", &Error{a, "called, but no function starts here"}
+}
+
+// ref returns the symbol of the A-instruction in m that loads its target,
+// or "". The return address of a call may be the same, but its symbol is a
+// generated label.
+func (l *lifter) ref(m disasm.Match) string {
+	if !l.named {
+		return ""
+	}
+	for a := m.Start; a < m.End; a++ {
+		sym := l.refs[a]
+		if l.code[a] == uint16(m.Target) && sym != "" && !strings.Contains(sym, "$ret$") {
+			return sym
+		}
+	}
+	return ""
+}
+
+// commands adds the commands to the files of prog.
+func (l *lifter) commands(prog *Program) error {
+	file := -1 // in prog.Files
+	function := ""
+	locals := -1 // command of the function whose locals are being counted
+
+	add := func(c parser.Command) {
+		f := &prog.Files[file]
+		c.Line = len(f.Commands) + 1
+		f.Commands = append(f.Commands, c)
+	}
+	cur := func() string {
+		if file < 0 {
+			return ""
+		}
+		return prog.Files[file].Name
+	}
+	enter := func(name string) {
+		if file < 0 || prog.Files[file].Name != name {
+			prog.Files = append(prog.Files, parser.File{Name: name})
+			file = len(prog.Files) - 1
+		}
+	}
+	at := func(a int, next int) {
+		for _, e := range l.events[a] {
+			if e.function {
+				enter(l.fileOf(a, e.name, next, cur()))
+				function = e.name
+				add(parser.Command{Type: parser.FUNCTION, Arg1: e.name})
+				locals = len(prog.Files[file].Commands) - 1
+				continue
+			}
+			if file < 0 {
+				enter(l.fileOf(a, "", next, cur()))
+			}
+			add(parser.Command{Type: parser.LABEL, Arg1: e.name[strings.LastIndex(e.name, "$")+1:]})
+			locals = -1
+		}
+	}
+
+	for i, m := range l.matches {
+		at(m.Start, i)
+		if file < 0 {
+			enter(l.fileOf(m.Start, "", i, cur()))
+		}
+		c := m.Command
+		if locals >= 0 && c.Type == parser.PUSH && c.Arg1 == "constant" && c.Arg2 == 0 {
+			prog.Files[file].Commands[locals].Arg2++
+			continue
+		}
+		locals = -1
+
+		switch c.Type {
+		case parser.CALL:
+			name, err := l.callee(m)
+			if err != nil {
+				return err
+			}
+			c.Arg1 = name
+		case parser.GOTO, parser.IF:
+			label, err := l.label(m, function, prog.Files[file].Name)
+			if err != nil {
+				return err
+			}
+			c.Arg1 = label
+		case parser.PUSH, parser.POP:
+			if c.Arg1 != "static" {
+				break
+			}
+			index, err := l.static(c.Arg2, prog.Files[file].Name)
+			if err != nil {
+				return &Error{m.Start, err.Error()}
+			}
+			c.Arg2 = index
+		}
+		add(c)
+	}
+	at(len(l.code), len(l.matches))
+	return nil
+}
+
+// label returns the name of the label m jumps to, which must be in the
+// scope of function or, outside of functions, file.
+func (l *lifter) label(m disasm.Match, function, file string) (string, error) {
+	scope := function
+	if scope == "" {
+		scope = file
+	}
+	a := m.Target
+	sym := l.ref(m)
+	for _, e := range l.events[a] {
+		i := strings.LastIndex(e.name, "$")
+		if !e.function && (!l.named || e.name == sym && e.name[:i] == scope) {
+			return e.name[i+1:], nil
+		}
+	}
+	return "", &Error{a, fmt.Sprintf("jumped to, but no label of %s is here", scope)}
+}
+
+// static returns the index of the static variable at RAM address a in file.
+func (l *lifter) static(a int, file string) (int, error) {
+	if !l.named {
+		return a - 16, nil
+	}
+	sym := l.variables[a]
+	i := strings.LastIndex(sym, ".")
+	n, err := strconv.Atoi(sym[i+1:])
+	if i < 0 || err != nil || sym[:i] != file {
+		return 0, fmt.Errorf("RAM %d is %q, not a static variable of %s", a, sym, file)
+	}
+	return n, nil
+}
+
+/*
+fileOf returns the file of the code at address a, which starts function, or
+no function for "", and runs from the match next to the next function. cur
+is the file of the code before.
+
+The labels the translator generates for calls and comparisons and the
+static variables are named after their file, and so are labels outside of
+functions; the first of them in the code tells. Without them the class of
+the function does, and else the file stays the same.
+*/
+func (l *lifter) fileOf(a int, function string, next int, cur string) string {
+	if !l.named {
+		return l.name
+	}
+	for _, m := range l.matches[next:] {
+		if m.Start > a && l.startsFunction(m.Start) {
+			break
+		}
+		if f := l.evidence(m); f != "" {
+			return f
+		}
+		if function != "" {
+			continue
+		}
+		for _, e := range l.events[m.Start] {
+			if i := strings.LastIndex(e.name, "$"); !e.function && i > 0 {
+				return e.name[:i]
+			}
+		}
+	}
+	if i := strings.LastIndex(function, "."); i > 0 {
+		return function[:i]
+	}
+	if cur != "" {
+		return cur
+	}
+	return l.name
+}
+
+func (l *lifter) startsFunction(a int) bool {
+	for _, e := range l.events[a] {
+		if e.function {
+			return true
+		}
+	}
+	return false
+}
+
+// evidence returns the file m was translated in, as the names of its
+// generated labels and static variables tell, or "".
+func (l *lifter) evidence(m disasm.Match) string {
+	switch c := m.Command; {
+	case c.Type == parser.CALL:
+		for _, g := range l.generated[m.End] {
+			if i := strings.Index(g, "$ret$"); i > 0 {
+				return g[:i]
+			}
+		}
+	case c.Type == parser.ARITHMETIC:
+		for a := m.Start; a < m.End; a++ {
+			for _, g := range l.generated[a] {
+				if i := strings.Index(g, "$cmp$"); i > 0 {
+					return g[:i]
+				}
+			}
+		}
+	case c.Arg1 == "static":
+		sym := l.variables[c.Arg2]
+		if i := strings.LastIndex(sym, "."); i > 0 {
+			return sym[:i]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package lift
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/assembler"
+	"vmt/codewriter"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+// translate returns the assembly of files.
+func translate(t *testing.T, files []parser.File, opt codewriter.Options) string {
+	t.Helper()
+	b := bytes.NewBufferString("")
+	if _, err := codewriter.Translate(b, files, opt, 1); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func parse(t *testing.T, name, src string) parser.File {
+	t.Helper()
+	cmds, err := parser.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parser.File{Name: name, Commands: cmds}
+}
+
+// source returns the files as VM source, one string per file.
+func source(files []parser.File) map[string]string {
+	out := map[string]string{}
+	for _, f := range files {
+		b := bytes.NewBufferString("")
+		f.WriteTo(b)
+		out[f.Name] = b.String()
+	}
+	return out
+}
+
+func TestAsm(t *testing.T) {
+	files := []parser.File{
+		parse(t, "Sys", "function Sys.init 0\npush constant 3\ncall Main.twice 1\npop static 0\nlabel END\ngoto END\n"),
+		parse(t, "Main", "function Main.twice 2\npush argument 0\npush argument 0\nadd\npush constant 0\neq\nif-goto ZERO\npush argument 0\nreturn\nlabel ZERO\npush static 1\nreturn\n"),
+	}
+	asm := translate(t, files, codewriter.DefaultOptions)
+	p, err := Asm(strings.NewReader(asm), "Prog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entry != "Sys.init" {
+		t.Errorf("Entry = %q, want Sys.init", p.Entry)
+	}
+	want := source(files)
+	if got := source(p.Files); !reflect.DeepEqual(got, want) {
+		t.Errorf("Asm() =\n%v\nwant\n%v", got, want)
+	}
+
+	code, err := assembler.Assemble(strings.NewReader(asm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = Code(code, "Prog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{"Prog": `function Sys.init 0
+push constant 3
+call Prog.f118 1
+pop static 0
+label L116
+goto L116
+function Prog.f118 2
+push argument 0
+push argument 0
+add
+push constant 0
+eq
+if-goto L267
+push argument 0
+return
+label L267
+push static 1
+return
+`}
+	if got := source(p.Files); !reflect.DeepEqual(got, want) {
+		t.Errorf("Code() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+// instructions returns the labels and instructions of asm, without comments.
+func instructions(asm string) string {
+	var out []string
+	for _, l := range strings.Split(asm, "\n") {
+		if i := strings.Index(l, "//"); i >= 0 {
+			l = l[:i]
+		}
+		if l = strings.TrimSpace(l); l != "" {
+			out = append(out, l)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// Lifting translated code and translating it again must give the same
+// instructions and symbols, or without the symbols the same machine code.
+func TestAsm_generated(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		files := generator.Generate(seed, generator.DefaultConfig)
+		for _, opt := range []codewriter.Options{
+			codewriter.DefaultOptions,
+			{Bootstrap: true, Mode: codewriter.Compact},
+			{Mode: codewriter.Annotated},
+		} {
+			asm := translate(t, files, opt)
+			p, err := Asm(strings.NewReader(asm), "Main")
+			if err != nil {
+				t.Fatalf("seed %d, %+v: Asm() error = %v", seed, opt, err)
+			}
+			again := opt
+			again.Entry = p.Entry
+			if got := translate(t, p.Files, again); instructions(got) != instructions(asm) {
+				t.Errorf("seed %d, %+v: the lifted program translates differently", seed, opt)
+			}
+
+			code, err := assembler.Assemble(strings.NewReader(asm))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err = Code(code, "Main")
+			if err != nil {
+				t.Fatalf("seed %d, %+v: Code() error = %v", seed, opt, err)
+			}
+			again.Entry = p.Entry
+			got, err := assembler.Assemble(strings.NewReader(translate(t, p.Files, again)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, code) {
+				t.Errorf("seed %d, %+v: the lifted machine code translates differently", seed, opt)
+			}
+		}
+	}
+}
+
+func TestCode_errors(t *testing.T) {
+	files := generator.Generate(1, generator.DefaultConfig)
+	asm := translate(t, files, codewriter.Options{Bootstrap: true, Optimize: 1})
+	code, err := assembler.Assemble(strings.NewReader(asm))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if _, err := Code(code, "Main"); !errors.As(err, &e) {
+		t.Errorf("Code() of optimized code error = %v, want an Error", err)
+	}
+	// a jump into the middle of push constant 1
+	if _, err := Code([]uint16{1, 0xEC10, 0, 0xFC20, 0xE308, 0, 0xFDC8, 2, 0xEA87}, "Main"); !errors.As(err, &e) || e.Addr != 2 {
+		t.Errorf("Code() error = %v, want an Error at ROM[2]", err)
+	}
+}
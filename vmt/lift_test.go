@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+func Test_decompile(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm":  "function Sys.init 0\npush constant 3\ncall Main.double 1\nlabel END\ngoto END\n",
+		"Main.vm": "function Main.double 0\npush argument 0\npush argument 0\nadd\nreturn\n",
+		// D=RAM[0], which no template of the translator generates
+		"Load.hack": "0000000000000000\n1111110000010000\n",
+	})
+	defer os.RemoveAll(dir)
+	asm := filepath.Join(dir, "Prog.asm")
+	if err := translate([]string{"-o", asm, dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := assemble([]string{"-o", filepath.Join(dir, "Prog.hack"), asm}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		input string
+		want  map[string]string
+	}{
+		{"Prog.asm", map[string]string{
+			"Sys.vm":  "call Main.double 1\nlabel END\ngoto END\n",
+			"Main.vm": "function Main.double 0\n",
+		}},
+		{"Prog.hack", map[string]string{
+			"Prog.vm": "call Prog.f",
+		}},
+	} {
+		out := filepath.Join(dir, "lifted-"+tt.input)
+		if err := decompile([]string{"-o", out, filepath.Join(dir, tt.input)}); err != nil {
+			t.Fatalf("lift %s: %v", tt.input, err)
+		}
+		for file, s := range tt.want {
+			vm, err := ioutil.ReadFile(filepath.Join(out, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(vm), s) {
+				t.Errorf("lift %s wrote %s\n%s\nwithout %q", tt.input, file, vm, s)
+			}
+		}
+	}
+
+	err := decompile([]string{"-o", filepath.Join(dir, "load"), filepath.Join(dir, "Load.hack")})
+	if l := diag.From(err); len(l) != 1 || l[0].Rule != "not-liftable" {
+		t.Errorf("lift Load.hack = %v, want a not-liftable error", err)
+	}
+	if err := decompile([]string{"-format", "ihex", asm}); !errors.Is(err, errUsage) {
+		t.Errorf("lift -format ihex = %v, want a usage error", err)
+	}
+}
+
+func Test_concat(t *testing.T) {
+	files := []parser.File{
+		{Name: "Sys", Commands: []parser.Command{{Type: parser.FUNCTION, Arg1: "Sys.init"}}},
+		{Name: "Main", Commands: []parser.Command{{Type: parser.RETURN}}},
+	}
+	want := "// Sys.vm\nfunction Sys.init 0\n\n// Main.vm\nreturn\n"
+	if got := concat(files).String(); got != want {
+		t.Errorf("concat() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"vmt/diag"
+	"vmt/lint"
+)
+
+func vmlint(args []string) error {
+	var o options
+	fs := newFlagSet("lint", "[flags] {vm file, directory or glob pattern} ...")
+	o.registerInputs(fs)
+	enable := fs.String("enable", "", "comma-separated rules to check even if the manifest turns them off")
+	disable := fs.String("disable", "", "comma-separated rules not to check")
+	list := fs.Bool("rules", false, "list the rules and exit")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	// for the SARIF output of the problems the rules find
+	for _, r := range lint.Rules {
+		diag.Rules[r.Name] = r.Doc
+	}
+	if *list {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, r := range lint.Rules {
+			fmt.Fprintf(tw, "%s\t%s\n", r.Name, r.Doc)
+		}
+		return tw.Flush()
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+
+	// the manifest first, then the flags
+	config := lint.Config{}
+	for name, on := range o.lint {
+		config[name] = on
+	}
+	for _, f := range []struct {
+		rules string
+		on    bool
+	}{{*enable, true}, {*disable, false}} {
+		for _, name := range strings.Split(f.rules, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config[name] = f.on
+			}
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	paths, err := expand(inputs)
+	if err != nil {
+		return err
+	}
+	var l diag.List
+	for _, p := range paths {
+		problems, err := lintFile(p, o.name, config)
+		var errs diag.List
+		if errors.As(err, &errs) {
+			l = append(l, errs...)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		l = append(l, problems...)
+	}
+	return l.Err()
+}
+
+// lintFile checks the file p, or standard input for "-" as the file name.
+func lintFile(p, name string, config lint.Config) (diag.List, error) {
+	var src []byte
+	var err error
+	if p == stdio {
+		src, err = ioutil.ReadAll(os.Stdin)
+		p = name + ".vm"
+	} else {
+		src, err = ioutil.ReadFile(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l, err := lint.Source(p, src, config)
+	var errs diag.List
+	if errors.As(err, &errs) {
+		for _, d := range errs {
+			d.File = p
+		}
+	}
+	return l, err
+}
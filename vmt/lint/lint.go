@@ -0,0 +1,167 @@
+/*
+Package lint finds VM code that translates but is probably wrong.
+
+Every rule has a name and can be switched off, per project in the "lint"
+object of vmt.json or for single lines with a comment:
+
+	goto END
+	push constant 0 // vmt:ignore unreachable
+
+A "vmt:ignore" comment on a line of its own applies to the next command.
+Without rule names it silences every rule. Problems are reported as
+warnings.
+*/
+package lint
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+// Rule is a check of a single file.
+type Rule struct {
+	Name string
+	Doc  string
+
+	check func(f parser.File, report reportFunc)
+}
+
+// reportFunc reports a problem at command c.
+type reportFunc func(c parser.Command, format string, args ...interface{})
+
+// Rules are all rules, in the order they are listed.
+var Rules = []*Rule{
+	{
+		Name:  "unreachable",
+		Doc:   "code after goto or return that no label jumped to makes reachable",
+		check: unreachable,
+	},
+	{
+		Name:  "unused-label",
+		Doc:   "a label no goto or if-goto jumps to",
+		check: unusedLabel,
+	},
+	{
+		Name:  "missing-return",
+		Doc:   "a function that can run past its last command without return",
+		check: missingReturn,
+	},
+	{
+		Name:  "unused-pointer",
+		Doc:   "pop pointer whose THIS or THAT is never used",
+		check: unusedPointer,
+	},
+	{
+		Name:  "function-name",
+		Doc:   "a function that is not named File.name after its file",
+		check: functionName,
+	},
+}
+
+// Config switches rules on and off by name. Rules it does not name are on.
+type Config map[string]bool
+
+// Validate reports rules in c that do not exist.
+func (c Config) Validate() error {
+	var unknown []string
+	for name := range c {
+		if Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown lint rules: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// Enabled reports whether the rule name is on.
+func (c Config) Enabled(name string) bool {
+	on, ok := c[name]
+	return !ok || on
+}
+
+// Lookup returns the rule name, or nil.
+func Lookup(name string) *Rule {
+	for _, r := range Rules {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Source checks the VM source src of the file path with the rules c
+// enables. Syntax errors are returned as the error.
+func Source(path string, src []byte, c Config) (diag.List, error) {
+	lines, err := parser.ParseLines(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	f := parser.File{Name: strings.TrimSuffix(filepath.Base(path), ".vm"), Path: path}
+	for _, l := range lines {
+		if l.Command != nil {
+			f.Commands = append(f.Commands, *l.Command)
+		}
+	}
+	ignored := ignores(lines)
+
+	var l diag.List
+	for _, r := range Rules {
+		if !c.Enabled(r.Name) {
+			continue
+		}
+		r.check(f, func(cmd parser.Command, format string, args ...interface{}) {
+			if rules, ok := ignored[cmd.Line]; ok && (len(rules) == 0 || rules[r.Name]) {
+				return
+			}
+			l = append(l, diag.Warningf(r.Name, format, args...).At(path, cmd.Line, 0))
+		})
+	}
+	l.Sort()
+	return l, nil
+}
+
+// ignores returns the rules that vmt:ignore comments silence by line. An
+// empty set silences all.
+func ignores(lines []parser.Line) map[int]map[string]bool {
+	ignored := map[int]map[string]bool{}
+	var pending map[string]bool
+	for _, l := range lines {
+		rules, ok := directive(l)
+		switch {
+		case l.Command != nil && ok:
+			ignored[l.Command.Line] = rules
+			pending = nil
+		case l.Command != nil && pending != nil:
+			ignored[l.Command.Line] = pending
+			pending = nil
+		case ok:
+			pending = rules
+		}
+	}
+	return ignored
+}
+
+// directive returns the rules of a vmt:ignore comment on l.
+func directive(l parser.Line) (map[string]bool, bool) {
+	text := strings.TrimSpace(l.Comment)
+	if !l.HasComment || !strings.HasPrefix(text, "vmt:ignore") {
+		return nil, false
+	}
+	rest := strings.TrimPrefix(text, "vmt:ignore")
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return nil, false
+	}
+	rules := map[string]bool{}
+	for _, name := range strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		rules[name] = true
+	}
+	return rules, true
+}
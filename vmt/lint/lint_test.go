@@ -0,0 +1,158 @@
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"vmt/diag"
+)
+
+// lines returns the lines of the problems in l as rule:line.
+func lines(l diag.List) []string {
+	var out []string
+	for _, d := range l {
+		out = append(out, fmt.Sprintf("%s:%d", d.Rule, d.Line))
+	}
+	return out
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			"clean",
+			"function Main.main 0\nlabel LOOP\npush constant 1\nif-goto LOOP\npush constant 0\nreturn\n",
+			nil,
+		},
+		{
+			"unreachable after goto",
+			"function Main.main 0\ngoto END\npush constant 1\nadd\nlabel END\npush constant 0\nreturn\n",
+			[]string{"unreachable:3"},
+		},
+		{
+			"unreachable after return, past an unused label",
+			"function Main.main 0\npush constant 0\nreturn\npop temp 0\nlabel X\npush constant 0\nreturn\n",
+			[]string{"unreachable:4", "unused-label:5"},
+		},
+		{
+			"missing return",
+			"function Main.main 0\npush constant 0\nif-goto X\nlabel X\n",
+			[]string{"missing-return:1"},
+		},
+		{
+			"infinite loop needs no return",
+			"function Main.main 0\nlabel END\ngoto END\n",
+			nil,
+		},
+		{
+			"code outside functions",
+			"push constant 1\nlabel X\n",
+			[]string{"unused-label:2"},
+		},
+		{
+			"labels are scoped by function",
+			"function Main.a 0\nlabel X\ngoto X\nfunction Main.b 0\nlabel X\npush constant 0\nreturn\n",
+			[]string{"unused-label:5"},
+		},
+		{
+			"unused pointer",
+			"function Main.main 0\npush argument 0\npop pointer 0\npush constant 0\nreturn\n",
+			[]string{"unused-pointer:3"},
+		},
+		{
+			"pointer set twice",
+			"function Main.main 0\npush argument 0\npop pointer 1\npush argument 1\npop pointer 1\npush that 0\nreturn\n",
+			[]string{"unused-pointer:3"},
+		},
+		{
+			"pointer used",
+			"function Main.main 0\npush argument 0\npop pointer 0\npush argument 1\npop pointer 1\npush pointer 0\npush that 0\nreturn\n",
+			nil,
+		},
+		{
+			"pointer passed to a call",
+			"function Main.main 0\npush argument 0\npop pointer 0\ncall Main.f 0\nreturn\n",
+			nil,
+		},
+		{
+			"function names",
+			"function Main 0\nreturn\nfunction Main. 0\nreturn\nfunction Other.f 0\nreturn\nfunction Main.f 0\nreturn\n",
+			[]string{"function-name:1", "function-name:3", "function-name:5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Source("dir/Main.vm", []byte(tt.src), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := lines(l); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Source() = %v, want %v", got, tt.want)
+			}
+			for _, d := range l {
+				if d.File != "dir/Main.vm" || d.Severity != diag.Warning {
+					t.Errorf("problem %v is not a warning in dir/Main.vm", d)
+				}
+			}
+		})
+	}
+}
+
+func TestSource_ignore(t *testing.T) {
+	src := `function Main 0 // vmt:ignore function-name
+goto END
+// vmt:ignore unreachable, unused-pointer
+pop pointer 0
+label L // vmt:ignore
+label M // vmt:ignored
+push constant 0
+return
+`
+	l, err := Source("Main.vm", []byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "vmt:ignored" is not a directive
+	if got, want := lines(l), []string{"unused-label:6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Source() = %v, want %v", got, want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	src := "function Other.f 0\nlabel X\n"
+	l, err := Source("Main.vm", []byte(src), Config{"unused-label": false, "missing-return": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lines(l), []string{"missing-return:1", "function-name:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Source() = %v, want %v", got, want)
+	}
+
+	if err := (Config{"unused-label": false}).Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if err := (Config{"unused-labels": false, "x": true}).Validate(); err == nil {
+		t.Error("Validate() accepted unknown rules")
+	}
+}
+
+func TestSource_error(t *testing.T) {
+	_, err := Source("Main.vm", []byte("push\n"), nil)
+	var l diag.List
+	if !errors.As(err, &l) {
+		t.Errorf("Source() error = %v, want a diag.List", err)
+	}
+}
+
+// vmt lint describes the rules next to those of diag.Rules.
+func TestRules_documented(t *testing.T) {
+	for _, r := range Rules {
+		if _, ok := diag.Rules[r.Name]; ok || r.Doc == "" {
+			t.Errorf("rule %s is not described or clashes with diag.Rules", r.Name)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package lint
+
+import (
+	"strings"
+	"vmt/parser"
+)
+
+// body is a function and its commands, or the commands before the first
+// function of a file, which have no function.
+type body struct {
+	function *parser.Command
+	commands []parser.Command
+}
+
+func bodies(f parser.File) []body {
+	var out []body
+	cur := body{}
+	for i, c := range f.Commands {
+		if c.Type == parser.FUNCTION {
+			if cur.function != nil || len(cur.commands) > 0 {
+				out = append(out, cur)
+			}
+			cur = body{function: &f.Commands[i]}
+			continue
+		}
+		cur.commands = append(cur.commands, c)
+	}
+	if cur.function != nil || len(cur.commands) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// targets returns the labels goto and if-goto jump to in b.
+func (b body) targets() map[string]bool {
+	t := map[string]bool{}
+	for _, c := range b.commands {
+		if c.Type == parser.GOTO || c.Type == parser.IF {
+			t[c.Arg1] = true
+		}
+	}
+	return t
+}
+
+// reachable calls visit for every command of b with whether control can
+// get there, and returns whether it can get past the last command. Labels
+// only make code reachable when something jumps to them.
+func (b body) reachable(visit func(c parser.Command, reachable bool)) bool {
+	targets := b.targets()
+	reachable := true
+	for _, c := range b.commands {
+		if c.Type == parser.LABEL && targets[c.Arg1] {
+			reachable = true
+		}
+		visit(c, reachable)
+		if c.Type == parser.GOTO || c.Type == parser.RETURN {
+			reachable = false
+		}
+	}
+	return reachable
+}
+
+func unreachable(f parser.File, report reportFunc) {
+	for _, b := range bodies(f) {
+		reported := false
+		b.reachable(func(c parser.Command, reachable bool) {
+			if reachable {
+				reported = false
+				return
+			}
+			// one report for every stretch of dead code
+			if !reported && c.Type != parser.LABEL {
+				report(c, "unreachable code: %s", c)
+				reported = true
+			}
+		})
+	}
+}
+
+func unusedLabel(f parser.File, report reportFunc) {
+	for _, b := range bodies(f) {
+		targets := b.targets()
+		for _, c := range b.commands {
+			if c.Type == parser.LABEL && !targets[c.Arg1] {
+				report(c, "label %s is never jumped to", c.Arg1)
+			}
+		}
+	}
+}
+
+func missingReturn(f parser.File, report reportFunc) {
+	for _, b := range bodies(f) {
+		if b.function == nil {
+			continue
+		}
+		if b.reachable(func(parser.Command, bool) {}) {
+			report(*b.function, "function %s can reach its end without return", b.function.Arg1)
+		}
+	}
+}
+
+func unusedPointer(f parser.File, report reportFunc) {
+	for _, b := range bodies(f) {
+		for i, c := range b.commands {
+			if c.Type != parser.POP || c.Arg1 != "pointer" || (c.Arg2 != 0 && c.Arg2 != 1) {
+				continue
+			}
+			register := []string{"THIS", "THAT"}[c.Arg2]
+			switch pointerUse(b.commands[i+1:], c.Arg2) {
+			case unused:
+				report(c, "%s is never used after %s", register, c)
+			case overwritten:
+				report(c, "%s is set again before it is used", register)
+			}
+		}
+	}
+}
+
+// use is what happens to a pointer after it is set.
+type use int
+
+const (
+	used use = iota
+	unused
+	overwritten
+)
+
+// pointerUse follows the commands after a pop into pointer to the first use
+// of THIS or THAT. Jumps and calls, which may lead to a use, end the search
+// as well.
+func pointerUse(commands []parser.Command, pointer int) use {
+	segment := []string{"this", "that"}[pointer]
+	for _, c := range commands {
+		switch {
+		case (c.Type == parser.PUSH || c.Type == parser.POP) && c.Arg1 == segment:
+			return used
+		case c.Type == parser.PUSH && c.Arg1 == "pointer" && c.Arg2 == pointer:
+			return used
+		case c.Type == parser.LABEL || c.Type == parser.GOTO || c.Type == parser.IF || c.Type == parser.CALL:
+			return used
+		case c.Type == parser.POP && c.Arg1 == "pointer" && c.Arg2 == pointer:
+			return overwritten
+		case c.Type == parser.RETURN:
+			return unused
+		}
+	}
+	return unused
+}
+
+func functionName(f parser.File, report reportFunc) {
+	for _, b := range bodies(f) {
+		if b.function == nil {
+			continue
+		}
+		name := b.function.Arg1
+		i := strings.Index(name, ".")
+		if i < 0 || name[:i] != f.Name || i == len(name)-1 {
+			report(*b.function, "function %s in %s.vm should be named %s.name", name, f.Name, f.Name)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"vmt/diag"
+)
+
+func Test_vmlint(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"vmt.json": `{"lint": {"unused-label": false, "function-name": false}}`,
+		"Main.vm":  "function Main.main 0\nlabel X\ngoto END\npush constant 1\nlabel END\n",
+	})
+	tests := []struct {
+		name  string
+		args  []string
+		rules []string
+	}{
+		{"manifest", []string{dir}, []string{"missing-return", "unreachable"}},
+		{"flags", []string{"-enable", "unused-label", "-disable", "unreachable,missing-return", dir}, []string{"unused-label"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := vmlint(tt.args)
+			var l diag.List
+			if !errors.As(err, &l) {
+				t.Fatalf("vmlint() = %v, want diagnostics", err)
+			}
+			var got []string
+			for _, d := range l {
+				got = append(got, d.Rule)
+			}
+			if len(got) != len(tt.rules) {
+				t.Fatalf("vmlint() = %v, want %v", got, tt.rules)
+			}
+			for i := range got {
+				if got[i] != tt.rules[i] {
+					t.Errorf("vmlint() = %v, want %v", got, tt.rules)
+				}
+			}
+		})
+	}
+
+	if err := vmlint([]string{"-disable", "nonsense", dir}); !errors.Is(err, errUsage) {
+		t.Errorf("vmlint() with an unknown rule = %v, want a usage error", err)
+	}
+}
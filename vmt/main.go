@@ -1,101 +1,99 @@
 package main
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"regexp"
-	"vmt/codewriter"
-	"vmt/parser"
 )
 
+// command is a vmt subcommand. run returns errUsage when its arguments are
+// invalid.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"translate", "translate VM code into Hack assembly", translate},
+	{"assemble", "assemble Hack assembly or VM code into Hack machine code", assemble},
+	{"run", "run a program on the Hack CPU emulator and print RAM", run},
+	{"test", "run a program and compare RAM with a nand2tetris .cmp file", test},
+	{"batch", "translate every program in a directory tree", batch},
+	{"stats", "print the size of every file and function", stats},
+	{"reduce", "shrink a failing VM program to a small repro", reduce},
+	{"fmt", "rewrite VM source in the canonical layout", vmfmt},
+	{"lint", "report suspicious VM code", vmlint},
+	{"graph", "print the control flow graph of a function or the call graph of the program", graph},
+	{"disasm", "turn Hack machine code back into Hack assembly", disassemble},
+	{"lift", "reconstruct the VM program of translated assembly or machine code", decompile},
+}
+
+var errUsage = errors.New("usage")
+
 func main() {
-	// parse args
-	flag.Parse()
-	flags := flag.Args()
-	if len(flags) == 0 {
-		log.Fatalln("Please specify the command argument for vm name")
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
 	}
-
-	// invalid args?
-	fInfo, err := os.Stat(flags[0])
-	if err != nil {
-		log.Fatalln(err.Error())
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			args = []string{args[1], "-h"}
+			break
+		}
+		usage(os.Stdout)
+		return
 	}
 
-	// generate asm
-	rep := regexp.MustCompile(`.vm$`)
-	bname := filepath.Base(rep.ReplaceAllString(flags[0], ""))
-	asm, err := os.Create(bname + ".asm")
-	if err != nil {
-		log.Fatalln(err.Error())
+	// "vmt {vm file or directory}" is short for "vmt translate ..."
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		cmd = findCommand("translate")
+	} else {
+		args = args[1:]
 	}
-	defer asm.Close()
-
-	// generate codewriter
-	cw := codewriter.New(asm)
 
-	// IsDir?
-	if !fInfo.IsDir() {
-		cw.SetFileName(bname)
-		translate(flags[0], cw)
-		log.Println("translated vm: " + bname + ".asm")
-		return
+	err := cmd.run(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitUsage)
 	}
+	os.Exit(report(os.Stderr, err))
+}
 
-	// multiple files in directory
-	fpath := flags[0] + "/*.vm"
-	files, err := filepath.Glob(fpath)
-	for _, f := range files {
-		pf := filepath.Base(rep.ReplaceAllString(f, ""))
-		cw.SetFileName(pf)
-		translate(f, cw)
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
 	}
-	log.Println("translated multiple vm: " + bname + ".asm")
+	return nil
 }
 
-func translate(vmn string, cw *codewriter.CodeWriter) {
-	// open vm
-	f, err := os.Open(vmn)
-	if err != nil {
-		log.Fatalln(err.Error())
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "vmt is Virtual Machine Translater for nand2tetris.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "usage: vmt <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.name, c.summary)
 	}
-	defer f.Close()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "vmt help <command>" for the flags of a command.`)
+}
 
-	// write assembley
-	p := parser.New(f)
-	for p.HasMoreCommands() {
-		p.Advance()
-		switch p.CommandType() {
-		case parser.ARITHMETIC:
-			cw.WriteArithmetic(p.Arg1())
-		case parser.PUSH, parser.POP:
-			index, err := p.Arg2()
-			if err != nil {
-				log.Fatalln(err.Error())
-			}
-			cw.WritePushPop(p.CommandType(), p.Arg1(), index)
-		case parser.LABEL:
-			cw.WriteLabel(p.Arg1())
-		case parser.IF:
-			cw.WriteIf(p.Arg1())
-		case parser.GOTO:
-			cw.WriteGoto(p.Arg1())
-		case parser.FUNCTION:
-			numlocal, err := p.Arg2()
-			if err != nil {
-				log.Fatalln(err.Error())
-			}
-			cw.WriteFunction(p.Arg1(), numlocal)
-		case parser.RETURN:
-			cw.WriteReturn()
-		case parser.CALL:
-			numargs, err := p.Arg2()
-			if err != nil {
-				log.Fatalln(err.Error())
-			}
-			cw.WriteCall(p.Arg1(), numargs)
-		}
+// newFlagSet returns a flag set for a subcommand whose usage prints synopsis
+// and the flags.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Var(&diagnostics, "diagnostics", "format of errors and warnings: text, json or sarif")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: vmt %s %s\n\nflags:\n", name, synopsis)
+		fs.PrintDefaults()
 	}
+	return fs
 }
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"vmt/analysis"
+	"vmt/assembler"
+	"vmt/cache"
+	"vmt/codewriter"
+	"vmt/diag"
+	"vmt/parser"
+	"vmt/project"
+	"vmt/statics"
+)
+
+// stdio is the file name for standard input and output.
+const stdio = "-"
+
+// options are the translation options shared by every subcommand that
+// translates VM code, so that they mean the same thing everywhere.
+type options struct {
+	bootstrap bool
+	optimize  int
+	asm       string
+	output    string
+	name      string
+	entry     string
+	manifest  string
+	libraries pathList
+	cacheDir  string
+	lint      map[string]bool
+	pkg       string // Go package of -target go
+
+	project   string        // directory of the manifest in use, if any
+	fragments fragmentCache // cache to use instead of cacheDir
+	reused    int           // files the last translate took from the cache
+}
+
+// fragmentCache is a cache of the code of single files.
+type fragmentCache interface {
+	codewriter.Cache
+	Stats() (hits, misses int)
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.bootstrap, "bootstrap", true, "write the bootstrap code that sets SP and calls the entry function")
+	fs.IntVar(&o.optimize, "O", 0, "optimization level: 0 or 1")
+	fs.StringVar(&o.asm, "asm", "", "form of the assembly: verbose (the default), compact without comments, or annotated with ROM addresses and VM commands")
+	o.registerInputs(fs)
+	fs.StringVar(&o.entry, "entry", project.DefaultEntry, "function the bootstrap code calls; its file is translated first")
+	fs.StringVar(&o.cacheDir, "cache", "off", `directory to keep the code of unchanged files in, such as ~/.cache/vmt; "off" translates every file`)
+	fs.Var(&o.libraries, "lib", "directory to search for Foo.vm when a function Foo.bar is called but not defined; repeatable")
+}
+
+// pathList is a flag that can be given more than once.
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, string(filepath.ListSeparator))
+}
+
+func (l *pathList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+/*
+inputs returns the inputs of the command line of fs after applying the
+project manifest.
+
+The manifest is the -manifest file, vmt.json in the only input directory,
+or vmt.json in the current directory when there are no inputs. Its inputs
+replace the directory it was found in, and its options apply unless they are
+given as flags. Library directories of the manifest are searched after those
+of -lib.
+*/
+func (o *options) inputs(fs *flag.FlagSet) ([]string, error) {
+	args := fs.Args()
+	path := o.manifest
+	if path == "" && len(args) == 1 {
+		if fInfo, err := os.Stat(args[0]); err == nil && fInfo.IsDir() {
+			path = project.Find(args[0])
+		}
+		if path != "" {
+			args = nil
+		}
+	}
+	if path == "" && len(args) == 0 {
+		path = project.Find(".")
+	}
+	if path == "" {
+		if len(args) == 0 {
+			fs.Usage()
+			return nil, errUsage
+		}
+		return args, nil
+	}
+
+	m, err := o.useManifest(path, setFlags(fs))
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		args = m.Inputs
+	}
+	return args, nil
+}
+
+// setFlags returns the names of the flags given on the command line of fs.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// useManifest loads the manifest at path and applies its options, except
+// those in set, which were given as flags.
+func (o *options) useManifest(path string, set map[string]bool) (*project.Manifest, error) {
+	m, err := project.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if m.Bootstrap != nil && !set["bootstrap"] {
+		o.bootstrap = *m.Bootstrap
+	}
+	if m.Optimize != nil && !set["O"] {
+		o.optimize = *m.Optimize
+	}
+	if m.Entry != "" && !set["entry"] {
+		o.entry = m.Entry
+	}
+	if m.Output != "" && !set["o"] {
+		o.output = m.Output
+	}
+	// copy, copies of o for other programs must not see the libraries
+	o.libraries = append(o.libraries[:len(o.libraries):len(o.libraries)], m.Libraries...)
+	o.lint = m.Lint
+	o.project = m.Dir
+	return m, nil
+}
+
+// bootstrapEntry returns the function the bootstrap code calls, or "" when
+// there is no bootstrap code.
+func (o *options) bootstrapEntry() string {
+	if !o.bootstrap {
+		return ""
+	}
+	if o.entry == "" {
+		return project.DefaultEntry
+	}
+	return o.entry
+}
+
+// registerInputs adds the flags that find the input files, for the
+// subcommands that only read them.
+func (o *options) registerInputs(fs *flag.FlagSet) {
+	fs.StringVar(&o.name, "name", "Main", `file name for VM code read from standard input ("-"), used for its statics and labels`)
+	fs.StringVar(&o.manifest, "manifest", "", "project manifest (default "+project.ManifestName+" in the input directory or, without inputs, the current directory)")
+}
+
+// registerOutput adds -o for the subcommands that write a file.
+func (o *options) registerOutput(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "o", "", `output file, "-" for standard output (default {name}.{ext} next to the input)`)
+}
+
+/*
+outputName returns the output file for inputs with extension ext.
+
+As nand2tetris specifies, Foo.vm is translated to Foo.asm in the same
+directory and the directory Foo to Foo/Foo.asm. A project is named after the
+directory of its manifest. Standard input goes to standard output. Several
+inputs need an explicit -o.
+*/
+func (o *options) outputName(inputs []string, ext string) (string, error) {
+	if o.output != "" {
+		return o.output, nil
+	}
+	if o.project != "" {
+		inputs = []string{o.project}
+	}
+	if len(inputs) != 1 {
+		return "", usageErrorf("-o is required with more than one input")
+	}
+	input := inputs[0]
+	if input == stdio {
+		return stdio, nil
+	}
+	if fInfo, err := os.Stat(input); err == nil && fInfo.IsDir() {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(input, filepath.Base(abs)+ext), nil
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + ext, nil
+}
+
+// translate checks the program and returns its assembly together with the
+// CodeWriter that wrote it, for the source and link maps. When the generated
+// code does not fit the Hack memory the CodeWriter is returned with the
+// error, so that the link map can show why. Files translated before with the
+// same options are taken from the cache.
+func (o *options) translate(files []parser.File) ([]byte, *codewriter.CodeWriter, error) {
+	if o.optimize < 0 || o.optimize > 1 {
+		return nil, nil, usageErrorf("unknown optimization level %d", o.optimize)
+	}
+	mode := codewriter.Verbose
+	if o.asm != "" {
+		m, err := codewriter.ParseMode(o.asm)
+		if err != nil {
+			return nil, nil, usageErrorf("-asm: %v", err)
+		}
+		mode = m
+	}
+	if err := o.check(files); err != nil {
+		return nil, nil, err
+	}
+	fragments := o.fragments
+	if fragments == nil && o.cacheDir != "" && o.cacheDir != "off" {
+		dir, err := cache.Open(o.cacheDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		fragments = dir
+	}
+	if fragments != nil {
+		before, _ := fragments.Stats()
+		defer func() {
+			after, _ := fragments.Stats()
+			o.reused = after - before
+		}()
+	}
+	asm := bytes.NewBufferString("")
+	cw, err := codewriter.TranslateCached(asm, files, codewriter.Options{
+		Bootstrap: o.bootstrap,
+		Entry:     o.bootstrapEntry(),
+		Optimize:  o.optimize,
+		Mode:      mode,
+	}, runtime.NumCPU(), fragments)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cw.LinkMap().Check(); err != nil {
+		return nil, cw, err
+	}
+	return asm.Bytes(), cw, nil
+}
+
+// check checks the program before it is translated, the same way for every
+// target, and reports the warnings.
+func (o *options) check(files []parser.File) error {
+	checks := project.Check(files, o.bootstrapEntry())
+	for _, f := range files {
+		checks = append(checks, analysis.CheckStack(f)...)
+	}
+	if entry := o.bootstrapEntry(); entry != "" {
+		checks = append(checks, stackWarnings(analysis.Calls(files), entry)...)
+	}
+	if checks.HasErrors() {
+		return checks
+	}
+	warn(checks)
+	_, err := statics.AllocateEntry(files, o.bootstrapEntry())
+	return err
+}
+
+// translateBackend checks the program and translates it with the backend that
+// newBackend returns, for a target other than Hack assembly.
+func (o *options) translateBackend(files []parser.File, newBackend func(*options, io.Writer, codewriter.Options) codewriter.Backend) ([]byte, error) {
+	if o.optimize < 0 || o.optimize > 1 {
+		return nil, usageErrorf("unknown optimization level %d", o.optimize)
+	}
+	if err := o.check(files); err != nil {
+		return nil, err
+	}
+	out := bytes.NewBufferString("")
+	b := newBackend(o, out, codewriter.Options{
+		Bootstrap: o.bootstrap,
+		Entry:     o.bootstrapEntry(),
+		Optimize:  o.optimize,
+	})
+	if err := codewriter.TranslateTo(b, files); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// load returns the machine code for inputs: a single .hack or .asm file, or
+// VM code that is translated with o first.
+func (o *options) load(inputs []string) ([]uint16, error) {
+	if len(inputs) == 1 {
+		switch input := inputs[0]; filepath.Ext(input) {
+		case ".hack":
+			f, err := os.Open(input)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return assembler.ReadHack(f)
+		case ".asm":
+			f, err := os.Open(input)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			code, err := assembler.Assemble(f)
+			var ae *assembler.Error
+			if errors.As(err, &ae) {
+				return nil, diag.Errorf("assembly", "%s", ae.Msg).At(input, ae.Line, 0)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return code, nil
+		}
+	}
+
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		return nil, err
+	}
+	asm, _, err := o.translate(files)
+	if err != nil {
+		return nil, err
+	}
+	return assembler.Assemble(bytes.NewReader(asm))
+}
+
+/*
+readProgram parses the VM code of all inputs as one program.
+
+An input is a .vm file, a directory whose .vm files are read, a glob pattern
+such as "lib/*.vm", or "-" for standard input, which is read as the file
+o.name. A file named by more than one input is read once. Calls to classes
+the inputs do not define are looked up in the library directories, and the
+files are returned in the order of project.Order.
+*/
+func (o *options) readProgram(inputs []string) ([]parser.File, error) {
+	paths, err := expand(inputs)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, p := range paths {
+		seen[p] = true
+	}
+
+	// report the syntax errors of every file at once
+	var files []parser.File
+	var errs diag.List
+	for _, p := range paths {
+		f, err := o.readFile(p)
+		var l diag.List
+		if errors.As(err, &l) {
+			errs = append(errs, l...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// library files may call further library classes
+	for added := true; added; {
+		added = false
+		for _, class := range project.Missing(files) {
+			p := o.findLibrary(class)
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			f, err := o.readFile(p)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+			added = true
+		}
+	}
+
+	project.Order(files, o.entry)
+	return files, nil
+}
+
+// expand returns the .vm files and "-" the inputs name, each once.
+func expand(inputs []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	for _, input := range inputs {
+		matches := []string{input}
+		if input != stdio && strings.ContainsAny(input, "*?[") {
+			var err error
+			matches, err = filepath.Glob(input)
+			if err != nil {
+				return nil, usageErrorf("%s: %v", input, err)
+			}
+			if len(matches) == 0 {
+				return nil, &os.PathError{Op: "glob", Path: input, Err: errors.New("no matching files")}
+			}
+		}
+		for _, m := range matches {
+			if m == stdio {
+				add(m)
+				continue
+			}
+			fInfo, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !fInfo.IsDir() {
+				add(filepath.Clean(m))
+				continue
+			}
+			vms, err := filepath.Glob(filepath.Join(m, "*.vm"))
+			if err != nil {
+				return nil, err
+			}
+			if len(vms) == 0 {
+				return nil, &os.PathError{Op: "read", Path: m, Err: errors.New("no .vm files")}
+			}
+			for _, vm := range vms {
+				add(vm)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, usageErrorf("no input files")
+	}
+	return paths, nil
+}
+
+// readFile parses the .vm file p, or standard input for "-".
+func (o *options) readFile(p string) (parser.File, error) {
+	var src []byte
+	var err error
+	name := strings.TrimSuffix(filepath.Base(p), ".vm")
+	if p == stdio {
+		src, err = ioutil.ReadAll(os.Stdin)
+		name = o.name
+	} else {
+		src, err = ioutil.ReadFile(p)
+	}
+	if err != nil {
+		return parser.File{}, err
+	}
+	cmds, err := parser.Parse(bytes.NewReader(src))
+	var l diag.List
+	if errors.As(err, &l) {
+		for _, d := range l {
+			d.File = p
+		}
+	}
+	if err != nil {
+		return parser.File{}, err
+	}
+	return parser.File{Name: name, Path: p, Commands: cmds}, nil
+}
+
+// findLibrary returns the first class.vm in the library directories, or ""
+// when there is none.
+func (o *options) findLibrary(class string) string {
+	for _, dir := range o.libraries {
+		p := filepath.Join(dir, class+".vm")
+		if fInfo, err := os.Stat(p); err == nil && !fInfo.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// writeOutput creates name, or uses standard output for "-", and fills it
+// from wt.
+func writeOutput(name string, wt io.WriterTo) error {
+	if name == stdio {
+		_, err := wt.WriteTo(os.Stdout)
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = wt.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/diag"
+)
+
+// testProgram writes files into a temporary directory and returns it.
+func testProgram(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "vmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func Test_options_outputName(t *testing.T) {
+	dir := testProgram(t, map[string]string{"Prog/Main.vm": "push constant 1\n"})
+	defer os.RemoveAll(dir)
+	prog := filepath.Join(dir, "Prog")
+
+	tests := []struct {
+		name    string
+		output  string
+		inputs  []string
+		want    string
+		wantErr bool
+	}{
+		{"file", "", []string{filepath.Join(prog, "Main.vm")}, filepath.Join(prog, "Main.asm"), false},
+		{"directory", "", []string{prog}, filepath.Join(prog, "Prog.asm"), false},
+		{"explicit", "out.asm", []string{prog}, "out.asm", false},
+		{"stdout", "-", []string{prog}, "-", false},
+		{"stdin", "", []string{"-"}, "-", false},
+		{"several inputs", "", []string{prog, "Other.vm"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &options{output: tt.output}
+			got, err := o.outputName(tt.inputs, ".asm")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("outputName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("outputName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_options_readProgram(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Prog/Main.vm": "function Main.main 0\npush constant 1\nreturn\n",
+		"Prog/Sys.vm":  "function Sys.init 0\ncall Main.main 0\n",
+		"Lib/Math.vm":  "function Math.abs 0\npush argument 0\nreturn\n",
+		"Bad.vm":       "push constant\n",
+	})
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		inputs  []string
+		want    []string
+		wantErr bool
+	}{
+		{"directory", []string{"Prog"}, []string{"Sys", "Main"}, false},
+		{"files and directories", []string{"Prog/Sys.vm", "Lib"}, []string{"Sys", "Math"}, false},
+		{"glob", []string{"*/M*.vm"}, []string{"Main", "Math"}, false},
+		{"duplicates", []string{"Prog/Main.vm", "Prog"}, []string{"Sys", "Main"}, false},
+		{"no match", []string{"*.jack"}, nil, true},
+		{"missing", []string{"Missing.vm"}, nil, true},
+		{"parse error", []string{"Bad.vm"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []string
+			for _, in := range tt.inputs {
+				inputs = append(inputs, filepath.Join(dir, in))
+			}
+			o := &options{}
+			files, err := o.readProgram(inputs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readProgram() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, f.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readProgram() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("readProgram() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_options_readProgram_libraries(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Prog/Main.vm": "function Main.main 0\npush constant 1\ncall Math.abs 1\nreturn\n",
+		"Prog/Sys.vm":  "function Sys.init 0\ncall Main.main 0\n",
+		"Lib/Math.vm":  "function Math.abs 0\npush argument 0\ncall Util.id 1\nreturn\n",
+		"Lib/Util.vm":  "function Util.id 0\npush argument 0\nreturn\n",
+		"Lib/Array.vm": "function Array.new 0\npush constant 0\nreturn\n",
+	})
+	defer os.RemoveAll(dir)
+
+	o := &options{libraries: pathList{filepath.Join(dir, "Missing"), filepath.Join(dir, "Lib")}}
+	files, err := o.readProgram([]string{filepath.Join(dir, "Prog")})
+	if err != nil {
+		t.Fatalf("readProgram() error = %v", err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name)
+	}
+	want := []string{"Sys", "Main", "Math", "Util"}
+	if len(got) != len(want) {
+		t.Fatalf("readProgram() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("readProgram() = %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_options_inputs(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Proj/vmt.json":    `{"inputs": ["src"], "entry": "Main.main", "bootstrap": false, "optimize": 1, "libraries": ["lib"]}`,
+		"Proj/src/Main.vm": "function Main.main 0\n",
+		"Plain/Main.vm":    "function Main.main 0\n",
+	})
+	defer os.RemoveAll(dir)
+	proj := filepath.Join(dir, "Proj")
+
+	tests := []struct {
+		name   string
+		args   []string
+		inputs []string
+		want   options
+	}{
+		{
+			"no manifest",
+			[]string{filepath.Join(dir, "Plain")},
+			[]string{filepath.Join(dir, "Plain")},
+			options{bootstrap: true, entry: "Sys.init"},
+		},
+		{
+			"manifest in directory",
+			[]string{proj},
+			[]string{filepath.Join(proj, "src")},
+			options{entry: "Main.main", optimize: 1, libraries: pathList{filepath.Join(proj, "lib")}, project: proj},
+		},
+		{
+			"flags win",
+			[]string{"-O", "0", "-entry", "Game.run", "-lib", "os", proj},
+			[]string{filepath.Join(proj, "src")},
+			options{entry: "Game.run", libraries: pathList{"os", filepath.Join(proj, "lib")}, project: proj},
+		},
+		{
+			"-manifest with inputs",
+			[]string{"-manifest", filepath.Join(proj, "vmt.json"), "Other.vm"},
+			[]string{"Other.vm"},
+			options{entry: "Main.main", optimize: 1, libraries: pathList{filepath.Join(proj, "lib")}, project: proj},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o options
+			fs := newFlagSet("test", "")
+			o.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := o.inputs(fs)
+			if err != nil {
+				t.Fatalf("inputs() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.inputs) {
+				t.Errorf("inputs() = %v, want %v", got, tt.inputs)
+			}
+			o.manifest, o.name, o.cacheDir = "", "", ""
+			if !reflect.DeepEqual(o, tt.want) {
+				t.Errorf("options = %+v, want %+v", o, tt.want)
+			}
+		})
+	}
+}
+
+// Files named alike must be rejected even without statics, since the labels
+// numbered per file, such as Main$cmp$0, would be defined twice.
+func Test_options_translate_sameName(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"a/Sys.vm":  "function Sys.init 0\ncall Main.f 0\nlabel END\ngoto END\n",
+		"a/Main.vm": "function Main.f 0\npush constant 1\npush constant 1\neq\nreturn\n",
+		"b/Main.vm": "push constant 1\npush constant 2\neq\npop temp 0\n",
+	})
+	defer os.RemoveAll(dir)
+	o := options{bootstrap: true}
+	files, err := o.readProgram([]string{
+		filepath.Join(dir, "a", "Sys.vm"),
+		filepath.Join(dir, "a", "Main.vm"),
+		filepath.Join(dir, "b", "Main.vm"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = o.translate(files)
+	if l := diag.From(err); len(l) != 1 || l[0].Rule != "duplicate-file" {
+		t.Errorf("translate() error = %v, want duplicate-file", err)
+	}
+}
+
+// A label of the program would be defined again as the return address of
+// the call, Main$ret$0.
+func Test_options_readProgram_generatedLabel(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Main.vm": "label ret$0\ncall Main.f 0\nfunction Main.f 0\npush constant 0\nreturn\n",
+	})
+	defer os.RemoveAll(dir)
+	o := options{}
+	_, err := o.readProgram([]string{dir})
+	if l := diag.From(err); len(l) != 1 || l[0].Rule != "invalid-name" || l[0].Line != 1 {
+		t.Errorf("readProgram() error = %v, want invalid-name on line 1", err)
+	}
+}
+
+func Test_options_check_stackOverflow(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm":  "function Sys.init 0\ncall Main.main 0\nlabel END\ngoto END\n",
+		"Main.vm": "function Main.main 1800\npush constant 0\nreturn\n",
+	})
+	defer os.RemoveAll(dir)
+	report(ioutil.Discard, nil)
+	o := options{bootstrap: true, entry: "Sys.init"}
+	files, err := o.readProgram([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.check(files); err != nil {
+		t.Fatal(err)
+	}
+	w := bytes.NewBufferString("")
+	report(w, nil)
+	if !strings.Contains(w.String(), "the stack may grow to 2067, into the heap at 2048 [stack-overflow]") {
+		t.Errorf("check() warned %q", w)
+	}
+}
+
+func Test_options_translate_asm(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm": "function Sys.init 0\npush constant 1\npop temp 0\nlabel END\ngoto END\n",
+	})
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		asm     string
+		want    string
+		wantErr bool
+	}{
+		{"", "// initialize asm\n", false},
+		{"compact", "@256\nD=A\n", false},
+		{"annotated", "// Sys.vm:2: push constant 1\n@1              // 53\n", false},
+		{"terse", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.asm, func(t *testing.T) {
+			o := options{bootstrap: true, asm: tt.asm}
+			files, err := o.readProgram([]string{dir})
+			if err != nil {
+				t.Fatal(err)
+			}
+			asm, _, err := o.translate(files)
+			var ue *usageError
+			if tt.wantErr {
+				if !errors.As(err, &ue) {
+					t.Errorf("translate() error = %v, want a usage error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(asm), tt.want) {
+				t.Errorf("translate() =\n%s\nwithout %q", asm, tt.want)
+			}
+		})
+	}
+}
+
+func Test_options_translateBackend(t *testing.T) {
+	dir := testProgram(t, map[string]string{
+		"Sys.vm": "function Sys.init 0\npush constant 1\npop temp 0\nlabel END\ngoto END\n",
+		"Bad.vm": "function Bad.f 0\nadd\nreturn\n",
+	})
+	defer os.RemoveAll(dir)
+	c := lookupTarget("c")
+	o := options{bootstrap: true}
+	files, err := o.readProgram([]string{filepath.Join(dir, "Sys.vm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := o.translateBackend(files, c.backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\tvm_ram[5] = pop();\n"; !strings.Contains(string(out), want) {
+		t.Errorf("translateBackend() =\n%s\nwithout %q", out, want)
+	}
+	o.pkg = "prog"
+	out, err = o.translateBackend(files, lookupTarget("go").backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package prog\n"; !strings.Contains(string(out), want) {
+		t.Errorf("translateBackend() =\n%s\nwithout %q", out, want)
+	}
+	out, err = o.translateBackend(files, lookupTarget("x86-64").backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\tcall vm_pop\n\tmovw %cx, 10(%rbx)\n"; !strings.Contains(string(out), want) {
+		t.Errorf("translateBackend() =\n%s\nwithout %q", out, want)
+	}
+
+	// the same checks as for Hack assembly
+	files, err = o.readProgram([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.translateBackend(files, c.backend)
+	if l := diag.From(err); len(l) != 1 || l[0].Rule != "stack-underflow" {
+		t.Errorf("translateBackend() = %v, want stack-underflow", err)
+	}
+}
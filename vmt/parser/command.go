@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"vmt/diag"
+)
+
+// Command is a single VM command together with the line it was read from.
+type Command struct {
+	Type Type
+	Arg1 string
+	Arg2 int
+	Line int
+}
+
+// File is a named sequence of VM commands, usually one .vm file.
+// Name is the base name without extension (e.g. "Sys"), Path the file it was
+// read from, if any.
+type File struct {
+	Name     string
+	Path     string
+	Commands []Command
+}
+
+// String returns the command in canonical VM syntax.
+func (c Command) String() string {
+	switch c.Type {
+	case ARITHMETIC:
+		return c.Arg1
+	case PUSH:
+		return fmt.Sprintf("push %s %d", c.Arg1, c.Arg2)
+	case POP:
+		return fmt.Sprintf("pop %s %d", c.Arg1, c.Arg2)
+	case LABEL:
+		return "label " + c.Arg1
+	case GOTO:
+		return "goto " + c.Arg1
+	case IF:
+		return "if-goto " + c.Arg1
+	case FUNCTION:
+		return fmt.Sprintf("function %s %d", c.Arg1, c.Arg2)
+	case RETURN:
+		return "return"
+	case CALL:
+		return fmt.Sprintf("call %s %d", c.Arg1, c.Arg2)
+	default:
+		return ""
+	}
+}
+
+// Line is a line of VM source with its comment, for tools that rewrite
+// source rather than translate it.
+type Line struct {
+	Command    *Command // nil for blank lines and lines with only a comment
+	HasComment bool
+	Comment    string // text after "//"
+}
+
+// Parse reads every command from r. Blank lines and comments are skipped.
+// Lines that are not valid VM commands are reported together as a
+// diag.List, without file names.
+func Parse(r io.Reader) ([]Command, error) {
+	return parse(r, nil)
+}
+
+// ParseLines is Parse that returns every line of r, comments included.
+func ParseLines(r io.Reader) ([]Line, error) {
+	var lines []Line
+	if _, err := parse(r, &lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// words is the number of words of each type of command.
+var words = map[Type]int{
+	ARITHMETIC: 1, RETURN: 1,
+	LABEL: 2, GOTO: 2, IF: 2,
+	PUSH: 3, POP: 3, FUNCTION: 3, CALL: 3,
+}
+
+// MaxConstant is the largest constant push constant takes, the largest
+// number an A-instruction loads.
+const MaxConstant = 32767
+
+// segments are the memory segments with their last index, or -1 when the
+// index has no fixed bound.
+var segments = map[string]int{
+	"argument": -1, "local": -1, "static": -1, "constant": -1,
+	"this": -1, "that": -1, "pointer": 1, "temp": 7,
+}
+
+func parse(r io.Reader, lines *[]Line) ([]Command, error) {
+	var cmds []Command
+	var errs diag.List
+	p := New(r)
+	fail := func(rule string, word int, format string, args ...interface{}) {
+		errs = append(errs, diag.Errorf(rule, format, args...).At("", p.Line(), p.Column(word)))
+	}
+	for p.HasMoreCommands() {
+		p.Advance()
+		var l Line
+		if i := strings.Index(p.scanner.Text(), "//"); i >= 0 {
+			l.Comment, l.HasComment = p.scanner.Text()[i+2:], true
+		}
+		if p.input == "" {
+			if lines != nil {
+				*lines = append(*lines, l)
+			}
+			continue
+		}
+		c := Command{Type: p.CommandType(), Line: p.Line()}
+		switch c.Type {
+		case None:
+			fail("invalid-command", 0, "invalid command %q", p.input)
+			continue
+		case ARITHMETIC, LABEL, GOTO, IF:
+			c.Arg1 = p.Arg1()
+		case PUSH, POP, FUNCTION, CALL:
+			c.Arg1 = p.Arg1()
+			arg2, err := p.Arg2()
+			if err != nil && len(strings.Fields(p.input)) < 3 {
+				if c.Arg1 != "" {
+					fail("missing-argument", 2, "%v", err)
+					continue
+				}
+			} else if err != nil {
+				fail("invalid-argument", 2, "invalid number %q in %q", strings.Fields(p.input)[2], p.input)
+				continue
+			}
+			c.Arg2 = arg2
+		}
+		if c.Type != ARITHMETIC && c.Type != RETURN && c.Arg1 == "" {
+			fail("missing-argument", 1, "missing argument in %q", p.input)
+			continue
+		}
+		if fields := strings.Fields(p.input); len(fields) > words[c.Type] {
+			fail("extra-argument", words[c.Type], "unexpected %q in %q", fields[words[c.Type]], p.input)
+			continue
+		}
+		if c.Type != PUSH && c.Type != POP && strings.Contains(c.Arg1, "$") {
+			fail("invalid-name", 1, "name %q in %q contains \"$\", which the translator keeps for its own labels", c.Arg1, p.input)
+			continue
+		}
+		if c.Arg2 < 0 {
+			fail("invalid-index", 2, "negative number %d in %q", c.Arg2, p.input)
+			continue
+		}
+		if c.Type == PUSH || c.Type == POP {
+			last, ok := segments[c.Arg1]
+			switch {
+			case !ok:
+				fail("invalid-segment", 1, "unknown segment %q in %q", c.Arg1, p.input)
+				continue
+			case c.Type == POP && c.Arg1 == "constant":
+				fail("invalid-segment", 1, "cannot pop to constant in %q", p.input)
+				continue
+			case c.Arg1 == "constant" && c.Arg2 > MaxConstant:
+				fail("invalid-index", 2, "constant %d is more than %d in %q", c.Arg2, MaxConstant, p.input)
+				continue
+			case last >= 0 && c.Arg2 > last:
+				fail("invalid-index", 2, "%s %d is outside %s 0-%d in %q", c.Arg1, c.Arg2, c.Arg1, last, p.input)
+				continue
+			}
+		}
+		cmds = append(cmds, c)
+		if lines != nil {
+			l.Command = &c
+			*lines = append(*lines, l)
+		}
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cmds, nil
+}
+
+// WriteTo writes the commands of f as VM source, one command per line.
+func (f File) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, c := range f.Commands {
+		b.WriteString(c.String())
+		b.WriteString("\n")
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzParser(f *testing.F) {
+	seeds := []string{
+		"push constant 7\npush constant 8\nadd\n",
+		"function Main.main 2\npush local 0\nreturn\n",
+		"label LOOP // comment\nif-goto LOOP\ngoto LOOP\n",
+		"call Math.multiply 2",
+		"push\npop local\nfunction\nreturn extra\n",
+		"\tpush   constant\t1  \n",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		// the streaming API must never panic, whatever the input
+		p := New(strings.NewReader(src))
+		for p.HasMoreCommands() {
+			p.Advance()
+			p.CommandType()
+			p.Arg1()
+			p.Arg2()
+		}
+
+		// whatever Parse accepts must survive a round trip through String
+		cmds, err := Parse(strings.NewReader(src))
+		if err != nil {
+			return
+		}
+		var b strings.Builder
+		File{Commands: cmds}.WriteTo(&b)
+		again, err := Parse(strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatalf("Parse(%q) failed on printed output: %v", b.String(), err)
+		}
+		if len(again) != len(cmds) {
+			t.Fatalf("round trip changed command count: %d != %d", len(again), len(cmds))
+		}
+		for i := range cmds {
+			if cmds[i].String() != again[i].String() {
+				t.Errorf("round trip changed %q to %q", cmds[i], again[i])
+			}
+		}
+	})
+}
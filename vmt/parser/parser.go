@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -27,6 +28,7 @@ const (
 type Parser struct {
 	scanner *bufio.Scanner
 	input   string
+	line    int
 }
 
 func New(r io.Reader) *Parser {
@@ -38,7 +40,41 @@ func New(r io.Reader) *Parser {
 }
 
 func (p *Parser) HasMoreCommands() bool {
-	return p.scanner.Scan()
+	if !p.scanner.Scan() {
+		return false
+	}
+	p.line++
+	return true
+}
+
+// Line returns the 1-based line number of the current command.
+func (p *Parser) Line() int {
+	return p.line
+}
+
+// Column returns the 1-based column of word n of the current command, where
+// word 0 is the command itself, or the column after the last word when there
+// are fewer words.
+func (p *Parser) Column(n int) int {
+	line := p.scanner.Text()
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	col, word, inWord := 0, -1, false
+	for i, r := range line {
+		space := r == ' ' || r == '\t' || r == '\r'
+		if !space && !inWord {
+			word++
+			if word == n {
+				return i + 1
+			}
+		}
+		inWord = !space
+		if !space {
+			col = i + 1
+		}
+	}
+	return col + 1
 }
 
 func (p *Parser) Advance() {
@@ -50,8 +86,7 @@ func (p *Parser) Advance() {
 	if strings.Contains(line, "//") {
 		line = line[:strings.Index(line, "//")]
 	}
-	line = strings.TrimSpace(line)
-	p.input = line
+	p.input = strings.Join(strings.Fields(line), " ")
 }
 
 func (p *Parser) CommandType() Type {
@@ -86,12 +121,18 @@ func (p *Parser) Arg1() string {
 	case "add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not":
 		return slice[0]
 	default:
+		if len(slice) < 2 {
+			return ""
+		}
 		return slice[1]
 	}
 }
 
 func (p *Parser) Arg2() (int, error) {
 	slice := strings.Split(p.input, " ")
+	if len(slice) < 3 {
+		return 0, fmt.Errorf("missing second argument in %q", p.input)
+	}
 	arg2, err := strconv.Atoi(slice[2])
 	if err != nil {
 		return 0, err
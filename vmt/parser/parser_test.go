@@ -1,9 +1,11 @@
 package parser
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
+	"vmt/diag"
 )
 
 func TestParser_HasMoreCommands(t *testing.T) {
@@ -66,6 +68,11 @@ func TestParser_Advance(t *testing.T) {
 			"test // comment",
 			"test",
 		},
+		{
+			"tabs_and_spaces",
+			"push\t constant   7",
+			"push constant 7",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -285,6 +292,13 @@ func TestParser_Arg1(t *testing.T) {
 			when RETURN, Args1() must not be called...
 		*/
 
+		// missing arg1
+		{
+			"missing arg1",
+			"goto",
+			"",
+		},
+
 		// CALL
 		{
 			"call",
@@ -353,6 +367,13 @@ func TestParser_Arg2(t *testing.T) {
 			0,
 			true,
 		},
+		// missing arg2
+		{
+			"missing arg2",
+			"push constant",
+			0,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -372,3 +393,149 @@ func TestParser_Arg2(t *testing.T) {
 		})
 	}
 }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    []Command
+		wantErr bool
+	}{
+		{
+			"commands",
+			"// comment\nfunction Main.main 1\n\n  push   constant 7 // seven\nadd\nreturn\n",
+			[]Command{
+				{Type: FUNCTION, Arg1: "Main.main", Arg2: 1, Line: 2},
+				{Type: PUSH, Arg1: "constant", Arg2: 7, Line: 4},
+				{Type: ARITHMETIC, Arg1: "add", Line: 5},
+				{Type: RETURN, Line: 6},
+			},
+			false,
+		},
+		{
+			"invalid command",
+			"push constant 1\njump LOOP\n",
+			nil,
+			true,
+		},
+		{
+			"missing arg1",
+			"goto\n",
+			nil,
+			true,
+		},
+		{
+			"missing arg2",
+			"push constant\n",
+			nil,
+			true,
+		},
+		{"extra argument", "push constant 7 8\n", nil, true},
+		{"extra argument of arithmetic", "add foo\n", nil, true},
+		{"extra argument of return", "return 1\n", nil, true},
+		{"unknown segment", "push foo 1\n", nil, true},
+		{"pop constant", "pop constant 1\n", nil, true},
+		{"negative index", "push local -1\n", nil, true},
+		{"negative count", "function Main.main -2\n", nil, true},
+		{"pointer out of range", "pop pointer 2\n", nil, true},
+		{"temp out of range", "push temp 8\n", nil, true},
+		{"constant out of range", "push constant 32768\n", nil, true},
+		{"$ in label", "label ret$0\n", nil, true},
+		{"$ in goto", "goto ret$0\n", nil, true},
+		{"$ in function", "function Main$f 0\n", nil, true},
+		{"$ in call", "call Main$f 0\n", nil, true},
+		{
+			"last indices",
+			"push pointer 1\npop temp 7\npush constant 32767\n",
+			[]Command{
+				{Type: PUSH, Arg1: "pointer", Arg2: 1, Line: 1},
+				{Type: POP, Arg1: "temp", Arg2: 7, Line: 2},
+				{Type: PUSH, Arg1: "constant", Arg2: 32767, Line: 3},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_String(t *testing.T) {
+	tests := []struct {
+		name string
+		args Command
+		want string
+	}{
+		{"arithmetic", Command{Type: ARITHMETIC, Arg1: "add"}, "add"},
+		{"push", Command{Type: PUSH, Arg1: "constant", Arg2: 7}, "push constant 7"},
+		{"pop", Command{Type: POP, Arg1: "local", Arg2: 0}, "pop local 0"},
+		{"label", Command{Type: LABEL, Arg1: "LOOP"}, "label LOOP"},
+		{"goto", Command{Type: GOTO, Arg1: "LOOP"}, "goto LOOP"},
+		{"if-goto", Command{Type: IF, Arg1: "END"}, "if-goto END"},
+		{"function", Command{Type: FUNCTION, Arg1: "Main.main", Arg2: 2}, "function Main.main 2"},
+		{"return", Command{Type: RETURN}, "return"},
+		{"call", Command{Type: CALL, Arg1: "Math.multiply", Arg2: 2}, "call Math.multiply 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.args.String(); got != tt.want {
+				t.Errorf("Command.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_diagnostics(t *testing.T) {
+	src := "push constant 1\n  jump LOOP\npush constant\n\tpush local x // comment\ngoto\n" +
+		"push constant 7 8\npush foo 1\npop temp 9\npush constant 40000\n"
+	_, err := Parse(strings.NewReader(src))
+	var got diag.List
+	if !errors.As(err, &got) {
+		t.Fatalf("Parse() error = %v, want a diag.List", err)
+	}
+	want := diag.List{
+		{Line: 2, Column: 3, Severity: diag.Error, Rule: "invalid-command", Message: `invalid command "jump LOOP"`},
+		{Line: 3, Column: 14, Severity: diag.Error, Rule: "missing-argument", Message: `missing second argument in "push constant"`},
+		{Line: 4, Column: 13, Severity: diag.Error, Rule: "invalid-argument", Message: `invalid number "x" in "push local x"`},
+		{Line: 5, Column: 5, Severity: diag.Error, Rule: "missing-argument", Message: `missing argument in "goto"`},
+		{Line: 6, Column: 17, Severity: diag.Error, Rule: "extra-argument", Message: `unexpected "8" in "push constant 7 8"`},
+		{Line: 7, Column: 6, Severity: diag.Error, Rule: "invalid-segment", Message: `unknown segment "foo" in "push foo 1"`},
+		{Line: 8, Column: 10, Severity: diag.Error, Rule: "invalid-index", Message: `temp 9 is outside temp 0-7 in "pop temp 9"`},
+		{Line: 9, Column: 15, Severity: diag.Error, Rule: "invalid-index", Message: `constant 40000 is more than 32767 in "push constant 40000"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() error =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	src := "// header\n\nfunction Main.main 0\n  push constant 1 //one\nreturn\n//\n"
+	got, err := ParseLines(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Line{
+		{HasComment: true, Comment: " header"},
+		{},
+		{Command: &Command{Type: FUNCTION, Arg1: "Main.main", Line: 3}},
+		{Command: &Command{Type: PUSH, Arg1: "constant", Arg2: 1, Line: 4}, HasComment: true, Comment: "one"},
+		{Command: &Command{Type: RETURN, Line: 5}},
+		{HasComment: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLines() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ParseLines(strings.NewReader("push\n")); err == nil {
+		t.Error("ParseLines() accepted an invalid command")
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Package project decides which VM files make up a program and in which order
+they are translated.
+
+The order of the files is visible in the output: the assembler allocates
+statics in order of first appearance. Labels of comparisons and return
+addresses are numbered per file, as in Main$cmp$0, and do not depend on it.
+Order therefore puts the files in a fixed order that does not depend on how
+they were found: the file of the entry point first, then the rest by name.
+
+A manifest, vmt.json, lists the inputs of a program together with its entry
+point, library search paths and code generation options:
+
+	{
+		"inputs": ["src"],
+		"entry": "Sys.init",
+		"libraries": ["../os"],
+		"bootstrap": true,
+		"optimize": 1,
+		"output": "build/Pong.asm",
+		"lint": {"unused-label": false}
+	}
+
+Relative paths are relative to the directory of the manifest.
+*/
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+// ManifestName is the file name of the manifest in a project directory.
+const ManifestName = "vmt.json"
+
+// DefaultEntry is the function the bootstrap code calls.
+const DefaultEntry = "Sys.init"
+
+// Manifest is a vmt.json file.
+type Manifest struct {
+	// Inputs are .vm files, directories and glob patterns. No inputs means
+	// the directory of the manifest.
+	Inputs []string `json:"inputs,omitempty"`
+	// Entry is the function the bootstrap code calls, Sys.init by default.
+	Entry string `json:"entry,omitempty"`
+	// Libraries are directories searched for Foo.vm when the program calls
+	// a function Foo.bar it does not define.
+	Libraries []string `json:"libraries,omitempty"`
+	// Bootstrap and Optimize are the code generation options; nil leaves
+	// the defaults.
+	Bootstrap *bool `json:"bootstrap,omitempty"`
+	Optimize  *int  `json:"optimize,omitempty"`
+	// Output is the .asm file to write.
+	Output string `json:"output,omitempty"`
+	// Lint switches the rules of vmt lint on and off by name.
+	Lint map[string]bool `json:"lint,omitempty"`
+
+	// Dir is the directory of the manifest.
+	Dir string `json:"-"`
+}
+
+// Load reads the manifest at path and makes its paths relative to the
+// current directory. Unknown fields are errors, so that typos do not go
+// unnoticed.
+func Load(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if m.Entry != "" && !strings.Contains(m.Entry, ".") {
+		return nil, fmt.Errorf("%s: entry %q is not a function name such as Sys.init", path, m.Entry)
+	}
+
+	m.Dir = filepath.Dir(path)
+	for i, in := range m.Inputs {
+		m.Inputs[i] = m.resolve(in)
+	}
+	if len(m.Inputs) == 0 {
+		m.Inputs = []string{m.Dir}
+	}
+	for i, lib := range m.Libraries {
+		m.Libraries[i] = m.resolve(lib)
+	}
+	if m.Output != "" {
+		m.Output = m.resolve(m.Output)
+	}
+	return m, nil
+}
+
+func (m *Manifest) resolve(p string) string {
+	if p == "-" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.Dir, p)
+}
+
+// Find returns the manifest in dir, or "" when there is none.
+func Find(dir string) string {
+	p := filepath.Join(dir, ManifestName)
+	if fInfo, err := os.Stat(p); err == nil && !fInfo.IsDir() {
+		return p
+	}
+	return ""
+}
+
+/*
+Order sorts files into translation order: the file that defines entry
+(DefaultEntry if empty) comes first, or else the file named after its class,
+and then the others by name and path. Files with the same name and path
+keep their order.
+*/
+func Order(files []parser.File, entry string) {
+	if entry == "" {
+		entry = DefaultEntry
+	}
+	first := -1
+	for i, f := range files {
+		if defines(f, entry) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		for i, f := range files {
+			if f.Name == class(entry) {
+				first = i
+				break
+			}
+		}
+	}
+	if first > 0 {
+		f := files[first]
+		copy(files[1:first+1], files[:first])
+		files[0] = f
+	}
+
+	rest := files
+	if first >= 0 {
+		rest = files[1:]
+	}
+	sort.SliceStable(rest, func(i, j int) bool {
+		if rest[i].Name != rest[j].Name {
+			return rest[i].Name < rest[j].Name
+		}
+		return rest[i].Path < rest[j].Path
+	})
+}
+
+func defines(f parser.File, function string) bool {
+	for _, c := range f.Commands {
+		if c.Type == parser.FUNCTION && c.Arg1 == function {
+			return true
+		}
+	}
+	return false
+}
+
+// class returns the class of a function name such as Main.main, which
+// nand2tetris puts in the file Main.vm.
+func class(function string) string {
+	if i := strings.Index(function, "."); i > 0 {
+		return function[:i]
+	}
+	return function
+}
+
+// Missing returns the sorted classes of functions that files call but do not
+// define, e.g. "Math" for a call to Math.multiply. These are the files to
+// look for in the library search paths.
+func Missing(files []parser.File) []string {
+	defined := map[string]bool{}
+	for _, f := range files {
+		for _, c := range f.Commands {
+			if c.Type == parser.FUNCTION {
+				defined[c.Arg1] = true
+			}
+		}
+	}
+	seen := map[string]bool{}
+	var classes []string
+	for _, f := range files {
+		for _, c := range f.Commands {
+			if c.Type != parser.CALL || defined[c.Arg1] {
+				continue
+			}
+			if cl := class(c.Arg1); cl != c.Arg1 && !seen[cl] {
+				seen[cl] = true
+				classes = append(classes, cl)
+			}
+		}
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+/*
+Check reports problems that span commands: functions and labels defined
+twice, which the assembler rejects, and calls and jumps to functions and
+labels the program does not define, which it silently turns into variables.
+A missing entry function is only reported when entry is not empty.
+*/
+func Check(files []parser.File, entry string) diag.List {
+	var l diag.List
+	defined := map[string]bool{}
+	for _, f := range files {
+		for _, c := range f.Commands {
+			if c.Type != parser.FUNCTION {
+				continue
+			}
+			if defined[c.Arg1] {
+				l = append(l, diag.Errorf("duplicate-function", "function %s is defined twice", c.Arg1).At(path(f), c.Line, 0))
+			}
+			defined[c.Arg1] = true
+		}
+	}
+	if entry != "" && !defined[entry] {
+		l = append(l, diag.Warningf("undefined-function", "entry function %s is not defined", entry))
+	}
+
+	for _, f := range files {
+		scope := f.Name + ".vm"
+		labels := map[string]bool{}
+		var jumps []parser.Command
+		endScope := func() {
+			for _, j := range jumps {
+				if !labels[j.Arg1] {
+					l = append(l, diag.Warningf("undefined-label", "label %s is not defined in %s", j.Arg1, scope).At(path(f), j.Line, 0))
+				}
+			}
+			labels, jumps = map[string]bool{}, nil
+		}
+		for _, c := range f.Commands {
+			switch c.Type {
+			case parser.FUNCTION:
+				endScope()
+				scope = c.Arg1
+			case parser.LABEL:
+				if labels[c.Arg1] {
+					l = append(l, diag.Errorf("duplicate-label", "label %s is defined twice in %s", c.Arg1, scope).At(path(f), c.Line, 0))
+				}
+				labels[c.Arg1] = true
+			case parser.GOTO, parser.IF:
+				jumps = append(jumps, c)
+			case parser.CALL:
+				if !defined[c.Arg1] {
+					l = append(l, diag.Warningf("undefined-function", "function %s is not defined", c.Arg1).At(path(f), c.Line, 0))
+				}
+			}
+		}
+		endScope()
+	}
+	return l
+}
+
+// path returns the file f was read from, or its name.
+func path(f parser.File) string {
+	if f.Path != "" {
+		return f.Path
+	}
+	return f.Name + ".vm"
+}
@@ -0,0 +1,204 @@
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"vmt/parser"
+)
+
+func names(files []parser.File) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.Name)
+	}
+	return out
+}
+
+func function(name string) parser.Command {
+	return parser.Command{Type: parser.FUNCTION, Arg1: name}
+}
+
+func call(name string) parser.Command {
+	return parser.Command{Type: parser.CALL, Arg1: name}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []parser.File
+		entry string
+		want  []string
+	}{
+		{
+			"Sys first",
+			[]parser.File{{Name: "Main"}, {Name: "Sys"}, {Name: "Array"}},
+			"",
+			[]string{"Sys", "Array", "Main"},
+		},
+		{
+			"file defining the entry",
+			[]parser.File{
+				{Name: "Main"},
+				{Name: "Boot", Commands: []parser.Command{function("Game.start")}},
+				{Name: "Game"},
+			},
+			"Game.start",
+			[]string{"Boot", "Game", "Main"},
+		},
+		{
+			"no entry",
+			[]parser.File{{Name: "Main"}, {Name: "Array"}},
+			"",
+			[]string{"Array", "Main"},
+		},
+		{
+			"same name by path",
+			[]parser.File{{Name: "Foo", Path: "b/Foo.vm"}, {Name: "Foo", Path: "a/Foo.vm"}},
+			"",
+			[]string{"Foo", "Foo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Order(tt.files, tt.entry)
+			if got := names(tt.files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrder_independentOfInput(t *testing.T) {
+	a := []parser.File{{Name: "Sys"}, {Name: "Main"}, {Name: "Foo", Path: "a/Foo.vm"}, {Name: "Foo", Path: "b/Foo.vm"}}
+	b := []parser.File{a[3], a[1], a[2], a[0]}
+	Order(a, "")
+	Order(b, "")
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Order() = %v and %v", a, b)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	files := []parser.File{
+		{Name: "Main", Commands: []parser.Command{
+			function("Main.main"),
+			call("Output.printInt"),
+			call("Math.multiply"),
+			call("Main.helper"),
+			call("Math.divide"),
+		}},
+		{Name: "Sys", Commands: []parser.Command{function("Sys.init"), call("Main.main")}},
+	}
+	want := []string{"Main", "Math", "Output"}
+	if got := Missing(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("Missing() = %v, want %v", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	one, zero := 1, 0
+	tests := []struct {
+		name    string
+		json    string
+		want    *Manifest
+		wantErr bool
+	}{
+		{
+			"full",
+			`{"inputs": ["src", "/abs/Main.vm"], "entry": "Main.main", "libraries": ["../os"], "bootstrap": false, "optimize": 1, "output": "out/Prog.asm", "lint": {"unused-label": false}}`,
+			&Manifest{
+				Inputs:    []string{filepath.Join(dir, "src"), "/abs/Main.vm"},
+				Entry:     "Main.main",
+				Libraries: []string{filepath.Join(filepath.Dir(dir), "os")},
+				Bootstrap: new(bool),
+				Optimize:  &one,
+				Output:    filepath.Join(dir, "out/Prog.asm"),
+				Lint:      map[string]bool{"unused-label": false},
+				Dir:       dir,
+			},
+			false,
+		},
+		{
+			"empty",
+			`{}`,
+			&Manifest{Inputs: []string{dir}, Dir: dir},
+			false,
+		},
+		{
+			"optimize 0",
+			`{"optimize": 0}`,
+			&Manifest{Inputs: []string{dir}, Optimize: &zero, Dir: dir},
+			false,
+		},
+		{"unknown field", `{"input": ["src"]}`, nil, true},
+		{"bad entry", `{"entry": "main"}`, nil, true},
+		{"syntax", `{"inputs": }`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, ManifestName)
+			if err := ioutil.WriteFile(p, []byte(tt.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Load(p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got := Find(dir); got != filepath.Join(dir, ManifestName) {
+		t.Errorf("Find() = %q", got)
+	}
+	if got := Find(filepath.Join(dir, "missing")); got != "" {
+		t.Errorf("Find() = %q, want none", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	label := func(t parser.Type, name string, line int) parser.Command {
+		return parser.Command{Type: t, Arg1: name, Line: line}
+	}
+	files := []parser.File{
+		{Name: "Main", Path: "src/Main.vm", Commands: []parser.Command{
+			label(parser.FUNCTION, "Main.main", 1),
+			label(parser.LABEL, "LOOP", 2),
+			label(parser.GOTO, "LOOP", 3),
+			label(parser.IF, "END", 4),
+			label(parser.CALL, "Math.abs", 5),
+			label(parser.LABEL, "LOOP", 6),
+			label(parser.FUNCTION, "Main.f", 7),
+			label(parser.GOTO, "LOOP", 8),
+			label(parser.FUNCTION, "Main.main", 9),
+		}},
+	}
+	want := []string{
+		"src/Main.vm:9: function Main.main is defined twice",
+		"entry function Sys.init is not defined",
+		"src/Main.vm:5: function Math.abs is not defined",
+		"src/Main.vm:6: label LOOP is defined twice in Main.main",
+		"src/Main.vm:4: label END is not defined in Main.main",
+		"src/Main.vm:8: label LOOP is not defined in Main.f",
+	}
+	var got []string
+	for _, d := range Check(files, "Sys.init") {
+		got = append(got, d.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() =\n%v\nwant\n%v", got, want)
+	}
+	if l := Check(files[:0], ""); len(l) != 0 {
+		t.Errorf("Check() of no files = %v", l)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"vmt/parser"
+	"vmt/reducer"
+)
+
+const reduceUsage = `usage: vmt reduce [flags] {vm file or directory} [--] command [args...]
+       vmt reduce [flags] {vm file, directory or glob pattern} ... -- command [args...]
+
+Shrinks a VM program while command still succeeds on it. Every candidate
+program is written to a temporary directory; "{}" in the command arguments is
+replaced with that directory, or the directory is appended as the last
+argument. Exit status 0 means the candidate still shows the problem.
+
+flags:
+`
+
+func reduce(args []string) error {
+	fs := flag.NewFlagSet("reduce", flag.ContinueOnError)
+	out := fs.String("o", "reduced", "directory to write the reduced .vm files to")
+	timeout := fs.Duration("timeout", 10*time.Second, "time limit for one run of command; a timeout counts as not failing")
+	fs.Var(&diagnostics, "diagnostics", "format of errors and warnings: text, json or sarif")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), reduceUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	// inputs end at "--", or after the first argument without one
+	rest := fs.Args()
+	inputs, command := rest, []string(nil)
+	if len(rest) > 0 {
+		inputs, command = rest[:1], rest[1:]
+	}
+	for i, a := range rest {
+		if a == "--" {
+			inputs, command = rest[:i], rest[i+1:]
+			break
+		}
+	}
+	if len(inputs) == 0 || len(command) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	o := options{name: "Main"}
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		return err
+	}
+	// a command that cannot be run at all stops the reduction
+	var runErr error
+	interesting := func(files []parser.File) bool {
+		if runErr != nil {
+			return false
+		}
+		ok, err := runPredicate(files, command, *timeout)
+		if err != nil {
+			runErr = err
+		}
+		return ok
+	}
+	if !interesting(files) {
+		if runErr != nil {
+			return runErr
+		}
+		return fmt.Errorf("command does not fail on the original program: %s", strings.Join(command, " "))
+	}
+
+	reduced, stats := reducer.Reduce(files, interesting)
+	if runErr != nil {
+		return runErr
+	}
+	if err := writeProgram(*out, reduced); err != nil {
+		return err
+	}
+	log.Printf("reduced to %d files, %d commands after %d runs: %s", len(reduced), stats.Commands, stats.Tests, *out)
+	return nil
+}
+
+// runPredicate writes files to a temporary directory and reports whether
+// command exits with status 0 on it.
+func runPredicate(files []parser.File, command []string, timeout time.Duration) (bool, error) {
+	dir, err := ioutil.TempDir("", "vmt-reduce")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	if err := writeProgram(dir, files); err != nil {
+		return false, err
+	}
+
+	args := make([]string, 0, len(command)+1)
+	replaced := false
+	for _, a := range command {
+		if strings.Contains(a, "{}") {
+			a = strings.ReplaceAll(a, "{}", dir)
+			replaced = true
+		}
+		args = append(args, a)
+	}
+	if !replaced {
+		args = append(args, dir)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	err = cmd.Run()
+	if _, ok := err.(*exec.ExitError); ok || ctx.Err() != nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeProgram writes one .vm file per file into dir. The .vm files dir
+// had before are removed, so that those of an earlier, larger program do
+// not become part of this one.
+func writeProgram(dir string, files []parser.File) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	for _, f := range files {
+		vm, err := os.Create(filepath.Join(dir, f.Name+".vm"))
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteTo(vm)
+		if cerr := vm.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"vmt/parser"
+)
+
+// A second run over the same directory leaves only the files of the
+// second program behind.
+func Test_writeProgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmt-reduce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sys := parser.File{Name: "Sys", Commands: []parser.Command{{Type: parser.FUNCTION, Arg1: "Sys.init"}}}
+	main := parser.File{Name: "Main", Commands: []parser.Command{{Type: parser.RETURN}}}
+	if err := writeProgram(dir, []parser.File{sys, main}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeProgram(dir, []parser.File{sys}); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range infos {
+		got = append(got, fi.Name())
+	}
+	if want := []string{"Sys.vm", "notes.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
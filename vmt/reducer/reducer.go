@@ -0,0 +1,296 @@
+/*
+Package reducer shrinks a failing VM program to a small reproduction.
+
+Reduce applies delta debugging (ddmin) to whole files, then whole functions,
+then single commands, and repeats until no further removal keeps the problem.
+Candidates that are not well-formed are never passed to the predicate: every
+goto and if-goto must still find its label in the same function, every
+call to a function the original program defined must still find it, and
+when the stack of the original program is balanced, as analysis.CheckStack
+verifies, the stack of every candidate must be as well.
+*/
+package reducer
+
+import (
+	"vmt/analysis"
+	"vmt/parser"
+)
+
+// Predicate reports whether a candidate program still shows the problem.
+type Predicate func(files []parser.File) bool
+
+// Stats counts what Reduce did.
+type Stats struct {
+	Tests    int // predicate runs
+	Commands int // commands left
+}
+
+// Reduce returns the smallest program it finds for which interesting holds.
+// interesting must hold for files itself.
+func Reduce(files []parser.File, interesting Predicate) ([]parser.File, Stats) {
+	r := &reducer{
+		interesting: interesting,
+		defined:     definedFunctions(files),
+		balanced:    balanced(files),
+	}
+	for {
+		before := count(files)
+		files = r.files(files)
+		files = r.functions(files)
+		files = r.commands(files)
+		if count(files) == before {
+			break
+		}
+	}
+	return files, Stats{Tests: r.tests, Commands: count(files)}
+}
+
+type reducer struct {
+	interesting Predicate
+	defined     map[string]bool
+	balanced    bool // the stack of the original program is balanced
+	tests       int
+}
+
+func (r *reducer) test(files []parser.File) bool {
+	if !r.wellFormed(files) {
+		return false
+	}
+	r.tests++
+	return r.interesting(files)
+}
+
+// files removes whole files.
+func (r *reducer) files(files []parser.File) []parser.File {
+	keep := ddmin(len(files), func(keep []bool) bool {
+		return r.test(filterFiles(files, keep))
+	})
+	return filterFiles(files, keep)
+}
+
+// functions removes whole functions including their bodies.
+func (r *reducer) functions(files []parser.File) []parser.File {
+	type span struct{ file, start, end int }
+	var spans []span
+	for i, f := range files {
+		for j, c := range f.Commands {
+			if c.Type != parser.FUNCTION {
+				continue
+			}
+			end := j + 1
+			for end < len(f.Commands) && f.Commands[end].Type != parser.FUNCTION {
+				end++
+			}
+			spans = append(spans, span{i, j, end})
+		}
+	}
+	build := func(keep []bool) []parser.File {
+		drop := make([][]bool, len(files))
+		for i, f := range files {
+			drop[i] = make([]bool, len(f.Commands))
+		}
+		for i, s := range spans {
+			if keep[i] {
+				continue
+			}
+			for j := s.start; j < s.end; j++ {
+				drop[s.file][j] = true
+			}
+		}
+		return filterCommands(files, drop)
+	}
+	keep := ddmin(len(spans), func(keep []bool) bool {
+		return r.test(build(keep))
+	})
+	return build(keep)
+}
+
+// commands removes single commands other than function declarations.
+func (r *reducer) commands(files []parser.File) []parser.File {
+	type pos struct{ file, cmd int }
+	var cands []pos
+	for i, f := range files {
+		for j, c := range f.Commands {
+			if c.Type != parser.FUNCTION {
+				cands = append(cands, pos{i, j})
+			}
+		}
+	}
+	build := func(keep []bool) []parser.File {
+		drop := make([][]bool, len(files))
+		for i, f := range files {
+			drop[i] = make([]bool, len(f.Commands))
+		}
+		for i, p := range cands {
+			drop[p.file][p.cmd] = !keep[i]
+		}
+		return filterCommands(files, drop)
+	}
+	keep := ddmin(len(cands), func(keep []bool) bool {
+		return r.test(build(keep))
+	})
+	return build(keep)
+}
+
+/*
+ddmin returns a mask of the units to keep out of n.
+
+It starts by trying to drop halves of the kept units and doubles the number
+of chunks each time no chunk can be dropped, until chunks are single units.
+test must report whether the program built from a mask is still interesting.
+*/
+func ddmin(n int, test func(keep []bool) bool) []bool {
+	keep := make([]bool, n)
+	for i := range keep {
+		keep[i] = true
+	}
+	chunks := 2
+	for {
+		var kept []int
+		for i, k := range keep {
+			if k {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) == 0 {
+			return keep
+		}
+		if chunks > len(kept) {
+			chunks = len(kept)
+		}
+
+		reduced := false
+		size := (len(kept) + chunks - 1) / chunks
+		for start := 0; start < len(kept); start += size {
+			end := start + size
+			if end > len(kept) {
+				end = len(kept)
+			}
+			cand := make([]bool, n)
+			copy(cand, keep)
+			for _, i := range kept[start:end] {
+				cand[i] = false
+			}
+			if test(cand) {
+				keep = cand
+				reduced = true
+			}
+		}
+
+		switch {
+		case reduced:
+			if chunks > 2 {
+				chunks--
+			}
+		case chunks < len(kept):
+			chunks *= 2
+		default:
+			return keep
+		}
+	}
+}
+
+// wellFormed reports whether every jump finds its label in the same function,
+// every call to a function of the original program finds its target and the
+// stack is balanced when it was in the original program.
+func (r *reducer) wellFormed(files []parser.File) bool {
+	if len(files) == 0 {
+		return false
+	}
+	if r.balanced && !balanced(files) {
+		return false
+	}
+	defined := definedFunctions(files)
+	for _, f := range files {
+		labels := map[string]bool{}
+		var jumps []string
+		check := func() bool {
+			for _, j := range jumps {
+				if !labels[j] {
+					return false
+				}
+			}
+			return true
+		}
+		for _, c := range f.Commands {
+			switch c.Type {
+			case parser.FUNCTION:
+				if !check() {
+					return false
+				}
+				labels = map[string]bool{}
+				jumps = nil
+			case parser.LABEL:
+				labels[c.Arg1] = true
+			case parser.GOTO, parser.IF:
+				jumps = append(jumps, c.Arg1)
+			case parser.CALL:
+				if r.defined[c.Arg1] && !defined[c.Arg1] {
+					return false
+				}
+			}
+		}
+		if !check() {
+			return false
+		}
+	}
+	return true
+}
+
+// balanced reports whether analysis.CheckStack finds no errors in files.
+func balanced(files []parser.File) bool {
+	for _, f := range files {
+		if analysis.CheckStack(f).HasErrors() {
+			return false
+		}
+	}
+	return true
+}
+
+func definedFunctions(files []parser.File) map[string]bool {
+	defined := map[string]bool{}
+	for _, f := range files {
+		for _, c := range f.Commands {
+			if c.Type == parser.FUNCTION {
+				defined[c.Arg1] = true
+			}
+		}
+	}
+	return defined
+}
+
+// filterFiles keeps the files whose mask entry is set.
+func filterFiles(files []parser.File, keep []bool) []parser.File {
+	var out []parser.File
+	for i, f := range files {
+		if keep[i] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// filterCommands drops the marked commands and any file left empty.
+func filterCommands(files []parser.File, drop [][]bool) []parser.File {
+	var out []parser.File
+	for i, f := range files {
+		nf := parser.File{Name: f.Name, Path: f.Path}
+		for j, c := range f.Commands {
+			if !drop[i][j] {
+				nf.Commands = append(nf.Commands, c)
+			}
+		}
+		if len(nf.Commands) > 0 {
+			out = append(out, nf)
+		}
+	}
+	return out
+}
+
+func count(files []parser.File) int {
+	n := 0
+	for _, f := range files {
+		n += len(f.Commands)
+	}
+	return n
+}
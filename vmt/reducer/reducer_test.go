@@ -0,0 +1,161 @@
+package reducer
+
+import (
+	"reflect"
+	"testing"
+	"vmt/generator"
+	"vmt/parser"
+)
+
+func Test_ddmin(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		need []int
+	}{
+		{"two units", 10, []int{3, 7}},
+		{"single unit", 5, []int{0}},
+		{"all units", 4, []int{0, 1, 2, 3}},
+		{"nothing needed", 6, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ddmin(tt.n, func(keep []bool) bool {
+				for _, i := range tt.need {
+					if !keep[i] {
+						return false
+					}
+				}
+				return true
+			})
+			want := make([]bool, tt.n)
+			for _, i := range tt.need {
+				want[i] = true
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ddmin() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestReduce(t *testing.T) {
+	files := generator.Generate(7, generator.DefaultConfig)
+
+	// the "bug" shows whenever Sys.init still calls a function that negates
+	interesting := func(files []parser.File) bool {
+		called := map[string]bool{}
+		negates := map[string]bool{}
+		for _, f := range files {
+			fn := ""
+			for _, c := range f.Commands {
+				switch {
+				case c.Type == parser.FUNCTION:
+					fn = c.Arg1
+				case c.Type == parser.CALL && fn == "Sys.init":
+					called[c.Arg1] = true
+				case c.Type == parser.ARITHMETIC && c.Arg1 == "neg":
+					negates[fn] = true
+				}
+			}
+		}
+		for fn := range called {
+			if negates[fn] {
+				return true
+			}
+		}
+		return false
+	}
+	if !interesting(files) {
+		t.Fatalf("seed does not show the problem")
+	}
+
+	got, stats := Reduce(files, interesting)
+	if !interesting(got) {
+		t.Fatalf("Reduce() returned a program that is not interesting")
+	}
+	// neg keeps the push it takes its value from
+	if len(got) != 2 || stats.Commands != 5 {
+		t.Errorf("Reduce() = %v, want 2 files with 5 commands", got)
+	}
+}
+
+func TestReducer_wellFormed(t *testing.T) {
+	r := &reducer{defined: map[string]bool{"Main.f": true}, balanced: true}
+	tests := []struct {
+		name string
+		cmds []parser.Command
+		want bool
+	}{
+		{
+			"label in same function",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.f"},
+				{Type: parser.LABEL, Arg1: "L"},
+				{Type: parser.GOTO, Arg1: "L"},
+			},
+			true,
+		},
+		{
+			"label in other function",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.f"},
+				{Type: parser.LABEL, Arg1: "L"},
+				{Type: parser.FUNCTION, Arg1: "Main.g"},
+				{Type: parser.IF, Arg1: "L"},
+			},
+			false,
+		},
+		{
+			"call to removed function",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.g"},
+				{Type: parser.CALL, Arg1: "Main.f"},
+			},
+			false,
+		},
+		{
+			"call to external function",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.f"},
+				{Type: parser.CALL, Arg1: "Math.multiply"},
+			},
+			true,
+		},
+		{
+			"stack underflow",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.f"},
+				{Type: parser.PUSH, Arg1: "constant", Arg2: 1},
+				{Type: parser.ARITHMETIC, Arg1: "add"},
+			},
+			false,
+		},
+		{
+			"return without a value",
+			[]parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.f"},
+				{Type: parser.RETURN},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []parser.File{{Name: "Main", Commands: tt.cmds}}
+			if got := r.wellFormed(files); got != tt.want {
+				t.Errorf("wellFormed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a program that is not balanced in the first place can still shrink
+	r.balanced = false
+	files := []parser.File{{Name: "Main", Commands: []parser.Command{
+		{Type: parser.FUNCTION, Arg1: "Main.f"},
+		{Type: parser.RETURN},
+	}}}
+	if !r.wellFormed(files) {
+		t.Error("wellFormed() = false for an unbalanced original, want true")
+	}
+}
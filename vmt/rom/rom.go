@@ -0,0 +1,190 @@
+/*
+Package rom writes Hack machine code as ROM images for the tools that load
+it: the textual .hack format of nand2tetris, raw binary, Intel HEX, Verilog
+memory files for $readmemb and $readmemh, and Logisim memory images.
+
+Every word is 16 bits wide. Formats that address bytes store each word big
+endian, the high byte first.
+*/
+package rom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"vmt/assembler"
+)
+
+// Size is the number of words of the Hack ROM.
+const Size = 32768
+
+// Format is a ROM image format.
+type Format struct {
+	Name string
+	Ext  string // file extension, with the dot
+	Doc  string // a line for the help of -format
+
+	write func(w *bufio.Writer, code []uint16)
+}
+
+// Formats are all formats, in the order they are listed.
+var Formats = []*Format{
+	{
+		Name:  "hack",
+		Ext:   ".hack",
+		Doc:   "nand2tetris text, one 16-digit binary word per line",
+		write: writeHack,
+	},
+	{
+		Name:  "bin",
+		Ext:   ".bin",
+		Doc:   "raw binary, two bytes per word, big endian",
+		write: writeBinary,
+	},
+	{
+		Name:  "ihex",
+		Ext:   ".hex",
+		Doc:   "Intel HEX with byte addresses, 16 bytes per record, big endian words",
+		write: writeIntelHex,
+	},
+	{
+		Name:  "memb",
+		Ext:   ".memb",
+		Doc:   "Verilog $readmemb file, one binary word per line",
+		write: writeMemb,
+	},
+	{
+		Name:  "memh",
+		Ext:   ".memh",
+		Doc:   "Verilog $readmemh file, one hex word per line",
+		write: writeMemh,
+	},
+	{
+		Name:  "logisim",
+		Ext:   ".rom",
+		Doc:   `Logisim "v2.0 raw" memory image`,
+		write: writeLogisim,
+	},
+}
+
+// Lookup returns the format called name, or nil.
+func Lookup(name string) *Format {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Write writes code to w in format f. It fails when code does not fit in
+// the ROM.
+func (f *Format) Write(w io.Writer, code []uint16) error {
+	if len(code) > Size {
+		return fmt.Errorf("%d words do not fit in the %d words of ROM", len(code), Size)
+	}
+	bw := bufio.NewWriter(w)
+	f.write(bw, code)
+	return bw.Flush()
+}
+
+func writeHack(w *bufio.Writer, code []uint16) {
+	assembler.WriteHack(w, code)
+}
+
+func writeBinary(w *bufio.Writer, code []uint16) {
+	binary.Write(w, binary.BigEndian, code)
+}
+
+// ReadBinary reads a raw binary image.
+func ReadBinary(r io.Reader) ([]uint16, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("binary image of %d bytes, want whole 16-bit words", len(b))
+	}
+	code := make([]uint16, len(b)/2)
+	for i := range code {
+		code[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return code, nil
+}
+
+/*
+writeIntelHex writes data records of up to 16 bytes and the end of file
+record. The 32K words of the Hack ROM take 64K bytes, so the 16-bit
+addresses of the records reach every byte and no extended address records
+are needed.
+*/
+func writeIntelHex(w *bufio.Writer, code []uint16) {
+	const perRecord = 8 // words
+	for start := 0; start < len(code); start += perRecord {
+		end := start + perRecord
+		if end > len(code) {
+			end = len(code)
+		}
+		addr := 2 * start
+		rec := []byte{byte(2 * (end - start)), byte(addr >> 8), byte(addr), 0}
+		for _, c := range code[start:end] {
+			rec = append(rec, byte(c>>8), byte(c))
+		}
+		writeRecord(w, rec)
+	}
+	writeRecord(w, []byte{0, 0, 0, 1})
+}
+
+// writeRecord writes rec, the byte count, address, type and data of a
+// record, with its checksum.
+func writeRecord(w *bufio.Writer, rec []byte) {
+	var sum byte
+	w.WriteByte(':')
+	for _, b := range rec {
+		fmt.Fprintf(w, "%02X", b)
+		sum += b
+	}
+	fmt.Fprintf(w, "%02X\n", -sum)
+}
+
+func writeMemb(w *bufio.Writer, code []uint16) {
+	fmt.Fprintf(w, "// %d words\n", len(code))
+	for _, c := range code {
+		fmt.Fprintf(w, "%016b\n", c)
+	}
+}
+
+func writeMemh(w *bufio.Writer, code []uint16) {
+	fmt.Fprintf(w, "// %d words\n", len(code))
+	for _, c := range code {
+		fmt.Fprintf(w, "%04x\n", c)
+	}
+}
+
+// writeLogisim writes 8 values per line and runs of 4 or more equal words
+// as "count*value", as Logisim does itself.
+func writeLogisim(w *bufio.Writer, code []uint16) {
+	w.WriteString("v2.0 raw\n")
+	n := 0
+	for i := 0; i < len(code); {
+		run := 1
+		for i+run < len(code) && code[i+run] == code[i] {
+			run++
+		}
+		if run < 4 {
+			run = 1
+			fmt.Fprintf(w, "%x", code[i])
+		} else {
+			fmt.Fprintf(w, "%d*%x", run, code[i])
+		}
+		i += run
+		n++
+		if n%8 == 0 || i == len(code) {
+			w.WriteByte('\n')
+		} else {
+			w.WriteByte(' ')
+		}
+	}
+}
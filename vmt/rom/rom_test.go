@@ -0,0 +1,111 @@
+package rom
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormat_Write(t *testing.T) {
+	code := []uint16{0x0002, 0xEA87}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"hack", "0000000000000010\n1110101010000111\n"},
+		{"bin", "\x00\x02\xEA\x87"},
+		{"ihex", ":040000000002EA8789\n:00000001FF\n"},
+		{"memb", "// 2 words\n0000000000000010\n1110101010000111\n"},
+		{"memh", "// 2 words\n0002\nea87\n"},
+		{"logisim", "v2.0 raw\n2 ea87\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			f := Lookup(tt.format)
+			if f == nil {
+				t.Fatalf("Lookup(%s) = nil", tt.format)
+			}
+			b := bytes.NewBufferString("")
+			if err := f.Write(b, code); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Write() = %q, want %q", b, tt.want)
+			}
+		})
+	}
+	if Lookup("srec") != nil {
+		t.Error("Lookup(srec) != nil")
+	}
+
+	// Intel HEX addresses would wrap around past the ROM
+	for _, f := range Formats {
+		if err := f.Write(bytes.NewBufferString(""), make([]uint16, Size+1)); err == nil {
+			t.Errorf("%s: Write() of %d words succeeded", f.Name, Size+1)
+		}
+	}
+}
+
+func TestWriteIntelHex(t *testing.T) {
+	code := make([]uint16, 20)
+	for i := range code {
+		code[i] = uint16(0x1111 * i)
+	}
+	b := bytes.NewBufferString("")
+	if err := Lookup("ihex").Write(b, code); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Write() = %d records, want 4:\n%s", len(lines), b)
+	}
+	for i, want := range []string{":10000000", ":10001000", ":08002000", ":00000001"} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("record %d = %s, want %s...", i, lines[i], want)
+		}
+	}
+	for _, l := range lines {
+		var sum byte
+		for i := 1; i < len(l); i += 2 {
+			var v byte
+			for _, c := range l[i : i+2] {
+				v = v<<4 | byte(strings.IndexRune("0123456789ABCDEF", c))
+			}
+			sum += v
+		}
+		if sum != 0 {
+			t.Errorf("record %s has a bad checksum", l)
+		}
+	}
+}
+
+func TestWriteLogisim(t *testing.T) {
+	code := []uint16{1, 0, 0, 0, 0, 0, 2, 2, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	b := bytes.NewBufferString("")
+	if err := Lookup("logisim").Write(b, code); err != nil {
+		t.Fatal(err)
+	}
+	want := "v2.0 raw\n1 5*0 2 2 2 3 4 5\n6 7 8 9 a\n"
+	if b.String() != want {
+		t.Errorf("Write() = %q, want %q", b, want)
+	}
+}
+
+func TestReadBinary(t *testing.T) {
+	code := []uint16{0, 0x7FFF, 0xEA87, 0xFFFF}
+	b := bytes.NewBufferString("")
+	if err := Lookup("bin").Write(b, code); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, code) {
+		t.Errorf("ReadBinary() = %v, want %v", got, code)
+	}
+	if _, err := ReadBinary(strings.NewReader("\x00\x01\x02")); err == nil {
+		t.Error("ReadBinary() accepted an odd number of bytes")
+	}
+}
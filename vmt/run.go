@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"vmt/emulator"
+)
+
+func run(args []string) error {
+	var o options
+	fs := newFlagSet("run", "[flags] {hack file | asm file | vm file, directory, glob pattern or - ...}")
+	o.register(fs)
+	cycles := fs.Int("cycles", 1000000, "stop after this many instructions")
+	ram := fs.String("ram", "0-4", "comma separated RAM addresses and ranges to print, e.g. 0-4,256-260")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+	addrs, err := parseRanges(*ram)
+	if err != nil {
+		return err
+	}
+
+	code, err := o.load(inputs)
+	if err != nil {
+		return err
+	}
+	cpu := emulator.New(code)
+	err = cpu.Run(*cycles)
+	for _, a := range addrs {
+		fmt.Printf("RAM[%d]\t%d\n", a, int16(cpu.RAM[a]))
+	}
+	if err != nil {
+		return err
+	}
+	if cpu.Halted {
+		log.Printf("halted after %d cycles", cpu.Cycles)
+	} else {
+		log.Printf("stopped after %d cycles", cpu.Cycles)
+	}
+	return nil
+}
+
+var (
+	tstSet    = regexp.MustCompile(`set\s+RAM\[(\d+)\]\s*,?\s+(-?\d+)`)
+	tstRepeat = regexp.MustCompile(`repeat\s+(\d+)`)
+	cmpRAM    = regexp.MustCompile(`^RAM\[(\d+)\]$`)
+)
+
+func test(args []string) error {
+	var o options
+	fs := newFlagSet("test", "[flags] {vm file or directory}")
+	o.register(fs)
+	cmpFile := fs.String("cmp", "", "expected RAM in nand2tetris .cmp format (default {name}.cmp next to the input)")
+	tstFile := fs.String("tst", "", "nand2tetris test script for the initial RAM and the cycle count (default {name}.tst, if present)")
+	cycles := fs.Int("cycles", 0, "stop after this many instructions (default the repeat count of the .tst, or 1000000)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	input := fs.Arg(0)
+
+	// nand2tetris keeps Foo.cmp and Foo.tst in the directory Foo or next to Foo.vm
+	dir, name := filepath.Dir(input), strings.TrimSuffix(filepath.Base(input), ".vm")
+	if fInfo, err := os.Stat(input); err == nil && fInfo.IsDir() {
+		dir = input
+	}
+	if *cmpFile == "" {
+		*cmpFile = filepath.Join(dir, name+".cmp")
+	}
+	if *tstFile == "" {
+		if _, err := os.Stat(filepath.Join(dir, name+".tst")); err == nil {
+			*tstFile = filepath.Join(dir, name+".tst")
+		}
+	}
+
+	want, err := readCmp(*cmpFile)
+	if err != nil {
+		return err
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+	code, err := o.load(inputs)
+	if err != nil {
+		return err
+	}
+	cpu := emulator.New(code)
+	limit := 1000000
+	if *tstFile != "" {
+		tst, err := ioutil.ReadFile(*tstFile)
+		if err != nil {
+			return err
+		}
+		for _, m := range tstSet.FindAllStringSubmatch(string(tst), -1) {
+			addr, _ := strconv.Atoi(m[1])
+			v, _ := strconv.Atoi(m[2])
+			if addr < emulator.RAMSize {
+				cpu.RAM[addr] = uint16(v)
+			}
+		}
+		if m := tstRepeat.FindStringSubmatch(string(tst)); m != nil {
+			limit, _ = strconv.Atoi(m[1])
+		}
+	}
+	if *cycles > 0 {
+		limit = *cycles
+	}
+	if err := cpu.Run(limit); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, w := range want {
+		if got := int16(cpu.RAM[w.addr]); got != w.value {
+			fmt.Printf("RAM[%d] = %d, want %d\n", w.addr, got, w.value)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("FAIL %s: %d of %d values differ after %d cycles", name, failed, len(want), cpu.Cycles)
+	}
+	log.Printf("PASS %s: %d values after %d cycles", name, len(want), cpu.Cycles)
+	return nil
+}
+
+type ramValue struct {
+	addr  int
+	value int16
+}
+
+// readCmp reads the RAM columns of the last row of a .cmp file such as
+//
+//	|RAM[0] |RAM[256]|
+//	|    257|      91|
+func readCmp(name string) ([]ramValue, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var header, row []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			continue
+		}
+		cells := strings.Split(strings.Trim(line, "|"), "|")
+		for i := range cells {
+			cells[i] = strings.TrimSpace(cells[i])
+		}
+		if header == nil {
+			header = cells
+			continue
+		}
+		row = cells
+	}
+	if row == nil {
+		return nil, fmt.Errorf("%s: no values", name)
+	}
+
+	var values []ramValue
+	for i, h := range header {
+		m := cmpRAM.FindStringSubmatch(h)
+		if m == nil || i >= len(row) {
+			continue
+		}
+		addr, _ := strconv.Atoi(m[1])
+		v, err := strconv.Atoi(row[i])
+		if err != nil || addr >= emulator.RAMSize {
+			return nil, fmt.Errorf("%s: invalid value %q for %s", name, row[i], h)
+		}
+		values = append(values, ramValue{addr, int16(v)})
+	}
+	return values, nil
+}
+
+// parseRanges parses a list such as "0-4,256,300-302".
+func parseRanges(spec string) ([]int, error) {
+	var addrs []int
+	for _, r := range strings.Split(spec, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i >= 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		l, err1 := strconv.Atoi(lo)
+		h, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || l < 0 || h < l || h >= emulator.RAMSize {
+			return nil, usageErrorf("invalid RAM range %q", r)
+		}
+		for a := l; a <= h; a++ {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs, nil
+}
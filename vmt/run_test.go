@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_parseRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []int
+		wantErr bool
+	}{
+		{"single", "0", []int{0}, false},
+		{"ranges", "0-2, 256-257", []int{0, 1, 2, 256, 257}, false},
+		{"reversed", "4-2", nil, true},
+		{"outside RAM", "32767-32768", nil, true},
+		{"not a number", "SP", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRanges() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readCmp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "StaticsTest.cmp")
+	cmp := "|  RAM[0]  | RAM[261] | RAM[262] |\n|    263   |    -2    |    8     |\n"
+	if err := ioutil.WriteFile(name, []byte(cmp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCmp(name)
+	if err != nil {
+		t.Fatalf("readCmp() error = %v", err)
+	}
+	want := []ramValue{{0, 263}, {261, -2}, {262, 8}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCmp() = %v, want %v", got, want)
+	}
+}
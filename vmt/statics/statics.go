@@ -0,0 +1,204 @@
+/*
+Package statics allocates the static variables of a whole program.
+
+The translator names static i of file Foo.vm "Foo.i" and leaves the
+allocation to the Hack assembler, which places every symbol that is not a
+label at RAM 16, 17, ... in order of first appearance. Only RAM 16-255 is
+reserved for statics; anything beyond silently overlaps the stack at 256.
+Allocate predicts the assembler's choice before any code is generated and
+reports programs that would overflow or whose files would share statics.
+*/
+package statics
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+// RAM reserved for static variables.
+const (
+	Base  = 16
+	Top   = 255
+	Slots = Top - Base + 1
+)
+
+// Static is a static variable and the RAM address the assembler gives it.
+type Static struct {
+	File    string // base name of the file, e.g. "Main"
+	Index   int
+	Symbol  string
+	Address int
+}
+
+// Variable is any other symbol the assembler allocates in RAM.
+type Variable struct {
+	Symbol  string
+	Address int
+}
+
+// Allocation is the static memory layout of a program.
+type Allocation struct {
+	Statics []Static // in RAM order
+	// Unresolved are symbols that are neither labels nor statics, such as
+	// calls to functions missing from the program. The assembler allocates
+	// them as variables too, so they take static slots.
+	Unresolved []Variable
+}
+
+var staticSym = regexp.MustCompile(`^(.+)\.([0-9]+)$`)
+
+// Layout places symbols, the symbols of a program that are not labels in
+// order of first appearance, the way the assembler does: one after the
+// other from RAM 16 on, the static variables "File.i" and the unresolved
+// symbols alike. It fails when they do not fit in RAM 16-255.
+func Layout(symbols []string) (*Allocation, error) {
+	a := &Allocation{}
+	for i, sym := range symbols {
+		if m := staticSym.FindStringSubmatch(sym); m != nil {
+			index, _ := strconv.Atoi(m[2])
+			a.Statics = append(a.Statics, Static{File: m[1], Index: index, Symbol: sym, Address: Base + i})
+			continue
+		}
+		a.Unresolved = append(a.Unresolved, Variable{Symbol: sym, Address: Base + i})
+	}
+	if used := len(symbols); used > Slots {
+		return a, diag.Errorf("static-overflow", "program needs %d static variables, RAM %d-%d holds %d: RAM %d-%d would overwrite the stack",
+			used, Base, Top, Slots, Top+1, Base+used-1)
+	}
+	return a, nil
+}
+
+// Allocate lays out the statics of files in the order the translator writes
+// them, after the bootstrap code. It fails when the statics do not fit in RAM
+// 16-255 or when two files have the same base name.
+func Allocate(files []parser.File) (*Allocation, error) {
+	return AllocateEntry(files, "Sys.init")
+}
+
+// AllocateEntry is Allocate for bootstrap code that calls entry instead of
+// Sys.init. An empty entry means there is no bootstrap code.
+func AllocateEntry(files []parser.File, entry string) (*Allocation, error) {
+	if err := checkNames(files); err != nil {
+		return nil, err
+	}
+
+	defined := map[string]bool{}
+	for _, f := range files {
+		function := ""
+		for _, c := range f.Commands {
+			switch c.Type {
+			case parser.FUNCTION:
+				function = c.Arg1
+				defined[c.Arg1] = true
+			case parser.LABEL:
+				defined[scope(f.Name, function)+"$"+c.Arg1] = true
+			}
+		}
+	}
+
+	// the symbols in order, with the command that uses each first
+	var symbols []string
+	var first []*diag.Diagnostic
+	seen := map[string]bool{}
+	use := func(symbol string, f parser.File, c parser.Command) {
+		if seen[symbol] || defined[symbol] {
+			return
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+		first = append(first, &diag.Diagnostic{File: path(f), Line: c.Line})
+	}
+	if entry != "" {
+		use(entry, parser.File{}, parser.Command{})
+	}
+	for _, f := range files {
+		function := ""
+		for _, c := range f.Commands {
+			switch c.Type {
+			case parser.FUNCTION:
+				function = c.Arg1
+			case parser.PUSH, parser.POP:
+				if c.Arg1 == "static" {
+					use(fmt.Sprintf("%s.%d", f.Name, c.Arg2), f, c)
+				}
+			case parser.CALL:
+				use(c.Arg1, f, c)
+			case parser.GOTO, parser.IF:
+				use(scope(f.Name, function)+"$"+c.Arg1, f, c)
+			}
+		}
+	}
+
+	a, err := Layout(symbols)
+	if d, ok := err.(*diag.Diagnostic); ok {
+		// at the command that allocates the first symbol past Top
+		if at := first[Slots]; at.Line > 0 {
+			d.At(at.File, at.Line, 0)
+		}
+	}
+	return a, err
+}
+
+// scope mirrors the label scoping of the code writer.
+func scope(file, function string) string {
+	if function != "" {
+		return function
+	}
+	return file
+}
+
+// checkNames fails when two files have the same base name, since their
+// "Name.i" statics would refer to the same variables and the labels the
+// translator numbers per file, such as Name$cmp$1, would be defined twice.
+func checkNames(files []parser.File) error {
+	first := map[string]parser.File{}
+	for _, f := range files {
+		if prev, ok := first[f.Name]; ok {
+			return diag.Errorf("duplicate-file", "%s and %s are both named %s.vm: their statics and generated labels would clash",
+				path(prev), path(f), f.Name).At(path(f), 0, 0)
+		}
+		first[f.Name] = f
+	}
+	return nil
+}
+
+func path(f parser.File) string {
+	if f.Path != "" {
+		return f.Path
+	}
+	return f.Name + ".vm"
+}
+
+// WriteTo writes the static map, grouped by file in allocation order.
+func (a *Allocation) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	var files []string
+	byFile := map[string][]Static{}
+	for _, s := range a.Statics {
+		if _, ok := byFile[s.File]; !ok {
+			files = append(files, s.File)
+		}
+		byFile[s.File] = append(byFile[s.File], s)
+	}
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s.vm\t\t\n", f)
+		for _, s := range byFile[f] {
+			fmt.Fprintf(tw, "  static %d\t%s\tRAM[%d]\n", s.Index, s.Symbol, s.Address)
+		}
+	}
+	for _, u := range a.Unresolved {
+		fmt.Fprintf(tw, "unresolved\t%s\tRAM[%d]\n", u.Symbol, u.Address)
+	}
+	used := len(a.Statics) + len(a.Unresolved)
+	fmt.Fprintf(tw, "total\t%d of %d slots\t\n", used, Slots)
+	tw.Flush()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
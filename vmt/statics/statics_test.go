@@ -0,0 +1,166 @@
+package statics
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"vmt/diag"
+	"vmt/parser"
+)
+
+func static(cmd parser.Type, index int) parser.Command {
+	return parser.Command{Type: cmd, Arg1: "static", Arg2: index}
+}
+
+func TestAllocate(t *testing.T) {
+	files := []parser.File{
+		{
+			Name: "Main",
+			Commands: []parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Main.main"},
+				static(parser.PUSH, 2),
+				{Type: parser.CALL, Arg1: "Output.printInt", Arg2: 1},
+				static(parser.POP, 0),
+				static(parser.PUSH, 2),
+				{Type: parser.RETURN},
+			},
+		},
+		{
+			Name: "Sys",
+			Commands: []parser.Command{
+				{Type: parser.FUNCTION, Arg1: "Sys.init"},
+				{Type: parser.CALL, Arg1: "Main.main"},
+				static(parser.POP, 0),
+				{Type: parser.LABEL, Arg1: "END"},
+				{Type: parser.GOTO, Arg1: "END"},
+			},
+		},
+	}
+	got, err := Allocate(files)
+	if err != nil {
+		t.Fatalf("Allocate() error = %v", err)
+	}
+	want := &Allocation{
+		Statics: []Static{
+			{File: "Main", Index: 2, Symbol: "Main.2", Address: 16},
+			{File: "Main", Index: 0, Symbol: "Main.0", Address: 18},
+			{File: "Sys", Index: 0, Symbol: "Sys.0", Address: 19},
+		},
+		Unresolved: []Variable{{Symbol: "Output.printInt", Address: 17}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Allocate() = %+v, want %+v", got, want)
+	}
+
+	b := bytes.NewBufferString("")
+	got.WriteTo(b)
+	for _, s := range []string{"Main.vm", "static 2", "RAM[16]", "unresolved", "4 of 240"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("WriteTo() does not contain %q:\n%s", s, b)
+		}
+	}
+}
+
+func TestAllocate_overflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		wantErr bool
+	}{
+		{"fits", Slots - 1, false}, // Sys.init is unresolved and takes a slot
+		{"overflows", Slots, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parser.File{Name: "Big"}
+			for i := 0; i < tt.count; i++ {
+				c := static(parser.PUSH, i)
+				c.Line = i + 1
+				f.Commands = append(f.Commands, c)
+			}
+			_, err := Allocate([]parser.File{f})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var d *diag.Diagnostic
+			if err != nil && (!errors.As(err, &d) || d.Rule != "static-overflow" || d.Line != Slots) {
+				t.Errorf("Allocate() error = %#v, want a static-overflow diagnostic at line %d", err, Slots)
+			}
+		})
+	}
+}
+
+func TestAllocateEntry(t *testing.T) {
+	f := parser.File{Name: "Main", Commands: []parser.Command{static(parser.PUSH, 0)}}
+	tests := []struct {
+		name  string
+		entry string
+		want  int
+	}{
+		{"Sys.init", "Sys.init", 17},
+		{"other entry", "Main.main", 17},
+		{"no bootstrap", "", 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AllocateEntry([]parser.File{f}, tt.entry)
+			if err != nil {
+				t.Fatalf("AllocateEntry() error = %v", err)
+			}
+			if got.Statics[0].Address != tt.want {
+				t.Errorf("AllocateEntry() Main.0 at %d, want %d", got.Statics[0].Address, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocate_sameName(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []parser.File
+		wantErr bool
+	}{
+		{
+			"both use statics",
+			[]parser.File{
+				{Name: "Foo", Path: "a/Foo.vm", Commands: []parser.Command{static(parser.PUSH, 0)}},
+				{Name: "Foo", Path: "b/Foo.vm", Commands: []parser.Command{static(parser.POP, 1)}},
+			},
+			true,
+		},
+		{
+			// the files would still define the same Foo$cmp$0
+			"neither uses statics",
+			[]parser.File{
+				{Name: "Foo", Path: "a/Foo.vm", Commands: []parser.Command{{Type: parser.ARITHMETIC, Arg1: "eq"}}},
+				{Name: "Foo", Path: "b/Foo.vm", Commands: []parser.Command{{Type: parser.ARITHMETIC, Arg1: "eq"}}},
+			},
+			true,
+		},
+		{
+			"different names",
+			[]parser.File{
+				{Name: "Foo", Path: "a/Foo.vm", Commands: []parser.Command{static(parser.PUSH, 0)}},
+				{Name: "Bar", Path: "a/Bar.vm", Commands: []parser.Command{static(parser.POP, 1)}},
+			},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Allocate(tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Allocate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "a/Foo.vm and b/Foo.vm") {
+				t.Errorf("Allocate() error = %v, want both paths", err)
+			}
+			var d *diag.Diagnostic
+			if err != nil && (!errors.As(err, &d) || d.Rule != "duplicate-file" || d.File != "b/Foo.vm") {
+				t.Errorf("Allocate() error = %#v, want a duplicate-file diagnostic in b/Foo.vm", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"vmt/parser"
+)
+
+func stats(args []string) error {
+	var o options
+	fs := newFlagSet("stats", "[flags] {vm file, directory, glob pattern or -} ...")
+	o.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		return err
+	}
+	_, cw, err := o.translate(files)
+	if cw == nil {
+		return err
+	}
+
+	// VM commands per function, in the order the functions are written
+	vmcmds := map[string]int{}
+	for _, f := range files {
+		fn := ""
+		for _, c := range f.Commands {
+			if c.Type == parser.FUNCTION {
+				fn = c.Arg1
+			}
+			vmcmds[fn]++
+		}
+	}
+
+	lm := cw.LinkMap()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "function\tfile\tvm\tasm\t")
+	fmt.Fprintf(tw, "(bootstrap)\t\t\t%d\t\n", lm.Bootstrap)
+	total, rest := 0, lm.Instructions-lm.Bootstrap
+	for _, f := range lm.Functions {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t\n", f.Name, f.File, vmcmds[f.Name], f.Size)
+		total += vmcmds[f.Name]
+		rest -= f.Size
+	}
+	if n := vmcmds[""]; n > 0 {
+		fmt.Fprintf(tw, "(outside functions)\t\t%d\t%d\t\n", n, rest)
+		total += n
+	}
+	fmt.Fprintf(tw, "total\t%d files\t%d\t%d\t\n", len(files), total, lm.Instructions)
+	fmt.Fprintf(tw, "statics\t\t\t%d\t\n", len(lm.Statics))
+	tw.Flush()
+	return err
+}
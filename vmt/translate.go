@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+	"vmt/cache"
+	"vmt/cgen"
+	"vmt/codewriter"
+	"vmt/gogen"
+	"vmt/statics"
+	"vmt/x86gen"
+)
+
+// target is a language translate writes.
+type target struct {
+	name string
+	ext  string
+	// backend returns the backend for the target, nil for Hack assembly,
+	// which the CodeWriter writes with its maps.
+	backend func(o *options, w io.Writer, opt codewriter.Options) codewriter.Backend
+}
+
+var targets = []target{
+	{"hack", ".asm", nil},
+	{"c", ".c", func(o *options, w io.Writer, opt codewriter.Options) codewriter.Backend {
+		return cgen.New(w, opt)
+	}},
+	{"go", ".go", func(o *options, w io.Writer, opt codewriter.Options) codewriter.Backend {
+		g := gogen.New(w, opt)
+		g.Package = o.pkg
+		return g
+	}},
+	{"x86-64", ".s", func(o *options, w io.Writer, opt codewriter.Options) codewriter.Backend {
+		return x86gen.New(w, opt)
+	}},
+}
+
+func lookupTarget(name string) *target {
+	for i := range targets {
+		if targets[i].name == name {
+			return &targets[i]
+		}
+	}
+	return nil
+}
+
+func translate(args []string) error {
+	var o options
+	fs := newFlagSet("translate", "[flags] {vm file, directory, glob pattern or -} ...")
+	o.register(fs)
+	o.registerOutput(fs)
+	sourcemap := fs.Bool("sourcemap", false, "also write a JSON source map to {name}.sourcemap.json")
+	linkmap := fs.Bool("map", false, "also write a link map with ROM and RAM addresses to {name}.map")
+	staticmap := fs.Bool("statics", false, "print the RAM address of every static variable")
+	watching := fs.Bool("watch", false, "keep running and translate again whenever an input changes")
+	interval := fs.Duration("interval", 500*time.Millisecond, "how often -watch looks for changes")
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.name)
+	}
+	lang := fs.String("target", "hack", "language to translate to: "+strings.Join(names, ", "))
+	fs.StringVar(&o.pkg, "package", "main", "package of the code of -target go; main has a main function that runs the program")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	t := lookupTarget(*lang)
+	if t == nil {
+		return usageErrorf("unknown target %q", *lang)
+	}
+	if t.backend != nil && (*sourcemap || *linkmap) {
+		return usageErrorf("-sourcemap and -map need -target hack")
+	}
+	inputs, err := o.inputs(fs)
+	if err != nil {
+		return err
+	}
+
+	name, err := o.outputName(inputs, t.ext)
+	if err != nil {
+		return err
+	}
+	if name == stdio && (*sourcemap || *linkmap) {
+		return usageErrorf("-sourcemap and -map need an output file")
+	}
+
+	if !*watching {
+		return translateTo(&o, t, inputs, name, *sourcemap, *linkmap, *staticmap)
+	}
+	for _, input := range inputs {
+		if input == stdio {
+			return usageErrorf("-watch cannot read standard input")
+		}
+	}
+	if name == stdio {
+		return usageErrorf("-watch needs an output file")
+	}
+	if o.cacheDir == "off" {
+		o.fragments = cache.NewMemory()
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	return watch(o.watched(inputs), *interval, stop, func() {
+		report(os.Stderr, translateTo(&o, t, inputs, name, *sourcemap, *linkmap, *staticmap))
+	})
+}
+
+// translateTo translates inputs for t to name and writes the maps asked for.
+func translateTo(o *options, t *target, inputs []string, name string, sourcemap, linkmap, staticmap bool) error {
+	// read vm and allocate statics
+	files, err := o.readProgram(inputs)
+	if err != nil {
+		return err
+	}
+	if staticmap {
+		var report io.Writer = os.Stdout
+		if name == stdio {
+			report = os.Stderr
+		}
+		if alloc, _ := statics.AllocateEntry(files, o.bootstrapEntry()); alloc != nil {
+			alloc.WriteTo(report)
+		}
+	}
+
+	if t.backend != nil {
+		out, err := o.translateBackend(files, t.backend)
+		if err != nil {
+			return err
+		}
+		if err := writeOutput(name, bytes.NewBuffer(out)); err != nil {
+			return err
+		}
+		if name != stdio {
+			log.Println("translated vm to " + t.name + ": " + name)
+		}
+		return nil
+	}
+
+	// generate asm
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	asm, cw, err := o.translate(files)
+	if cw != nil && linkmap {
+		if err := writeOutput(base+".map", cw.LinkMap()); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(name, bytes.NewBuffer(asm)); err != nil {
+		return err
+	}
+	if sourcemap {
+		if err := writeOutput(base+".sourcemap.json", cw.SourceMap()); err != nil {
+			return err
+		}
+	}
+	if name == stdio {
+		return nil
+	}
+	cached := ""
+	if o.reused > 0 {
+		cached = fmt.Sprintf(" (%d of %d files unchanged)", o.reused, len(files))
+	}
+	if len(files) > 1 {
+		log.Println("translated multiple vm: " + name + cached)
+		return nil
+	}
+	log.Println("translated vm: " + name + cached)
+	return nil
+}
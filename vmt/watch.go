@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watched returns glob patterns for the files the program of inputs is read
+// from: the inputs and the .vm files of input and library directories.
+// Patterns are matched again on every poll, so that added and deleted files
+// are noticed.
+func (o *options) watched(inputs []string) []string {
+	var patterns []string
+	for _, input := range inputs {
+		if fInfo, err := os.Stat(input); err == nil && fInfo.IsDir() {
+			input = filepath.Join(input, "*.vm")
+		}
+		patterns = append(patterns, input)
+	}
+	for _, lib := range o.libraries {
+		patterns = append(patterns, filepath.Join(lib, "*.vm"))
+	}
+	return patterns
+}
+
+// fileState is what a poll knows about a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot returns the state of every file matching patterns.
+func snapshot(patterns []string) map[string]fileState {
+	files := map[string]fileState{}
+	for _, p := range patterns {
+		matches, _ := filepath.Glob(p)
+		for _, m := range matches {
+			if fInfo, err := os.Stat(m); err == nil && !fInfo.IsDir() {
+				files[m] = fileState{fInfo.ModTime(), fInfo.Size()}
+			}
+		}
+	}
+	return files
+}
+
+// changed returns the files added, deleted or modified between two
+// snapshots, sorted.
+func changed(before, after map[string]fileState) []string {
+	var names []string
+	for name, s := range after {
+		if b, ok := before[name]; !ok || b != s {
+			names = append(names, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+watch calls build once and again whenever a file matching patterns is
+modified, added or deleted, until stop receives.
+
+The files are polled every interval. Editors and compilers often write
+several files in a row, so after a change watch waits until a poll sees no
+further change before it builds.
+*/
+func watch(patterns []string, interval time.Duration, stop <-chan os.Signal, build func()) error {
+	prev := snapshot(patterns)
+	build()
+	log.Printf("watching %s", strings.Join(patterns, ", "))
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-tick.C:
+		}
+		cur := snapshot(patterns)
+		names := changed(prev, cur)
+		if len(names) == 0 {
+			continue
+		}
+		// debounce: wait for a quiet poll
+		for quiet := false; !quiet; {
+			select {
+			case <-stop:
+				return nil
+			case <-tick.C:
+			}
+			next := snapshot(patterns)
+			quiet = len(changed(cur, next)) == 0
+			cur = next
+		}
+		names = changed(prev, cur)
+		prev = cur
+		if len(names) == 0 {
+			continue
+		}
+		log.Printf("changed: %s", describe(names))
+		build()
+	}
+}
+
+// describe lists a few names and counts the rest.
+func describe(names []string) string {
+	const max = 3
+	if len(names) <= max {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:max], ", "), len(names)-max)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_changed(t *testing.T) {
+	now := time.Now()
+	before := map[string]fileState{
+		"a.vm": {now, 1},
+		"b.vm": {now, 2},
+		"c.vm": {now, 3},
+	}
+	after := map[string]fileState{
+		"a.vm": {now, 1},
+		"b.vm": {now.Add(time.Second), 2},
+		"d.vm": {now, 4},
+	}
+	want := []string{"b.vm", "c.vm", "d.vm"}
+	if got := changed(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("changed() = %v, want %v", got, want)
+	}
+	if got := changed(after, after); len(got) != 0 {
+		t.Errorf("changed() = %v, want none", got)
+	}
+}
+
+func Test_watch(t *testing.T) {
+	dir := testProgram(t, map[string]string{"Prog/Main.vm": "push constant 1\n"})
+	defer os.RemoveAll(dir)
+	prog := filepath.Join(dir, "Prog")
+
+	var builds int32
+	stop := make(chan os.Signal)
+	done := make(chan error)
+	o := &options{}
+	go func() {
+		done <- watch(o.watched([]string{prog}), 10*time.Millisecond, stop, func() {
+			atomic.AddInt32(&builds, 1)
+		})
+	}()
+
+	// waitFor waits until build ran n times
+	waitFor := func(n int32, what string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if atomic.LoadInt32(&builds) >= n {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("%s: %d builds, want %d", what, atomic.LoadInt32(&builds), n)
+	}
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(filepath.Join(prog, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(1, "start")
+	write("Main.vm", "push constant 2\npush constant 3\n")
+	waitFor(2, "modify")
+	write("Sys.vm", "function Sys.init 0\n")
+	waitFor(3, "add")
+	if err := os.Remove(filepath.Join(prog, "Sys.vm")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(4, "delete")
+	write("README", "not a vm file")
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&builds); n != 4 {
+		t.Errorf("other file: %d builds, want 4", n)
+	}
+
+	stop <- os.Interrupt
+	if err := <-done; err != nil {
+		t.Errorf("watch() error = %v", err)
+	}
+}
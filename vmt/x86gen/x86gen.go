@@ -0,0 +1,443 @@
+/*
+Package x86gen translates VM code into x86-64 assembly for the GNU assembler,
+which links into a static Linux executable without libc.
+
+The Hack RAM is an array of 32768 16-bit words in .bss, whose address %rbx
+holds throughout; the stack and segment pointers are in RAM 0-4, where the
+assembly of the codewriter package keeps them. All arithmetic uses 16-bit
+instructions, so it wraps around like on the Hack CPU. Calls push the ROM
+address the Hack code would return to and returns jump back by comparing
+with those addresses, so a program leaves the same RAM behind as on the
+Hack CPU emulator.
+
+The executable sets RAM from its arguments such as 0=256, runs the program
+until it reaches the loop the emulator halts at, label X followed by goto X,
+or the end of its code, and prints the RAM words that are not 0 before it
+exits.
+
+	as -o prog.o prog.s && ld -o prog prog.o
+*/
+package x86gen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"vmt/codewriter"
+	"vmt/parser"
+)
+
+// Writer translates VM commands into x86-64 assembly. It implements
+// codewriter.Backend.
+type Writer struct {
+	codewriter.Native
+	w io.Writer
+}
+
+// New returns a Writer that writes assembly to w when it is closed, with
+// the Options of codewriter.Native.Init.
+func New(w io.Writer, opt codewriter.Options) *Writer {
+	cw := &Writer{w: w}
+	cw.Init(cw, opt)
+	return cw
+}
+
+func (cw *Writer) Comment(text string) string {
+	return "# " + text
+}
+
+func (cw *Writer) Bootstrap() {
+	cw.Printf("movw $256, (%%rbx)")
+}
+
+// operators compute x op y into %cx, with x in %cx and y in %dx.
+var operators = map[string]string{"add": "addw", "sub": "subw", "and": "andw", "or": "orw"}
+
+// conditions set %al when x compares to y; they test the difference the way
+// the Hack code does, after testw has cleared the overflow flag.
+var conditions = map[string]string{"eq": "sete", "gt": "setg", "lt": "sets"}
+
+func (cw *Writer) Arithmetic(cmd string) {
+	cw.Printf("# %s", cmd)
+	switch cmd {
+	case "neg":
+		cw.Printf("call vm_pop")
+		cw.Printf("negw %%cx")
+	case "not":
+		cw.Printf("call vm_pop")
+		cw.Printf("notw %%cx")
+	case "eq", "gt", "lt":
+		cw.Printf("call vm_pop")
+		cw.Printf("movw %%cx, %%dx")
+		cw.Printf("call vm_pop")
+		cw.Printf("subw %%dx, %%cx")
+		cw.Printf("testw %%cx, %%cx")
+		cw.Printf("%s %%al", conditions[cmd])
+		cw.Printf("movzbw %%al, %%cx")
+		cw.Printf("negw %%cx")
+	default:
+		cw.Printf("call vm_pop")
+		cw.Printf("movw %%cx, %%dx")
+		cw.Printf("call vm_pop")
+		cw.Printf("%s %%dx, %%cx", operators[cmd])
+	}
+	cw.Printf("call vm_push")
+}
+
+// segments are the byte offsets in RAM of the segment pointers.
+var segments = map[string]int{"local": 2, "argument": 4, "this": 6, "that": 8}
+
+func (cw *Writer) PushPop(cmd parser.Type, segment string, index int) {
+	// the address of the word, for the segments that are not pointers
+	addr := ""
+	switch segment {
+	case "pointer":
+		addr = fmt.Sprint(2 * (3 + index))
+	case "temp":
+		addr = fmt.Sprint(2 * (5 + index))
+	case "static":
+		addr = "2*" + static(cw.Static(index))
+	}
+	switch {
+	case cmd == parser.PUSH && segment == "constant":
+		cw.Printf("movw $%d, %%cx", index)
+		cw.Printf("call vm_push")
+	case cmd == parser.PUSH && addr != "":
+		cw.Printf("movw %s(%%rbx), %%cx", addr)
+		cw.Printf("call vm_push")
+	case cmd == parser.PUSH:
+		cw.Printf("movw %d(%%rbx), %%cx", segments[segment])
+		cw.Printf("addw $%d, %%cx", index)
+		cw.Printf("movw %%cx, 26(%%rbx)")
+		cw.Printf("call vm_read")
+		cw.Printf("call vm_push")
+	case addr != "":
+		cw.Printf("call vm_pop")
+		cw.Printf("movw %%cx, %s(%%rbx)", addr)
+	default:
+		cw.Printf("decw (%%rbx)")
+		cw.Printf("movw %d(%%rbx), %%cx", segments[segment])
+		cw.Printf("addw $%d, %%cx", index)
+		cw.Printf("movw %%cx, 26(%%rbx)")
+		cw.Printf("movw (%%rbx), %%cx")
+		cw.Printf("call vm_read")
+		cw.Printf("movw %%cx, %%dx")
+		cw.Printf("movw 26(%%rbx), %%cx")
+		cw.Printf("call vm_write")
+	}
+}
+
+func (cw *Writer) Goto(name string) {
+	cw.Printf("jmp %s", name)
+}
+
+func (cw *Writer) Halt() {
+	cw.Printf("jmp vm_exit # halt")
+}
+
+func (cw *Writer) If(name string) {
+	cw.Printf("call vm_pop")
+	cw.Printf("testw %%cx, %%cx")
+	cw.Printf("jnz %s", name)
+}
+
+func (cw *Writer) PushZero() {
+	cw.Printf("xorl %%ecx, %%ecx")
+	cw.Printf("call vm_push")
+}
+
+func (cw *Writer) Call(name string, numargs, ret int) {
+	cw.Printf("movw $%d, %%cx", ret)
+	cw.Printf("movw $%d, %%dx", numargs)
+	cw.Printf("call vm_call")
+	cw.Printf("jmp %s", name)
+}
+
+func (cw *Writer) Return() {
+	cw.Printf("call vm_return")
+	cw.Printf("jmp vm_dispatch")
+}
+
+// WriteProgram writes the assembly.
+func (cw *Writer) WriteProgram() error {
+	w := bufio.NewWriter(cw.w)
+	io.WriteString(w, "# Generated by vmt from VM code.\n")
+	for _, v := range cw.Statics() {
+		fmt.Fprintf(w, "\t.set %s, %d\n", static(v.Symbol), v.Address)
+	}
+	io.WriteString(w, prelude)
+	cw.WriteBody(w)
+	io.WriteString(w, "\tjmp vm_exit\n")
+
+	io.WriteString(w, "\n# vm_dispatch jumps to the return address in R14.\nvm_dispatch:\n")
+	if cw.Returned() {
+		io.WriteString(w, "\tmovw 28(%rbx), %ax\n")
+		for _, ret := range cw.Returns() {
+			fmt.Fprintf(w, "\tcmpw $%d, %%ax\n\tje R_%d\n", ret, ret)
+		}
+	}
+	io.WriteString(w, "\tjmp vm_bad\n")
+
+	// jumps to undefined labels and functions go nowhere
+	for _, name := range cw.Missing() {
+		fmt.Fprintf(w, "%s:\n\tjmp vm_bad\n", name)
+	}
+	io.WriteString(w, routines)
+	return w.Flush()
+}
+
+// static returns the symbol of the static variable sym.
+func static(sym string) string {
+	return "S_" + codewriter.Identifier(sym)
+}
+
+const prelude = `
+	.bss
+	.align 16
+	.lcomm ram, 65536       # 32768 words of Hack RAM
+	.lcomm out, 589824      # the RAM dump, at most 18 bytes per word
+	.lcomm digits, 16
+
+	.text
+	.globl _start
+_start:
+	leaq ram(%rip), %rbx
+
+	# set RAM from the arguments address=value
+	movq (%rsp), %r12       # argc
+	leaq 16(%rsp), %r13     # argv[1]
+1:
+	decq %r12
+	jz 3f
+	movq (%r13), %rsi
+	call vm_atoi
+	cmpb $0x3d, (%rsi)
+	jne vm_usage
+	cmpl $32768, %eax
+	jae vm_usage
+	movl %eax, %r14d
+	incq %rsi
+	call vm_atoi
+	cmpb $0, (%rsi)
+	jne vm_usage
+	movw %ax, (%rbx,%r14,2)
+	addq $8, %r13
+	jmp 1b
+3:
+`
+
+const routines = `
+# vm_atoi reads a decimal number, possibly negative, at %rsi into %eax and
+# leaves %rsi after it.
+vm_atoi:
+	xorl %eax, %eax
+	xorl %r8d, %r8d
+	cmpb $0x2d, (%rsi)
+	jne 1f
+	incq %rsi
+	movl $1, %r8d
+1:
+	movzbl (%rsi), %ecx
+	subl $0x30, %ecx
+	cmpl $9, %ecx
+	ja 2f
+	imull $10, %eax
+	addl %ecx, %eax
+	incq %rsi
+	jmp 1b
+2:
+	testl %r8d, %r8d
+	jz 3f
+	negl %eax
+3:
+	ret
+
+# vm_read loads RAM[%cx] into %cx.
+vm_read:
+	movzwl %cx, %eax
+	andl $0x7FFF, %eax
+	movw (%rbx,%rax,2), %cx
+	ret
+
+# vm_write stores %dx in RAM[%cx].
+vm_write:
+	movzwl %cx, %eax
+	andl $0x7FFF, %eax
+	movw %dx, (%rbx,%rax,2)
+	ret
+
+# vm_push pushes %cx.
+vm_push:
+	movzwl (%rbx), %eax
+	andl $0x7FFF, %eax
+	movw %cx, (%rbx,%rax,2)
+	incw (%rbx)
+	ret
+
+# vm_pop pops into %cx.
+vm_pop:
+	decw (%rbx)
+	movw (%rbx), %cx
+	jmp vm_read
+
+# vm_call saves the frame of the caller, with the return address in %cx,
+# and sets up the frame of a callee with %dx arguments.
+vm_call:
+	call vm_push
+	movw 2(%rbx), %cx
+	call vm_push
+	movw 4(%rbx), %cx
+	call vm_push
+	movw 6(%rbx), %cx
+	call vm_push
+	movw 8(%rbx), %cx
+	call vm_push
+	movw (%rbx), %cx
+	subw %dx, %cx
+	subw $5, %cx
+	movw %cx, 4(%rbx)
+	movw (%rbx), %cx
+	movw %cx, 2(%rbx)
+	ret
+
+# vm_return restores the frame of the caller and leaves the return address
+# in R14.
+vm_return:
+	movw 2(%rbx), %cx
+	movw %cx, 26(%rbx)
+	subw $5, %cx
+	call vm_read
+	movw %cx, 28(%rbx)
+	decw (%rbx)
+	movw (%rbx), %cx
+	call vm_read
+	movw %cx, %dx
+	movw 4(%rbx), %cx
+	call vm_write
+	movw 4(%rbx), %cx
+	incw %cx
+	movw %cx, (%rbx)
+	movw 26(%rbx), %cx
+	subw $1, %cx
+	call vm_read
+	movw %cx, 8(%rbx)
+	movw 26(%rbx), %cx
+	subw $2, %cx
+	call vm_read
+	movw %cx, 6(%rbx)
+	movw 26(%rbx), %cx
+	subw $3, %cx
+	call vm_read
+	movw %cx, 4(%rbx)
+	movw 26(%rbx), %cx
+	subw $4, %cx
+	call vm_read
+	movw %cx, 2(%rbx)
+	ret
+
+# vm_itoa writes %eax in decimal at %rdi and leaves %rdi after it.
+vm_itoa:
+	testl %eax, %eax
+	jns 1f
+	movb $0x2d, (%rdi)
+	incq %rdi
+	negl %eax
+1:
+	leaq digits+16(%rip), %r8
+	movq %r8, %r9
+	movl $10, %ecx
+2:
+	xorl %edx, %edx
+	divl %ecx
+	addb $0x30, %dl
+	decq %r8
+	movb %dl, (%r8)
+	testl %eax, %eax
+	jnz 2b
+3:
+	movb (%r8), %al
+	movb %al, (%rdi)
+	incq %rdi
+	incq %r8
+	cmpq %r9, %r8
+	jb 3b
+	ret
+
+# vm_exit prints the RAM words that are not 0 and exits.
+vm_exit:
+	leaq out(%rip), %rdi
+	xorl %esi, %esi
+1:
+	movswl (%rbx,%rsi,2), %r10d
+	testl %r10d, %r10d
+	jz 2f
+	movl $0x5b4d4152, (%rdi)        # RAM[
+	addq $4, %rdi
+	movl %esi, %eax
+	call vm_itoa
+	movw $0x095d, (%rdi)            # ]\t
+	addq $2, %rdi
+	movl %r10d, %eax
+	call vm_itoa
+	movb $0x0a, (%rdi)
+	incq %rdi
+2:
+	incl %esi
+	cmpl $32768, %esi
+	jb 1b
+
+	leaq out(%rip), %rsi
+	movq %rdi, %rdx
+	subq %rsi, %rdx
+3:
+	testq %rdx, %rdx
+	jz 4f
+	movl $1, %edi
+	movl $1, %eax                   # write
+	syscall
+	testq %rax, %rax
+	js 5f
+	addq %rax, %rsi
+	subq %rax, %rdx
+	jmp 3b
+4:
+	xorl %edi, %edi
+	movl $60, %eax                  # exit
+	syscall
+5:
+	movl $1, %edi
+	movl $60, %eax
+	syscall
+
+# vm_bad exits when the program jumps to an address without code.
+vm_bad:
+	leaq bad(%rip), %rsi
+	movl $bad_len, %edx
+	movl $1, %edi
+	jmp vm_fail
+
+vm_usage:
+	leaq usage(%rip), %rsi
+	movl $usage_len, %edx
+	movl $2, %edi
+
+# vm_fail writes the message at %rsi of %rdx bytes and exits with %edi.
+vm_fail:
+	movl %edi, %r12d
+	movl $2, %edi
+	movl $1, %eax                   # write
+	syscall
+	movl %r12d, %edi
+	movl $60, %eax                  # exit
+	syscall
+
+	.section .rodata
+bad:
+	.ascii "jump to an address without code\n"
+	.set bad_len, . - bad
+usage:
+	.ascii "usage: prog [address=value ...]\n"
+	.set usage_len, . - usage
+
+	.section .note.GNU-stack, "", @progbits
+`
@@ -0,0 +1,139 @@
+package x86gen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"vmt/codewriter"
+	"vmt/internal/nativetest"
+	"vmt/parser"
+)
+
+func TestWriter(t *testing.T) {
+	b := bytes.NewBufferString("")
+	cw := New(b, codewriter.Options{})
+	cw.SetFileName("Main")
+	for _, c := range []parser.Command{
+		{Type: parser.PUSH, Arg1: "local", Arg2: 2},
+		{Type: parser.POP, Arg1: "static", Arg2: 1},
+		{Type: parser.ARITHMETIC, Arg1: "gt"},
+		{Type: parser.LABEL, Arg1: "END"},
+		{Type: parser.GOTO, Arg1: "END"},
+	} {
+		codewriter.Dispatch(cw, c)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\t.set S_Main_2e1, 16\n",
+		"\t# push local 2\n\tmovw 2(%rbx), %cx\n\taddw $2, %cx\n\tmovw %cx, 26(%rbx)\n\tcall vm_read\n\tcall vm_push\n",
+		"\tcall vm_pop\n\tmovw %cx, 2*S_Main_2e1(%rbx)\n",
+		"\tsubw %dx, %cx\n\ttestw %cx, %cx\n\tsetg %al\n",
+		"\tjmp vm_exit # halt\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("assembly without %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(b.String(), "L_Main_24END:") {
+		t.Errorf("assembly with unused labels:\n%s", b)
+	}
+}
+
+// link assembles and links files with the GNU binutils, and returns the
+// executable.
+func link(t *testing.T, dir string, files []parser.File, opt codewriter.Options) string {
+	t.Helper()
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("not linux/amd64")
+	}
+	as, err := exec.LookPath("as")
+	if err != nil {
+		t.Skip("no assembler")
+	}
+	ld, err := exec.LookPath("ld")
+	if err != nil {
+		t.Skip("no linker")
+	}
+	b := bytes.NewBufferString("")
+	if err := codewriter.TranslateTo(New(b, opt), files); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "prog.s")
+	if err := ioutil.WriteFile(src, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	obj := filepath.Join(dir, "prog.o")
+	if out, err := exec.Command(as, "--fatal-warnings", "-o", obj, src).CombinedOutput(); err != nil {
+		t.Fatalf("as: %v\n%s", err, out)
+	}
+	exe := filepath.Join(dir, "prog")
+	if out, err := exec.Command(ld, "-static", "-o", exe, obj).CombinedOutput(); err != nil {
+		t.Fatalf("ld: %v\n%s", err, out)
+	}
+	return exe
+}
+
+// The executable must leave the same RAM behind as the Hack code on the
+// emulator, word for word.
+func TestWriter_generated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "x86gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nativetest.Generated(t, 5, func(files []parser.File, opt codewriter.Options) []byte {
+		out, err := exec.Command(link(t, dir, files, opt)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+}
+
+func TestWriter_arguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "x86gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// RAM[2] = RAM[0] - RAM[1], with a jump to a label without code
+	files := []parser.File{{Name: "Main", Commands: []parser.Command{
+		{Type: parser.PUSH, Arg1: "temp", Arg2: 0},
+		{Type: parser.PUSH, Arg1: "temp", Arg2: 1},
+		{Type: parser.ARITHMETIC, Arg1: "sub"},
+		{Type: parser.POP, Arg1: "temp", Arg2: 2},
+		{Type: parser.PUSH, Arg1: "temp", Arg2: 3},
+		{Type: parser.IF, Arg1: "NOWHERE"},
+	}}}
+	exe := link(t, dir, files, codewriter.Options{})
+	for _, tt := range []struct {
+		args []string
+		out  string
+		code int
+	}{
+		{[]string{"0=256", "5=3", "6=-32768"}, "RAM[0]\t256\nRAM[5]\t3\nRAM[6]\t-32768\nRAM[7]\t-32765\nRAM[257]\t-32768\n", 0},
+		{[]string{"0=256", "8=1"}, "", 1},
+		{[]string{"32768=1"}, "", 2},
+		{[]string{"5"}, "", 2},
+	} {
+		out, err := exec.Command(exe, tt.args...).Output()
+		code := 0
+		if e, ok := err.(*exec.ExitError); ok {
+			code = e.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.out || code != tt.code {
+			t.Errorf("%v: got %q and exit code %d, want %q and %d", tt.args, out, code, tt.out, tt.code)
+		}
+	}
+}